@@ -0,0 +1,139 @@
+// Package arp implements ARP from RFC826 for IPv4 over Ethernet: message
+// encoding and neighbor cache used by ipv4.IpSocket to resolve hardware
+// addresses of on-link destinations
+package arp
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+)
+
+// EtherType of ARP frames
+const EtherType = 0x0806
+
+// Operation codes
+const (
+	OpRequest = 1
+	OpReply   = 2
+)
+
+const (
+	hardwareEthernet = 1
+	protocolIPv4     = 0x0800
+
+	hardwareLength = 6
+	protocolLength = 4
+
+	packetLength = 8 + 2*hardwareLength + 2*protocolLength
+)
+
+var (
+	ErrTruncated   = errors.New("arp packet is truncated")
+	ErrUnsupported = errors.New("arp packet is not ethernet ipv4 one")
+)
+
+// Broadcast is hardware address of all hosts on link
+var Broadcast = net.HardwareAddr{0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF}
+
+// 0                   1                   2                   3
+// 0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1
+// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+// |         Hardware Type         |         Protocol Type         |
+// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+// |  HW Length    | Proto Length  |           Operation           |
+// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+// |                Sender Hardware Address (6 octets)             |
+// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+// |          Sender Protocol Address (4 octets)                   |
+// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+// |                Target Hardware Address (6 octets)             |
+// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+// |          Target Protocol Address (4 octets)                   |
+// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+//
+//	ARP packet for Ethernet hardware and IPv4 protocol addresses
+type Packet struct {
+	Op       uint16
+	SenderHW net.HardwareAddr
+	SenderIP [4]byte
+	TargetHW net.HardwareAddr
+	TargetIP [4]byte
+}
+
+// NewRequest creates request of hardware address of target
+func NewRequest(hw net.HardwareAddr, ip, target [4]byte) *Packet {
+	return &Packet{
+		Op:       OpRequest,
+		SenderHW: hw,
+		SenderIP: ip,
+		TargetHW: make(net.HardwareAddr, hardwareLength),
+		TargetIP: target,
+	}
+}
+
+// NewGratuitous creates request announcing hardware address of ip, sender
+// and target protocol addresses are the same
+func NewGratuitous(hw net.HardwareAddr, ip [4]byte) *Packet {
+	return NewRequest(hw, ip, ip)
+}
+
+// ReplyTo creates reply to request with hardware address hw
+func (p *Packet) ReplyTo(hw net.HardwareAddr) *Packet {
+	return &Packet{
+		Op:       OpReply,
+		SenderHW: hw,
+		SenderIP: p.TargetIP,
+		TargetHW: p.SenderHW,
+		TargetIP: p.SenderIP,
+	}
+}
+
+// IsGratuitous reports whether packet announces address of sender
+func (p *Packet) IsGratuitous() bool {
+	return p.SenderIP == p.TargetIP
+}
+
+// Marshal returns wire representation of packet
+func (p *Packet) Marshal() []byte {
+	buf := make([]byte, packetLength)
+
+	binary.BigEndian.PutUint16(buf[0:2], hardwareEthernet)
+	binary.BigEndian.PutUint16(buf[2:4], protocolIPv4)
+	buf[4] = hardwareLength
+	buf[5] = protocolLength
+	binary.BigEndian.PutUint16(buf[6:8], p.Op)
+	copy(buf[8:14], p.SenderHW)
+	copy(buf[14:18], p.SenderIP[:])
+	copy(buf[18:24], p.TargetHW)
+	copy(buf[24:28], p.TargetIP[:])
+
+	return buf
+}
+
+// Unmarshal parses packet from b, padding of frame after packet is ignored
+func (p *Packet) Unmarshal(b []byte) error {
+	if len(b) < 8 {
+		return fmt.Errorf("%w: got %d bytes", ErrTruncated, len(b))
+	}
+
+	htype := binary.BigEndian.Uint16(b[0:2])
+	ptype := binary.BigEndian.Uint16(b[2:4])
+
+	if htype != hardwareEthernet || ptype != protocolIPv4 || b[4] != hardwareLength || b[5] != protocolLength {
+		return fmt.Errorf("%w: hardware %d protocol %#x", ErrUnsupported, htype, ptype)
+	}
+
+	if len(b) < packetLength {
+		return fmt.Errorf("%w: got %d bytes", ErrTruncated, len(b))
+	}
+
+	p.Op = binary.BigEndian.Uint16(b[6:8])
+	p.SenderHW = net.HardwareAddr(append([]byte{}, b[8:14]...))
+	copy(p.SenderIP[:], b[14:18])
+	p.TargetHW = net.HardwareAddr(append([]byte{}, b[18:24]...))
+	copy(p.TargetIP[:], b[24:28])
+
+	return nil
+}
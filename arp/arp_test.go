@@ -0,0 +1,104 @@
+package arp
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"testing"
+)
+
+var (
+	senderHW = net.HardwareAddr{0x02, 0, 0, 0, 0, 0x01}
+	targetHW = net.HardwareAddr{0x02, 0, 0, 0, 0, 0x02}
+	senderIP = [4]byte{192, 168, 0, 1}
+	targetIP = [4]byte{192, 168, 0, 2}
+)
+
+var request = []byte{
+	0x00, 0x01, 0x08, 0x00, 0x06, 0x04, 0x00, 0x01,
+	0x02, 0, 0, 0, 0, 0x01, 192, 168, 0, 1,
+	0, 0, 0, 0, 0, 0, 192, 168, 0, 2,
+}
+
+func Test_Packet_Marshal(t *testing.T) {
+	data := NewRequest(senderHW, senderIP, targetIP).Marshal()
+	if !bytes.Equal(data, request) {
+		t.Errorf("Marshaled not expected %v", data)
+	}
+}
+
+func Test_Packet_Unmarshal(t *testing.T) {
+	unsupported := append([]byte{}, request...)
+	unsupported[3] = 0xDD
+
+	tests := []struct {
+		name     string
+		input    []byte
+		expected error
+	}{
+		{
+			name:  "Request",
+			input: request,
+		},
+		{
+			name:  "Padded frame",
+			input: append(append([]byte{}, request...), make([]byte, 18)...),
+		},
+		{
+			name:     "Truncated",
+			input:    request[:27],
+			expected: ErrTruncated,
+		},
+		{
+			name:     "Truncated header",
+			input:    request[:7],
+			expected: ErrTruncated,
+		},
+		{
+			name:     "Not IPv4",
+			input:    unsupported,
+			expected: ErrUnsupported,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p := &Packet{}
+
+			err := p.Unmarshal(test.input)
+			if !errors.Is(err, test.expected) {
+				t.Fatalf("Error not expected %v", err)
+			}
+
+			if err != nil {
+				return
+			}
+
+			if p.Op != OpRequest || !bytes.Equal(p.SenderHW, senderHW) || p.SenderIP != senderIP || p.TargetIP != targetIP {
+				t.Errorf("Packet not expected %v", p)
+			}
+		})
+	}
+}
+
+func Test_Packet_ReplyTo(t *testing.T) {
+	reply := NewRequest(senderHW, senderIP, targetIP).ReplyTo(targetHW)
+
+	if reply.Op != OpReply || reply.SenderIP != targetIP || reply.TargetIP != senderIP {
+		t.Errorf("Reply not expected %v", reply)
+	}
+
+	if !bytes.Equal(reply.SenderHW, targetHW) || !bytes.Equal(reply.TargetHW, senderHW) {
+		t.Errorf("Reply addresses not expected %v %v", reply.SenderHW, reply.TargetHW)
+	}
+}
+
+func Test_Packet_IsGratuitous(t *testing.T) {
+	if !NewGratuitous(senderHW, senderIP).IsGratuitous() {
+		t.Error("Gratuitous packet not detected")
+	}
+
+	if NewRequest(senderHW, senderIP, targetIP).IsGratuitous() {
+		t.Error("Request detected as gratuitous")
+	}
+}
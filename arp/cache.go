@@ -0,0 +1,271 @@
+package arp
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	defaultReachableTime = 30 * time.Second
+	defaultStaleTime     = 60 * time.Second
+	defaultRetransTime   = time.Second
+	defaultMaxRequests   = 3
+	defaultQueueLength   = 3
+)
+
+// ErrUnresolved is returned when neighbor does not answer requests
+var ErrUnresolved = errors.New("hardware address is not resolved")
+
+// State is state of neighbor cache entry
+type State int
+
+const (
+	// StateIncomplete entry waits for reply to request
+	StateIncomplete State = iota
+	// StateReachable entry was confirmed recently
+	StateReachable
+	// StateStale entry is used, but it is confirmed again on the next use
+	StateStale
+)
+
+func (s State) String() string {
+	switch s {
+	case StateIncomplete:
+		return "INCOMPLETE"
+	case StateReachable:
+		return "REACHABLE"
+	case StateStale:
+		return "STALE"
+	}
+
+	return fmt.Sprintf("state(%d)", int(s))
+}
+
+// Config describes aging of cache entries, zero fields are replaced by
+// defaults: entries are reachable for 30 seconds, stale ones are removed
+// after a minute, 3 requests are sent every second to resolve address
+type Config struct {
+	ReachableTime time.Duration // time entry is reachable after confirmation
+	StaleTime     time.Duration // time stale entry is kept without confirmation
+	RetransTime   time.Duration // time between requests
+	MaxRequests   int           // requests sent before resolution fails
+	QueueLength   int           // payloads held per incomplete entry
+}
+
+// Neighbor is snapshot of cache entry
+type Neighbor struct {
+	IP      [4]byte
+	HW      net.HardwareAddr
+	State   State
+	Updated time.Time // time of the last confirmation
+}
+
+type entry struct {
+	hw        net.HardwareAddr
+	state     State
+	updated   time.Time
+	requested time.Time
+	requests  int
+	queue     [][]byte
+}
+
+// Cache keeps hardware addresses of neighbors, it is safe for concurrent use
+type Cache struct {
+	cfg Config
+
+	mu      sync.Mutex
+	entries map[[4]byte]*entry
+	expired time.Time // time of the last expiration
+}
+
+// NewCache creates neighbor cache with aging from cfg
+func NewCache(cfg Config) *Cache {
+	if cfg.ReachableTime <= 0 {
+		cfg.ReachableTime = defaultReachableTime
+	}
+
+	if cfg.StaleTime <= 0 {
+		cfg.StaleTime = defaultStaleTime
+	}
+
+	if cfg.RetransTime <= 0 {
+		cfg.RetransTime = defaultRetransTime
+	}
+
+	if cfg.MaxRequests <= 0 {
+		cfg.MaxRequests = defaultMaxRequests
+	}
+
+	if cfg.QueueLength <= 0 {
+		cfg.QueueLength = defaultQueueLength
+	}
+
+	return &Cache{
+		cfg:     cfg,
+		entries: make(map[[4]byte]*entry),
+	}
+}
+
+// Resolve returns hardware address of ip. Payload sent to unresolved address
+// is queued until reply is received (see Update), the oldest payload is
+// dropped when queue is full. Request reports whether ARP request has to be
+// sent now: to resolve new or incomplete entry or to confirm stale one, stale
+// address is used while it is confirmed. ErrUnresolved is returned when all
+// requests are sent without reply, entry and its queue are dropped then.
+// Expired entries are removed once per StaleTime (see Expire).
+func (c *Cache) Resolve(ip [4]byte, payload []byte, now time.Time) (hw net.HardwareAddr, request bool, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if now.Sub(c.expired) >= c.cfg.StaleTime {
+		c.expire(now)
+	}
+
+	e, ok := c.entries[ip]
+	if !ok {
+		e = &entry{state: StateIncomplete}
+		c.entries[ip] = e
+	}
+
+	c.age(e, now)
+
+	if e.state == StateReachable {
+		return e.hw, false, nil
+	}
+
+	if e.requests == 0 || now.Sub(e.requested) >= c.cfg.RetransTime {
+		if e.requests >= c.cfg.MaxRequests {
+			delete(c.entries, ip)
+			return nil, false, fmt.Errorf("%w: %v", ErrUnresolved, net.IP(ip[:]))
+		}
+
+		e.requests++
+		e.requested = now
+		request = true
+	}
+
+	if e.state == StateStale {
+		return e.hw, request, nil
+	}
+
+	if payload != nil {
+		if len(e.queue) == c.cfg.QueueLength {
+			e.queue = e.queue[1:]
+		}
+
+		e.queue = append(e.queue, payload)
+	}
+
+	return nil, request, nil
+}
+
+// Update confirms hardware address of ip and returns payloads queued while
+// it was resolved. Following RFC826 existing entry is always updated, new one
+// is created only when create is set, e.g. when local host is target of
+// received packet.
+func (c *Cache) Update(ip [4]byte, hw net.HardwareAddr, create bool, now time.Time) [][]byte {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[ip]
+	if !ok {
+		if !create {
+			return nil
+		}
+
+		e = &entry{}
+		c.entries[ip] = e
+	}
+
+	queue := e.queue
+
+	e.hw = append(net.HardwareAddr{}, hw...)
+	e.state = StateReachable
+	e.updated = now
+	e.requests = 0
+	e.queue = nil
+
+	return queue
+}
+
+// Lookup returns entry of ip without sending payload
+func (c *Cache) Lookup(ip [4]byte, now time.Time) (Neighbor, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[ip]
+	if !ok {
+		return Neighbor{}, false
+	}
+
+	c.age(e, now)
+
+	return neighbor(ip, e), true
+}
+
+// Remove deletes entry of ip with queued payloads
+func (c *Cache) Remove(ip [4]byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.entries, ip)
+}
+
+// Neighbors returns snapshot of all entries
+func (c *Cache) Neighbors(now time.Time) []Neighbor {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	neighbors := make([]Neighbor, 0, len(c.entries))
+
+	for ip, e := range c.entries {
+		c.age(e, now)
+		neighbors = append(neighbors, neighbor(ip, e))
+	}
+
+	return neighbors
+}
+
+// Expire removes stale entries not confirmed for StaleTime and entries whose
+// requests are not answered, it returns count of removed entries
+func (c *Cache) Expire(now time.Time) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.expire(now)
+}
+
+func (c *Cache) expire(now time.Time) int {
+	c.expired = now
+	removed := 0
+
+	for ip, e := range c.entries {
+		c.age(e, now)
+
+		switch {
+		case e.state == StateStale && now.Sub(e.updated) >= c.cfg.ReachableTime+c.cfg.StaleTime:
+		case e.state != StateReachable && e.requests >= c.cfg.MaxRequests && now.Sub(e.requested) >= c.cfg.RetransTime:
+		default:
+			continue
+		}
+
+		delete(c.entries, ip)
+		removed++
+	}
+
+	return removed
+}
+
+// age turns reachable entry to stale when it is not confirmed in time
+func (c *Cache) age(e *entry, now time.Time) {
+	if e.state == StateReachable && now.Sub(e.updated) >= c.cfg.ReachableTime {
+		e.state = StateStale
+	}
+}
+
+func neighbor(ip [4]byte, e *entry) Neighbor {
+	return Neighbor{IP: ip, HW: e.hw, State: e.state, Updated: e.updated}
+}
@@ -0,0 +1,182 @@
+package arp
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func Test_Cache_Resolve(t *testing.T) {
+	c := NewCache(Config{MaxRequests: 2, QueueLength: 2})
+	now := time.Now()
+
+	tests := []struct {
+		name    string
+		after   time.Duration
+		payload byte
+		request bool
+		err     error
+	}{
+		{name: "First request", request: true, payload: 1},
+		{name: "Waiting reply", after: 500 * time.Millisecond, payload: 2},
+		{name: "Second request", after: time.Second, request: true, payload: 3},
+		{name: "Requests exhausted", after: 2 * time.Second, err: ErrUnresolved},
+		{name: "Request again", after: 2 * time.Second, request: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			hw, request, err := c.Resolve(targetIP, []byte{test.payload}, now.Add(test.after))
+			if !errors.Is(err, test.err) {
+				t.Fatalf("Error not expected %v", err)
+			}
+
+			if hw != nil || request != test.request {
+				t.Errorf("Resolution not expected %v %v", hw, request)
+			}
+		})
+	}
+
+	c.Resolve(targetIP, []byte{4}, now.Add(2*time.Second))
+	c.Resolve(targetIP, []byte{5}, now.Add(2*time.Second))
+
+	queue := c.Update(targetIP, targetHW, false, now.Add(3*time.Second))
+	if len(queue) != 2 || queue[0][0] != 4 || queue[1][0] != 5 {
+		t.Errorf("Queue not expected %v", queue)
+	}
+
+	hw, request, err := c.Resolve(targetIP, []byte{6}, now.Add(3*time.Second))
+	if err != nil || request || !bytes.Equal(hw, targetHW) {
+		t.Errorf("Resolution not expected %v %v %v", hw, request, err)
+	}
+}
+
+func Test_Cache_Update(t *testing.T) {
+	c := NewCache(Config{})
+	now := time.Now()
+
+	if queue := c.Update(senderIP, senderHW, false, now); queue != nil {
+		t.Errorf("Queue not expected %v", queue)
+	}
+
+	if _, ok := c.Lookup(senderIP, now); ok {
+		t.Error("Entry created without create")
+	}
+
+	c.Update(senderIP, senderHW, true, now)
+
+	n, ok := c.Lookup(senderIP, now)
+	if !ok || n.State != StateReachable || !bytes.Equal(n.HW, senderHW) {
+		t.Errorf("Neighbor not expected %v", n)
+	}
+
+	c.Update(senderIP, targetHW, false, now)
+
+	if n, _ := c.Lookup(senderIP, now); !bytes.Equal(n.HW, targetHW) {
+		t.Errorf("Hardware address not updated %v", n.HW)
+	}
+}
+
+func Test_Cache_Aging(t *testing.T) {
+	c := NewCache(Config{ReachableTime: time.Second, StaleTime: time.Second})
+	now := time.Now()
+
+	c.Update(senderIP, senderHW, true, now)
+
+	n, _ := c.Lookup(senderIP, now.Add(time.Second))
+	if n.State != StateStale {
+		t.Errorf("State not expected %v", n.State)
+	}
+
+	hw, request, err := c.Resolve(senderIP, nil, now.Add(time.Second))
+	if err != nil || !request || !bytes.Equal(hw, senderHW) {
+		t.Errorf("Stale resolution not expected %v %v %v", hw, request, err)
+	}
+
+	if _, request, _ := c.Resolve(senderIP, nil, now.Add(time.Second)); request {
+		t.Error("Stale entry requested twice")
+	}
+
+	if removed := c.Expire(now.Add(1500 * time.Millisecond)); removed != 0 {
+		t.Errorf("Removed not expected %d", removed)
+	}
+
+	if removed := c.Expire(now.Add(2 * time.Second)); removed != 1 {
+		t.Errorf("Removed not expected %d", removed)
+	}
+
+	if neighbors := c.Neighbors(now); len(neighbors) != 0 {
+		t.Errorf("Neighbors not expected %v", neighbors)
+	}
+}
+
+func Test_Cache_Expire_Incomplete(t *testing.T) {
+	c := NewCache(Config{MaxRequests: 1})
+	now := time.Now()
+
+	c.Resolve(targetIP, nil, now)
+
+	if removed := c.Expire(now); removed != 0 {
+		t.Errorf("Removed not expected %d", removed)
+	}
+
+	if removed := c.Expire(now.Add(time.Second)); removed != 1 {
+		t.Errorf("Removed not expected %d", removed)
+	}
+}
+
+func Test_State_String(t *testing.T) {
+	if StateStale.String() != "STALE" || State(7).String() != "state(7)" {
+		t.Errorf("States not expected %v %v", StateStale, State(7))
+	}
+}
+
+func Test_Cache_Resolve_StaleUnanswered(t *testing.T) {
+	c := NewCache(Config{ReachableTime: time.Second, StaleTime: time.Minute, MaxRequests: 2})
+	now := time.Now()
+
+	c.Update(targetIP, targetHW, true, now)
+
+	tests := []struct {
+		name    string
+		after   time.Duration
+		hw      net.HardwareAddr
+		request bool
+		err     error
+	}{
+		{name: "First confirmation", after: time.Second, hw: targetHW, request: true},
+		{name: "Waiting reply", after: 1500 * time.Millisecond, hw: targetHW},
+		{name: "Second confirmation", after: 2 * time.Second, hw: targetHW, request: true},
+		{name: "Requests exhausted", after: 3 * time.Second, err: ErrUnresolved},
+		{name: "Resolved again", after: 3 * time.Second, request: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			hw, request, err := c.Resolve(targetIP, nil, now.Add(test.after))
+			if !errors.Is(err, test.err) {
+				t.Fatalf("Error not expected %v", err)
+			}
+
+			if !bytes.Equal(hw, test.hw) || request != test.request {
+				t.Errorf("Resolution not expected %v %v", hw, request)
+			}
+		})
+	}
+}
+
+func Test_Cache_Resolve_Expires(t *testing.T) {
+	c := NewCache(Config{ReachableTime: time.Second, StaleTime: time.Second})
+	now := time.Now()
+
+	c.Resolve(targetIP, nil, now)
+	c.Update(senderIP, senderHW, true, now)
+
+	c.Resolve(targetIP, nil, now.Add(2*time.Second))
+
+	if _, ok := c.Lookup(senderIP, now.Add(2*time.Second)); ok {
+		t.Error("Stale entry not expired")
+	}
+}
@@ -0,0 +1,153 @@
+package ipv4
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+)
+
+// Matcher selects packets for handler
+type Matcher func(p *Packet) bool
+
+// MatchProtocol selects packets of upper layer protocol
+func MatchProtocol(protocol uint8) Matcher {
+	return func(p *Packet) bool {
+		return p.Protocol == protocol
+	}
+}
+
+// MatchDestination selects packets sent to dst
+func MatchDestination(dst IPAddr) Matcher {
+	return func(p *Packet) bool {
+		return p.Dst == dst
+	}
+}
+
+type dispatchRoute struct {
+	match   Matcher
+	handler PacketHandler
+}
+
+// Dispatcher reads socket in a single loop and passes every packet to all
+// handlers whose matcher selects it. Handlers are called from the loop one
+// by one, so they must not block and must not change the packet; handlers
+// may be registered and removed from any goroutine.
+type Dispatcher struct {
+	sock *IpSocket
+
+	mu     sync.RWMutex
+	routes map[uint64]dispatchRoute
+	nextID uint64
+	dflt   PacketHandler
+
+	unhandled atomic.Uint64
+}
+
+// NewDispatcher creates dispatcher of packets read from sock
+func NewDispatcher(sock *IpSocket) *Dispatcher {
+	return &Dispatcher{
+		sock:   sock,
+		routes: make(map[uint64]dispatchRoute),
+	}
+}
+
+// Handle registers handler for packets selected by m, returned function
+// removes registration
+func (d *Dispatcher) Handle(m Matcher, h PacketHandler) func() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	id := d.nextID
+	d.nextID++
+
+	d.routes[id] = dispatchRoute{match: m, handler: h}
+
+	return func() {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+
+		delete(d.routes, id)
+	}
+}
+
+// HandleProtocol registers handler for packets of upper layer protocol
+func (d *Dispatcher) HandleProtocol(protocol uint8, h PacketHandler) func() {
+	return d.Handle(MatchProtocol(protocol), h)
+}
+
+// HandleDestination registers handler for packets sent to dst
+func (d *Dispatcher) HandleDestination(dst IPAddr, h PacketHandler) func() {
+	return d.Handle(MatchDestination(dst), h)
+}
+
+// HandleDefault registers handler for packets not selected by any other,
+// nil handler drops such packets reporting ReasonProtocolUnreachable for
+// ones addressed to socket (see IpSocket.SetErrorReporter)
+func (d *Dispatcher) HandleDefault(h PacketHandler) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.dflt = h
+}
+
+// Unhandled returns count of packets dropped because no handler selected them
+func (d *Dispatcher) Unhandled() uint64 {
+	return d.unhandled.Load()
+}
+
+// Socket returns socket packets are read from, it may be used for writing
+func (d *Dispatcher) Socket() *IpSocket {
+	return d.sock
+}
+
+// Run reads packets and dispatches them until read fails or ctx is done.
+// Context is checked between packets, so Run returns after the next packet
+// is read.
+func (d *Dispatcher) Run(ctx context.Context) error {
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		p, err := d.sock.ReadPacket()
+		if err != nil {
+			return err
+		}
+
+		d.Dispatch(p)
+	}
+}
+
+// Dispatch passes packet to handlers selecting it. Handlers are called
+// without lock held, so they may register and remove handlers.
+func (d *Dispatcher) Dispatch(p *Packet) {
+	d.mu.RLock()
+
+	var handlers []PacketHandler
+
+	for _, r := range d.routes {
+		if r.match(p) {
+			handlers = append(handlers, r.handler)
+		}
+	}
+
+	if len(handlers) == 0 && d.dflt != nil {
+		handlers = append(handlers, d.dflt)
+	}
+
+	d.mu.RUnlock()
+
+	if len(handlers) == 0 {
+		d.unhandled.Add(1)
+
+		if d.sock != nil && p.Dst == d.sock.GetIpAddr() {
+			d.sock.reportError(ReasonProtocolUnreachable, p)
+		}
+
+		return
+	}
+
+	for _, h := range handlers {
+		h(p)
+	}
+}
@@ -0,0 +1,132 @@
+package ipv4
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/IvMaslov/ethernet"
+)
+
+func Test_Dispatcher_Dispatch(t *testing.T) {
+	local, _ := IPFromString("10.0.0.1")
+	other, _ := IPFromString("10.0.0.2")
+
+	d := NewDispatcher(nil)
+
+	var icmp, udp, toLocal, custom, dflt int
+
+	d.HandleProtocol(ProtocolICMP, func(p *Packet) { icmp++ })
+	removeUDP := d.HandleProtocol(ProtocolUDP, func(p *Packet) { udp++ })
+	d.HandleDestination(local, func(p *Packet) { toLocal++ })
+	d.Handle(func(p *Packet) bool { return p.TTL == 1 }, func(p *Packet) { custom++ })
+
+	d.Dispatch(New(other, local, nil).WithProtocol(ProtocolICMP))
+	d.Dispatch(New(local, other, nil).WithProtocol(ProtocolUDP))
+	d.Dispatch(New(local, other, nil).WithProtocol(ProtocolTCP))
+
+	expire := New(local, other, nil)
+	expire.TTL = 1
+	d.Dispatch(expire)
+
+	if icmp != 1 || udp != 1 || toLocal != 1 || custom != 1 {
+		t.Errorf("Handled not expected icmp %d udp %d local %d custom %d", icmp, udp, toLocal, custom)
+	}
+
+	if d.Unhandled() != 1 {
+		t.Errorf("Unhandled not expected %d", d.Unhandled())
+	}
+
+	removeUDP()
+	d.HandleDefault(func(p *Packet) { dflt++ })
+
+	d.Dispatch(New(local, other, nil).WithProtocol(ProtocolUDP))
+
+	if udp != 1 || dflt != 1 {
+		t.Errorf("Handled not expected udp %d default %d", udp, dflt)
+	}
+}
+
+// ipFrames returns frames carrying marshaled packets
+func ipFrames(packets ...*Packet) []frame {
+	framed := make([]frame, 0, len(packets))
+
+	for _, p := range packets {
+		framed = append(framed, frame{etherType: uint16(ethernet.EtherTypeIPv4), payload: p.Marshal()})
+	}
+
+	return framed
+}
+
+func Test_Dispatcher_Run(t *testing.T) {
+	local, _ := IPFromString("192.168.0.1")
+	other, _ := IPFromString("192.168.0.2")
+
+	t.Run("Read error", func(t *testing.T) {
+		is, _ := arpSocket(t, "eth0", "192.168.0.1", 24)
+		is.recv = frames(ipFrames(New(other, local, nil), New(other, local, nil))...)
+
+		d := NewDispatcher(is)
+
+		handled := 0
+		d.HandleDefault(func(p *Packet) { handled++ })
+
+		if err := d.Run(context.Background()); !errors.Is(err, io.EOF) {
+			t.Errorf("Error not expected %v", err)
+		}
+
+		if handled != 2 {
+			t.Errorf("Handled not expected %d", handled)
+		}
+	})
+
+	t.Run("Context done", func(t *testing.T) {
+		is, _ := arpSocket(t, "eth0", "192.168.0.1", 24)
+		is.recv = frames(ipFrames(New(other, local, nil), New(other, local, nil))...)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		d := NewDispatcher(is)
+
+		handled := 0
+		d.HandleDefault(func(p *Packet) {
+			handled++
+			cancel()
+		})
+
+		if err := d.Run(ctx); !errors.Is(err, context.Canceled) {
+			t.Errorf("Error not expected %v", err)
+		}
+
+		if handled != 1 {
+			t.Errorf("Handled not expected %d", handled)
+		}
+	})
+}
+
+func Test_Dispatcher_Dispatch_ProtocolUnreachable(t *testing.T) {
+	local, _ := IPFromString("192.168.0.1")
+	other, _ := IPFromString("192.168.0.2")
+
+	is, _ := arpSocket(t, "eth0", "192.168.0.1", 24)
+
+	errs := &errorRecorder{}
+	is.SetErrorReporter(errs)
+
+	d := NewDispatcher(is)
+	d.HandleProtocol(ProtocolICMP, func(p *Packet) {})
+
+	d.Dispatch(New(other, local, nil).WithProtocol(ProtocolICMP))
+	d.Dispatch(New(other, local, nil).WithProtocol(ProtocolUDP))
+	d.Dispatch(New(local, other, nil).WithProtocol(ProtocolUDP))
+
+	if len(errs.errors) != 1 || errs.errors[0].reason != ReasonProtocolUnreachable {
+		t.Errorf("Reported not expected %v", errs.errors)
+	}
+
+	if d.Unhandled() != 2 {
+		t.Errorf("Unhandled not expected %d", d.Unhandled())
+	}
+}
@@ -0,0 +1,163 @@
+package ipv4
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Type of Service octet is split into DSCP and ECN fields, RFC2474 and RFC3168
+// -0-1-2-3-4-5-6-7-
+// |   DSCP    |ECN|
+type DSCP uint8
+
+// Code points of Class Selector (RFC2474), Assured Forwarding (RFC2597) and
+// Expedited Forwarding (RFC3246) per-hop behaviors
+const (
+	DSCPCS0  DSCP = 0
+	DSCPCS1  DSCP = 8
+	DSCPAF11 DSCP = 10
+	DSCPAF12 DSCP = 12
+	DSCPAF13 DSCP = 14
+	DSCPCS2  DSCP = 16
+	DSCPAF21 DSCP = 18
+	DSCPAF22 DSCP = 20
+	DSCPAF23 DSCP = 22
+	DSCPCS3  DSCP = 24
+	DSCPAF31 DSCP = 26
+	DSCPAF32 DSCP = 28
+	DSCPAF33 DSCP = 30
+	DSCPCS4  DSCP = 32
+	DSCPAF41 DSCP = 34
+	DSCPAF42 DSCP = 36
+	DSCPAF43 DSCP = 38
+	DSCPCS5  DSCP = 40
+	DSCPEF   DSCP = 46
+	DSCPCS6  DSCP = 48
+	DSCPCS7  DSCP = 56
+
+	maxDSCP = 63
+)
+
+var dscpNames = map[DSCP]string{
+	DSCPCS0: "CS0", DSCPCS1: "CS1", DSCPCS2: "CS2", DSCPCS3: "CS3",
+	DSCPCS4: "CS4", DSCPCS5: "CS5", DSCPCS6: "CS6", DSCPCS7: "CS7",
+	DSCPAF11: "AF11", DSCPAF12: "AF12", DSCPAF13: "AF13",
+	DSCPAF21: "AF21", DSCPAF22: "AF22", DSCPAF23: "AF23",
+	DSCPAF31: "AF31", DSCPAF32: "AF32", DSCPAF33: "AF33",
+	DSCPAF41: "AF41", DSCPAF42: "AF42", DSCPAF43: "AF43",
+	DSCPEF: "EF",
+}
+
+func (d DSCP) String() string {
+	if name, ok := dscpNames[d]; ok {
+		return name
+	}
+
+	return fmt.Sprintf("DSCP(%d)", uint8(d))
+}
+
+// AF returns Assured Forwarding code point of class 1-4 with drop
+// precedence 1-3
+func AF(class, drop int) (DSCP, error) {
+	if class < 1 || class > 4 || drop < 1 || drop > 3 {
+		return 0, fmt.Errorf("wrong assured forwarding class %d or drop precedence %d", class, drop)
+	}
+
+	return DSCP(class<<3 | drop<<1), nil
+}
+
+// CS returns Class Selector code point of precedence 0-7
+func CS(precedence int) (DSCP, error) {
+	if precedence < 0 || precedence > 7 {
+		return 0, fmt.Errorf("wrong class selector precedence %d", precedence)
+	}
+
+	return DSCP(precedence << 3), nil
+}
+
+// ECN is Explicit Congestion Notification field from RFC3168
+type ECN uint8
+
+const (
+	ECNNotECT ECN = 0 // transport is not ECN capable
+	ECNECT1   ECN = 1 // ECN capable transport
+	ECNECT0   ECN = 2 // ECN capable transport
+	ECNCE     ECN = 3 // congestion experienced
+)
+
+// DSCP returns differentiated services code point of packet
+func (p *Packet) DSCP() DSCP {
+	return DSCP(p.TOS >> 2)
+}
+
+// SetDSCP sets differentiated services code point, ECN field is not changed
+func (p *Packet) SetDSCP(d DSCP) error {
+	if d > maxDSCP {
+		return fmt.Errorf("wrong dscp %d", d)
+	}
+
+	p.TOS = uint8(d)<<2 | p.TOS&3
+
+	return nil
+}
+
+// ECN returns explicit congestion notification field of packet
+func (p *Packet) ECN() ECN {
+	return ECN(p.TOS & 3)
+}
+
+// SetECN sets explicit congestion notification field, DSCP is not changed
+func (p *Packet) SetECN(e ECN) {
+	p.TOS = p.TOS&^3 | uint8(e)&3
+}
+
+// ECNCapable reports whether packet is sent by ECN capable transport
+func (p *Packet) ECNCapable() bool {
+	return p.ECN() != ECNNotECT
+}
+
+// MarkCE marks packet of ECN capable transport with Congestion Experienced
+// instead of dropping it. False is returned for not capable transport,
+// such packet should be dropped.
+func (p *Packet) MarkCE() bool {
+	if !p.ECNCapable() {
+		return false
+	}
+
+	p.SetECN(ECNCE)
+
+	return true
+}
+
+// WithDSCP sets differentiated services code point, wrong code point is
+// truncated to 6 bits
+func (p *Packet) WithDSCP(d DSCP) *Packet {
+	p.SetDSCP(d & maxDSCP)
+
+	return p
+}
+
+// WithECN sets explicit congestion notification field
+func (p *Packet) WithECN(e ECN) *Packet {
+	p.SetECN(e)
+
+	return p
+}
+
+// dscpPolicy keeps default code points of socket
+type dscpPolicy struct {
+	mu      sync.RWMutex
+	dflt    DSCP
+	perDest map[IPAddr]DSCP
+}
+
+func (d *dscpPolicy) lookup(dst IPAddr) DSCP {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	if dscp, ok := d.perDest[dst]; ok {
+		return dscp
+	}
+
+	return d.dflt
+}
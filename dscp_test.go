@@ -0,0 +1,174 @@
+package ipv4
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_Packet_DSCP(t *testing.T) {
+	tests := []struct {
+		name     string
+		tos      uint8
+		dscp     DSCP
+		ecn      ECN
+		expected string
+	}{
+		{
+			name:     "Best effort",
+			tos:      0,
+			dscp:     DSCPCS0,
+			ecn:      ECNNotECT,
+			expected: "CS0",
+		},
+		{
+			name:     "Expedited forwarding",
+			tos:      0xB8,
+			dscp:     DSCPEF,
+			ecn:      ECNNotECT,
+			expected: "EF",
+		},
+		{
+			name:     "Assured forwarding with congestion",
+			tos:      0x33,
+			dscp:     DSCPAF12,
+			ecn:      ECNCE,
+			expected: "AF12",
+		},
+		{
+			name:     "Unnamed code point",
+			tos:      0x06,
+			dscp:     1,
+			ecn:      ECNECT0,
+			expected: "DSCP(1)",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p := &Packet{TOS: test.tos}
+
+			if p.DSCP() != test.dscp {
+				t.Errorf("DSCP not expected %d", p.DSCP())
+			}
+
+			if p.ECN() != test.ecn {
+				t.Errorf("ECN not expected %d", p.ECN())
+			}
+
+			if p.DSCP().String() != test.expected {
+				t.Errorf("Name not expected %s", p.DSCP())
+			}
+		})
+	}
+}
+
+func Test_Packet_SetDSCP(t *testing.T) {
+	p := New(IPAddr{}, IPAddr{}, nil).WithECN(ECNECT1).WithDSCP(DSCPAF41)
+
+	if p.TOS != 0x89 {
+		t.Errorf("TOS not expected %#x", p.TOS)
+	}
+
+	if err := p.SetDSCP(64); err == nil {
+		t.Error("wrong dscp accepted")
+	}
+
+	if !p.MarkCE() || p.ECN() != ECNCE || p.DSCP() != DSCPAF41 {
+		t.Errorf("TOS not expected %#x", p.TOS)
+	}
+
+	p.SetECN(ECNNotECT)
+
+	if p.MarkCE() {
+		t.Error("not ECN capable packet marked")
+	}
+}
+
+func Test_AF(t *testing.T) {
+	tests := []struct {
+		class    int
+		drop     int
+		expected DSCP
+		fails    bool
+	}{
+		{class: 1, drop: 1, expected: DSCPAF11},
+		{class: 2, drop: 3, expected: DSCPAF23},
+		{class: 4, drop: 2, expected: DSCPAF42},
+		{class: 5, drop: 1, fails: true},
+		{class: 1, drop: 0, fails: true},
+	}
+
+	for _, test := range tests {
+		d, err := AF(test.class, test.drop)
+
+		if (err != nil) != test.fails || d != test.expected {
+			t.Errorf("AF%d%d not expected %v %v", test.class, test.drop, d, err)
+		}
+	}
+}
+
+func Test_CS(t *testing.T) {
+	if d, err := CS(5); err != nil || d != DSCPCS5 {
+		t.Errorf("CS5 not expected %v %v", d, err)
+	}
+
+	if _, err := CS(8); err == nil {
+		t.Error("wrong precedence accepted")
+	}
+}
+
+func Test_dscpPolicy_lookup(t *testing.T) {
+	dst, _ := IPFromString("10.0.0.1")
+
+	d := dscpPolicy{dflt: DSCPAF21, perDest: map[IPAddr]DSCP{dst: DSCPEF}}
+
+	if d.lookup(dst) != DSCPEF {
+		t.Error("per destination code point not used")
+	}
+
+	if d.lookup(IPAddr{10, 0, 0, 2}) != DSCPAF21 {
+		t.Error("default code point not used")
+	}
+}
+
+func Test_IpSocket_WriteTo_DSCP(t *testing.T) {
+	is, r := arpSocket(t, "eth0", "192.168.0.1", 24)
+
+	peer, _ := IPFromString("192.168.0.2")
+	other, _ := IPFromString("192.168.0.3")
+	is.Neighbors().Update(peer, peerMac, true, time.Now())
+	is.Neighbors().Update(other, peerMac, true, time.Now())
+
+	if err := is.SetDSCP(DSCPAF21); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := is.SetDSCPFor(peer, DSCPEF); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, dst := range []IPAddr{peer, other} {
+		if err := is.WriteTo(dst, []byte("data")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	is.ClearDSCPFor(peer)
+
+	if err := is.WriteTo(peer, []byte("data")); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []DSCP{DSCPEF, DSCPAF21, DSCPAF21}
+
+	if len(r.frames) != len(expected) {
+		t.Fatalf("Frames not expected %v", r.frames)
+	}
+
+	for i, frame := range r.frames {
+		// TOS byte of header carries code point in its upper six bits
+		if DSCP(frame.payload[1]>>2) != expected[i] {
+			t.Errorf("TOS of frame %d not expected %#x", i, frame.payload[1])
+		}
+	}
+}
@@ -0,0 +1,485 @@
+package ipv4
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// ErrMartian is returned when datagram has source or destination address
+	// which must not appear on network (see RFC1812 5.3.7)
+	ErrMartian = errors.New("martian address")
+	// ErrNoSockets is returned by Forwarder.Run without sockets to read
+	ErrNoSockets = errors.New("forwarder has no sockets")
+	// ErrSourceRouteFailed is returned when the next hop of strict source
+	// route is not on link
+	ErrSourceRouteFailed = errors.New("source route failed")
+)
+
+// Router chooses route of datagrams to dst, RouteTable implements it
+type Router interface {
+	Lookup(dst IPAddr) (Route, bool)
+}
+
+// RouterFunc adapts function to Router
+type RouterFunc func(dst IPAddr) (Route, bool)
+
+// Lookup calls f
+func (f RouterFunc) Lookup(dst IPAddr) (Route, bool) {
+	return f(dst)
+}
+
+// InterfaceStats contains counters of forwarder for one interface, drops are
+// counted on interface datagram is received from, sends on egress one
+type InterfaceStats struct {
+	Received   uint64 // datagrams read from interface
+	Delivered  uint64 // datagrams addressed to router passed to local handler
+	Forwarded  uint64 // datagrams sent to interface
+	Fragmented uint64 // datagrams fragmented to fit MTU of interface
+	TTLExpired uint64 // datagrams dropped because TTL reached zero
+	Martian    uint64 // datagrams dropped for invalid source or destination address
+	NoRoute    uint64 // datagrams dropped without route
+	TooBig     uint64 // datagrams exceeding MTU of egress interface with Don't Fragment flag
+	BadOption  uint64 // datagrams dropped for options failed to process
+	SendFailed uint64 // datagrams failed to write to interface
+}
+
+type interfaceCounters struct {
+	received   atomic.Uint64
+	delivered  atomic.Uint64
+	forwarded  atomic.Uint64
+	fragmented atomic.Uint64
+	ttlExpired atomic.Uint64
+	martian    atomic.Uint64
+	noRoute    atomic.Uint64
+	tooBig     atomic.Uint64
+	badOption  atomic.Uint64
+	sendFailed atomic.Uint64
+}
+
+// Forwarder is software router between interfaces as described in RFC1812.
+// Datagram received on one socket gets TTL decremented and is sent out of
+// interface chosen by Router to the next hop, it is fragmented when exceeds
+// MTU of egress interface. Dropped datagrams are reported by error reporter
+// of ingress socket (see IpSocket.SetErrorReporter), so ICMP Time Exceeded
+// and Destination Unreachable are sent when icmp.Reporter is set up. Options
+// are processed as RFC1812 5.2.4 requires: datagram with source route option
+// addressed to router goes to the next address of the route, Record Route and
+// Internet Timestamp get address of egress interface. Sockets should neither
+// be bound to protocol nor reassemble datagrams. Datagrams with martian
+// addresses are silently dropped.
+type Forwarder struct {
+	router Router
+	socks  map[string]*IpSocket
+	stats  map[string]*interfaceCounters
+
+	mu    sync.RWMutex
+	local PacketHandler
+}
+
+// NewForwarder creates forwarder between socks, routes refer interfaces by
+// socket name. Sockets must have ARP enabled to send datagrams to the next
+// hop, ErrARPDisabled is returned otherwise.
+func NewForwarder(router Router, socks ...*IpSocket) (*Forwarder, error) {
+	f := &Forwarder{
+		router: router,
+		socks:  make(map[string]*IpSocket),
+		stats:  make(map[string]*interfaceCounters),
+	}
+
+	for _, sock := range socks {
+		if sock.Neighbors() == nil {
+			return nil, fmt.Errorf("%w on %v", ErrARPDisabled, sock.ifName)
+		}
+
+		f.socks[sock.ifName] = sock
+		f.stats[sock.ifName] = &interfaceCounters{}
+	}
+
+	return f, nil
+}
+
+// HandleLocal registers handler for datagrams addressed to router itself:
+// to address of any interface, broadcast or multicast. Nil handler drops them.
+func (f *Forwarder) HandleLocal(h PacketHandler) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.local = h
+}
+
+// Stats returns counters by interface name
+func (f *Forwarder) Stats() map[string]InterfaceStats {
+	stats := make(map[string]InterfaceStats, len(f.stats))
+
+	for name, c := range f.stats {
+		stats[name] = InterfaceStats{
+			Received:   c.received.Load(),
+			Delivered:  c.delivered.Load(),
+			Forwarded:  c.forwarded.Load(),
+			Fragmented: c.fragmented.Load(),
+			TTLExpired: c.ttlExpired.Load(),
+			Martian:    c.martian.Load(),
+			NoRoute:    c.noRoute.Load(),
+			TooBig:     c.tooBig.Load(),
+			BadOption:  c.badOption.Load(),
+			SendFailed: c.sendFailed.Load(),
+		}
+	}
+
+	return stats
+}
+
+// Run reads all sockets and forwards datagrams until read fails or ctx is
+// done, it returns the first error. Reading is not interrupted by ctx, so
+// sockets blocked in ReadPacket keep reading after Run returns and stop after
+// the next datagram is read, close underlying ethernet sockets to stop them
+// at once.
+func (f *Forwarder) Run(ctx context.Context) error {
+	if len(f.socks) == 0 {
+		return ErrNoSockets
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	errs := make(chan error, len(f.socks))
+
+	for _, sock := range f.socks {
+		go func(in *IpSocket) {
+			for {
+				if err := ctx.Err(); err != nil {
+					errs <- err
+					return
+				}
+
+				p, err := in.ReadPacket()
+				if err != nil {
+					errs <- err
+					return
+				}
+
+				f.Forward(in, p)
+			}
+		}(sock)
+	}
+
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Forward routes datagram received from socket in. Error is returned when
+// datagram is dropped.
+func (f *Forwarder) Forward(in *IpSocket, p *Packet) error {
+	inStats := f.counters(in)
+	inStats.received.Add(1)
+
+	if isMartian(in, p) {
+		inStats.martian.Add(1)
+		return fmt.Errorf("%w: %v -> %v", ErrMartian, p.Src.String(), p.Dst.String())
+	}
+
+	var route sourceRoute
+
+	// datagram carrying source route is addressed to router on every hop
+	if f.isInterfaceAddr(p.Dst) {
+		var err error
+
+		if route, err = activeSourceRoute(p); err != nil {
+			inStats.badOption.Add(1)
+			return err
+		}
+	}
+
+	if route == nil && f.isLocal(in, p.Dst) {
+		f.mu.RLock()
+		h := f.local
+		f.mu.RUnlock()
+
+		if h != nil {
+			inStats.delivered.Add(1)
+			h(p)
+		}
+
+		return nil
+	}
+
+	if p.TTL <= 1 {
+		inStats.ttlExpired.Add(1)
+		in.reportError(ReasonTTLExpired, p)
+
+		return ErrTTLExpired
+	}
+
+	dst := p.Dst
+	if route != nil {
+		dst, _ = route.Next(IPAddr{}) // route is not exhausted, local address is recorded later
+	}
+
+	r, ok := f.router.Lookup(dst)
+	out := f.socks[r.Iface]
+
+	if !ok || out == nil {
+		inStats.noRoute.Add(1)
+		in.reportError(ReasonNetUnreachable, p)
+
+		return fmt.Errorf("%w: %v", ErrNoRoute, dst.String())
+	}
+
+	if _, strict := route.(*StrictSourceRoute); strict && r.NextHop != (IPAddr{}) {
+		inStats.badOption.Add(1)
+		in.reportError(ReasonSourceRouteFailed, p)
+
+		return fmt.Errorf("%w: %v is not on link", ErrSourceRouteFailed, dst.String())
+	}
+
+	outStats := f.counters(out)
+	hop := r.Gateway(dst)
+
+	forwarded := *p
+	forwarded.TTL--
+	forwarded.header = nil
+	forwarded.Options = append([]Option(nil), p.Options...)
+
+	changed, err := forwarded.updateOptions(out.GetIpAddr(), route != nil, time.Now())
+	if err != nil {
+		inStats.badOption.Add(1)
+		return err
+	}
+
+	if !changed {
+		if data, ok := forwardRaw(p, out.mtu); ok {
+			if err := out.writeFrame(p.Dst, hop, data); err != nil {
+				outStats.sendFailed.Add(1)
+				return err
+			}
+
+			outStats.forwarded.Add(1)
+
+			return nil
+		}
+	}
+
+	fragments, err := forwarded.Fragment(out.mtu)
+	if err != nil {
+		if errors.Is(err, ErrFragmentationNeeded) {
+			outStats.tooBig.Add(1)
+			in.reportErrorMTU(ReasonFragmentationNeeded, p, out.mtu)
+		} else {
+			outStats.sendFailed.Add(1)
+		}
+
+		return err
+	}
+
+	if len(fragments) > 1 {
+		outStats.fragmented.Add(1)
+	}
+
+	for _, fragment := range fragments {
+		data, err := fragment.MarshalChecked()
+		if err == nil {
+			err = out.writeFrame(forwarded.Dst, hop, data)
+		}
+
+		if err != nil {
+			outStats.sendFailed.Add(1)
+			return err
+		}
+	}
+
+	outStats.forwarded.Add(1)
+
+	return nil
+}
+
+// isLocal reports whether dst is address of router interface, broadcast or
+// multicast one
+func (f *Forwarder) isLocal(in *IpSocket, dst IPAddr) bool {
+	return isMulticast(dst) || in.IsBroadcast(dst) || f.isInterfaceAddr(dst)
+}
+
+// isInterfaceAddr reports whether dst is address of router interface
+func (f *Forwarder) isInterfaceAddr(dst IPAddr) bool {
+	for _, sock := range f.socks {
+		if sock.GetIpAddr() == dst {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isMartian reports whether datagram received from socket in must not be
+// forwarded following RFC1812 5.3.7: destination is in 0/8, 127/8 or class E
+// network, source is in 0/8, 127/8, multicast, class E or broadcast. Zero
+// source is allowed only for limited broadcast sent by host on startup.
+func isMartian(in *IpSocket, p *Packet) bool {
+	src, dst := p.Src, p.Dst
+
+	if dst[0] == 0 || dst[0] == 127 || (dst[0] >= 240 && dst != broadcastIP) {
+		return true
+	}
+
+	if src == (IPAddr{}) {
+		return dst != broadcastIP
+	}
+
+	return src[0] == 0 || src[0] == 127 || src[0] >= 224 || in.IsBroadcast(src)
+}
+
+// isMulticast reports whether dst is class D address
+func isMulticast(dst IPAddr) bool {
+	return dst[0] >= 224 && dst[0] < 240
+}
+
+func (f *Forwarder) counters(sock *IpSocket) *interfaceCounters {
+	if c, ok := f.stats[sock.ifName]; ok {
+		return c
+	}
+
+	// socket is not part of forwarder, its counters are discarded
+	return &interfaceCounters{}
+}
+
+// sourceRoute is implemented by LooseSourceRoute and StrictSourceRoute
+type sourceRoute interface {
+	Option() Option
+	Done() bool
+	Next(local IPAddr) (IPAddr, error)
+}
+
+// decodeSourceRoute converts generic option to source route one, it returns
+// nil for other options
+func decodeSourceRoute(o Option) (sourceRoute, error) {
+	switch o.Type.Number() {
+	case OptionLooseSourceRoute:
+		return LooseSourceRouteFromOption(o)
+	case OptionStrictSourceRoute:
+		return StrictSourceRouteFromOption(o)
+	}
+
+	return nil, nil
+}
+
+// activeSourceRoute returns source route option of datagram which is not
+// exhausted yet, nil is returned without one
+func activeSourceRoute(p *Packet) (sourceRoute, error) {
+	for _, opt := range p.Options {
+		route, err := decodeSourceRoute(opt)
+		if err != nil {
+			return nil, err
+		}
+
+		if route != nil && !route.Done() {
+			return route, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// updateOptions processes options of datagram leaving interface with address
+// local: the next address of active source route becomes destination and
+// local address is recorded in its place, Record Route and Internet Timestamp
+// get local address. Options must not be shared with received datagram. It
+// reports whether options are changed.
+func (p *Packet) updateOptions(local IPAddr, sourceRouted bool, now time.Time) (bool, error) {
+	changed := false
+
+	for i, opt := range p.Options {
+		var updated interface{ Option() Option }
+
+		switch opt.Type.Number() {
+		case OptionRecordRoute:
+			rr, err := RecordRouteFromOption(opt)
+			if err != nil {
+				return false, err
+			}
+
+			if rr.Full() {
+				continue
+			}
+
+			rr.Record(local)
+			updated = rr
+		case OptionTimestamp:
+			ts, err := TimestampFromOption(opt)
+			if err != nil {
+				return false, err
+			}
+
+			if err := ts.Stamp(local, now); err != nil {
+				return false, err
+			}
+
+			updated = ts
+		case OptionLooseSourceRoute, OptionStrictSourceRoute:
+			if !sourceRouted {
+				continue
+			}
+
+			route, err := decodeSourceRoute(opt)
+			if err != nil {
+				return false, err
+			}
+
+			if p.Dst, err = route.Next(local); err != nil {
+				return false, err
+			}
+
+			sourceRouted = false // only the first source route is followed
+			updated = route
+		default:
+			continue
+		}
+
+		p.Options[i] = updated.Option()
+		changed = true
+	}
+
+	return changed, nil
+}
+
+// forwardRaw returns received datagram with TTL decremented, checksum is
+// updated incrementally as RFC1624 describes. It fails for datagram which is
+// changed after it is received or exceeds mtu.
+func forwardRaw(p *Packet, mtu int) ([]byte, bool) {
+	header := p.header
+	if !p.headerMatches() || int(p.Length) != len(header)+len(p.Data) || int(p.Length) > mtu {
+		return nil, false
+	}
+
+	data := make([]byte, p.Length)
+	copy(data, header)
+	copy(data[len(header):], p.Data)
+
+	old := binary.BigEndian.Uint16(data[8:10])
+	data[8]--
+
+	checksum := updateChecksum(binary.BigEndian.Uint16(data[10:12]), old, binary.BigEndian.Uint16(data[8:10]))
+	binary.BigEndian.PutUint16(data[10:12], checksum)
+
+	return data, true
+}
+
+// updateChecksum returns checksum after 16 bit word of data changed from old
+// to updated value: HC' = ~(~HC + ~m + m') from RFC1624
+func updateChecksum(checksum, old, updated uint16) uint16 {
+	sum := uint32(^checksum) + uint32(^old) + uint32(updated)
+
+	for sum>>16 != 0 {
+		sum = sum&0xFFFF + sum>>16
+	}
+
+	return ^uint16(sum)
+}
@@ -0,0 +1,427 @@
+package ipv4
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+type reportedError struct {
+	reason ErrorReason
+	mtu    int
+}
+
+// errorRecorder keeps errors reported by socket
+type errorRecorder struct {
+	errors []reportedError
+}
+
+func (r *errorRecorder) ReportError(reason ErrorReason, p *Packet, mtu int) error {
+	r.errors = append(r.errors, reportedError{reason: reason, mtu: mtu})
+	return nil
+}
+
+var nextHopMac = net.HardwareAddr{0x02, 0, 0, 0, 0, 0x10}
+
+// forwarder connects 192.168.0.0/24 on eth0 with 10.0.0.0/8 on eth1, the
+// rest goes through 10.0.0.254
+func forwarder(t *testing.T) (*Forwarder, *IpSocket, *IpSocket, *frameRecorder, *errorRecorder) {
+	in, _ := arpSocket(t, "eth0", "192.168.0.1", 24)
+	out, frames := arpSocket(t, "eth1", "10.0.0.1", 8)
+
+	errs := &errorRecorder{}
+	in.SetErrorReporter(errs)
+
+	out.Neighbors().Update([4]byte{10, 0, 0, 5}, nextHopMac, true, time.Now())
+
+	table := NewRouteTable()
+	table.Add(route(t, "192.168.0.0", 24, "", "eth0", 0))
+	table.Add(route(t, "10.0.0.0", 8, "", "eth1", 0))
+	table.Add(route(t, "172.16.0.0", 12, "10.0.0.254", "eth1", 0))
+
+	f, err := NewForwarder(table, in, out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return f, in, out, frames, errs
+}
+
+// received returns packet as it is parsed by socket
+func received(t *testing.T, src, dst string, ttl uint8, data []byte) *Packet {
+	srcIP, _ := IPFromString(src)
+	dstIP, _ := IPFromString(dst)
+
+	sent := New(srcIP, dstIP, data).WithProtocol(ProtocolUDP)
+	sent.TTL = ttl
+	sent.ID = 7
+
+	p := &Packet{}
+	if err := p.Unmarshal(sent.Marshal()); err != nil {
+		t.Fatal(err)
+	}
+
+	return p
+}
+
+func Test_Forwarder_Forward(t *testing.T) {
+	tests := []struct {
+		name     string
+		dst      string
+		expected net.HardwareAddr
+	}{
+		{
+			name:     "On link",
+			dst:      "10.0.0.5",
+			expected: nextHopMac,
+		},
+		{
+			name:     "Via gateway",
+			dst:      "172.16.1.1",
+			expected: gatewayMac,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f, in, _, frames, _ := forwarder(t)
+
+			if err := f.Forward(in, received(t, "192.168.0.2", test.dst, 64, []byte("data"))); err != nil {
+				t.Fatal(err)
+			}
+
+			if len(frames.frames) != 1 || !bytes.Equal(frames.frames[0].dst, test.expected) {
+				t.Fatalf("Frames not expected %v", frames.frames)
+			}
+
+			p := &Packet{}
+			if err := p.Unmarshal(frames.frames[0].payload); err != nil {
+				t.Fatal(err)
+			}
+
+			if p.TTL != 63 || !p.VerifyChecksum() || string(p.Data) != "data" {
+				t.Errorf("Forwarded not expected %v", p)
+			}
+
+			stats := f.Stats()
+			if stats["eth0"].Received != 1 || stats["eth1"].Forwarded != 1 {
+				t.Errorf("Stats not expected %v", stats)
+			}
+		})
+	}
+}
+
+func Test_Forwarder_Forward_Fragment(t *testing.T) {
+	f, in, out, frames, _ := forwarder(t)
+	out.SetMTU(576)
+
+	data := bytes.Repeat([]byte{1}, 1000)
+
+	if err := f.Forward(in, received(t, "192.168.0.2", "10.0.0.5", 64, data)); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(frames.frames) != 2 {
+		t.Fatalf("Frames not expected %d", len(frames.frames))
+	}
+
+	for _, frame := range frames.frames {
+		p := &Packet{}
+		if err := p.Unmarshal(frame.payload); err != nil {
+			t.Fatal(err)
+		}
+
+		if p.TTL != 63 || p.ID != 7 || !p.VerifyChecksum() || len(frame.payload) > 576 {
+			t.Errorf("Fragment not expected %v", p)
+		}
+	}
+
+	if stats := f.Stats()["eth1"]; stats.Fragmented != 1 || stats.Forwarded != 1 {
+		t.Errorf("Stats not expected %v", stats)
+	}
+}
+
+func Test_Forwarder_Forward_Drop(t *testing.T) {
+	tests := []struct {
+		name     string
+		dst      string
+		ttl      uint8
+		df       bool
+		expected error
+		reason   ErrorReason
+	}{
+		{
+			name:     "TTL expired",
+			dst:      "10.0.0.5",
+			ttl:      1,
+			expected: ErrTTLExpired,
+			reason:   ReasonTTLExpired,
+		},
+		{
+			name:     "No route",
+			dst:      "8.8.8.8",
+			ttl:      64,
+			expected: ErrNoRoute,
+			reason:   ReasonNetUnreachable,
+		},
+		{
+			name:     "Don't fragment",
+			dst:      "10.0.0.5",
+			ttl:      64,
+			df:       true,
+			expected: ErrFragmentationNeeded,
+			reason:   ReasonFragmentationNeeded,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f, in, out, frames, errs := forwarder(t)
+			out.SetMTU(576)
+
+			p := received(t, "192.168.0.2", test.dst, test.ttl, make([]byte, 1000))
+			p.WithDontFragment(test.df)
+
+			if err := f.Forward(in, p); !errors.Is(err, test.expected) {
+				t.Fatalf("Error not expected %v", err)
+			}
+
+			if len(frames.frames) != 0 {
+				t.Errorf("Frames not expected %v", frames.frames)
+			}
+
+			if len(errs.errors) != 1 || errs.errors[0].reason != test.reason {
+				t.Errorf("Reported not expected %v", errs.errors)
+			}
+		})
+	}
+
+	f, in, _, _, errs := forwarder(t)
+	in.SetMTU(9000)
+
+	p := received(t, "192.168.0.2", "10.0.0.5", 64, make([]byte, 2000))
+	p.WithDontFragment(true)
+
+	f.Forward(in, p)
+
+	if len(errs.errors) != 1 || errs.errors[0].mtu != 1500 {
+		t.Errorf("MTU of egress interface not reported %v", errs.errors)
+	}
+
+	if stats := f.Stats()["eth1"]; stats.TooBig != 1 {
+		t.Errorf("Stats not expected %v", stats)
+	}
+}
+
+func Test_Forwarder_HandleLocal(t *testing.T) {
+	f, in, _, frames, _ := forwarder(t)
+
+	var delivered []*Packet
+	f.HandleLocal(func(p *Packet) {
+		delivered = append(delivered, p)
+	})
+
+	for _, dst := range []string{"10.0.0.1", "192.168.0.255", "224.0.0.5"} {
+		if err := f.Forward(in, received(t, "192.168.0.2", dst, 1, nil)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if len(delivered) != 3 || len(frames.frames) != 0 {
+		t.Errorf("Delivered not expected %d", len(delivered))
+	}
+
+	if stats := f.Stats()["eth0"]; stats.Delivered != 3 {
+		t.Errorf("Stats not expected %v", stats)
+	}
+}
+
+func Test_Forwarder_Forward_Martian(t *testing.T) {
+	tests := []struct {
+		src string
+		dst string
+	}{
+		{src: "192.168.0.2", dst: "0.1.2.3"},
+		{src: "192.168.0.2", dst: "127.0.0.1"},
+		{src: "192.168.0.2", dst: "240.0.0.1"},
+		{src: "0.0.0.0", dst: "10.0.0.5"},
+		{src: "127.0.0.1", dst: "10.0.0.5"},
+		{src: "224.0.0.5", dst: "10.0.0.5"},
+		{src: "250.0.0.1", dst: "10.0.0.5"},
+		{src: "192.168.0.255", dst: "10.0.0.5"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.src+"->"+test.dst, func(t *testing.T) {
+			f, in, _, frames, errs := forwarder(t)
+
+			delivered := 0
+			f.HandleLocal(func(p *Packet) {
+				delivered++
+			})
+
+			if err := f.Forward(in, received(t, test.src, test.dst, 64, nil)); !errors.Is(err, ErrMartian) {
+				t.Fatalf("Error not expected %v", err)
+			}
+
+			if delivered != 0 || len(frames.frames) != 0 || len(errs.errors) != 0 {
+				t.Errorf("Martian not dropped %d %v %v", delivered, frames.frames, errs.errors)
+			}
+
+			if stats := f.Stats()["eth0"]; stats.Martian != 1 {
+				t.Errorf("Stats not expected %v", stats)
+			}
+		})
+	}
+
+	// host without address broadcasts on startup
+	f, in, _, _, _ := forwarder(t)
+	if err := f.Forward(in, received(t, "0.0.0.0", "255.255.255.255", 64, nil)); err != nil {
+		t.Errorf("Error not expected %v", err)
+	}
+}
+
+func Test_Forwarder_Forward_Options(t *testing.T) {
+	src, _ := IPFromString("192.168.0.2")
+	router, _ := IPFromString("192.168.0.1")
+	egress, _ := IPFromString("10.0.0.1")
+	dst, _ := IPFromString("10.0.0.5")
+	remote, _ := IPFromString("172.16.1.1")
+
+	rr, _ := NewRecordRouteOption(2)
+	ts, _ := NewTimestampOption(TimestampWithAddress, 2)
+
+	tests := []struct {
+		name     string
+		dst      IPAddr
+		option   Option
+		expected error
+	}{
+		{name: "Record route", dst: dst, option: rr.Option()},
+		{name: "Timestamp", dst: dst, option: ts.Option()},
+		{name: "Loose source route", dst: router, option: (&LooseSourceRoute{Pointer: 4, Route: []IPAddr{dst}}).Option()},
+		{name: "Strict source route", dst: router, option: (&StrictSourceRoute{Pointer: 4, Route: []IPAddr{dst}}).Option()},
+		{name: "Strict source route off link", dst: router, option: (&StrictSourceRoute{Pointer: 4, Route: []IPAddr{remote}}).Option(), expected: ErrSourceRouteFailed},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f, in, _, frames, errs := forwarder(t)
+
+			p := &Packet{}
+			if err := p.Unmarshal(New(src, test.dst, []byte("data")).WithOptions(test.option).Marshal()); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := f.Forward(in, p); !errors.Is(err, test.expected) {
+				t.Fatalf("Error not expected %v", err)
+			}
+
+			if test.expected != nil {
+				if len(frames.frames) != 0 || len(errs.errors) != 1 || errs.errors[0].reason != ReasonSourceRouteFailed {
+					t.Errorf("Dropped not expected %v %v", frames.frames, errs.errors)
+				}
+
+				return
+			}
+
+			if len(frames.frames) != 1 || !bytes.Equal(frames.frames[0].dst, nextHopMac) {
+				t.Fatalf("Frames not expected %v", frames.frames)
+			}
+
+			forwarded := &Packet{}
+			if err := forwarded.Unmarshal(frames.frames[0].payload); err != nil {
+				t.Fatal(err)
+			}
+
+			if forwarded.Dst != dst || forwarded.TTL != 63 || !forwarded.VerifyChecksum() {
+				t.Fatalf("Forwarded not expected %v", forwarded)
+			}
+
+			var recorded IPAddr
+
+			switch o := forwarded.Typed[0].(type) {
+			case *RecordRouteOption:
+				recorded = o.Recorded()[0]
+			case *TimestampOption:
+				recorded = o.Recorded()[0].Addr
+			case *LooseSourceRoute:
+				recorded = o.Route[0]
+			case *StrictSourceRoute:
+				recorded = o.Route[0]
+			}
+
+			if recorded != egress {
+				t.Errorf("Recorded not expected %v", forwarded.Typed)
+			}
+		})
+	}
+}
+
+func Test_Forwarder_Run(t *testing.T) {
+	f, err := NewForwarder(NewRouteTable())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := f.Run(context.Background()); !errors.Is(err, ErrNoSockets) {
+		t.Errorf("Error not expected %v", err)
+	}
+
+	f, in, out, _, _ := forwarder(t)
+
+	block := make(chan struct{})
+	defer close(block)
+
+	// sockets blocked in ReadPacket do not keep Run after ctx is done
+	in.recv = func() (uint16, []byte, error) {
+		<-block
+		return 0, nil, io.EOF
+	}
+	out.recv = in.recv
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	if err := f.Run(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Error not expected %v", err)
+	}
+}
+
+func Test_NewForwarder_NoARP(t *testing.T) {
+	is, _ := arpSocket(t, "eth0", "192.168.0.1", 24)
+	is.DisableARP()
+
+	if _, err := NewForwarder(NewRouteTable(), is); !errors.Is(err, ErrARPDisabled) {
+		t.Errorf("Error not expected %v", err)
+	}
+}
+
+func Test_updateChecksum(t *testing.T) {
+	p := received(t, "192.168.0.2", "10.0.0.5", 64, []byte("data"))
+
+	data, ok := forwardRaw(p, 1500)
+	if !ok {
+		t.Fatal("Raw forwarding not used")
+	}
+
+	forwarded := *p
+	forwarded.TTL--
+
+	if !bytes.Equal(data, forwarded.Marshal()) {
+		t.Errorf("Forwarded not expected %v", data)
+	}
+
+	// example of RFC1624 where equation 2 gives 0xFFFF
+	if checksum := updateChecksum(0xDD2F, 0x5555, 0x3285); checksum != 0x0000 {
+		t.Errorf("Checksum not expected %#x", checksum)
+	}
+
+	if checksum := updateChecksum(0x1234, 0x4006, 0x3F06); checksum != 0x1334 {
+		t.Errorf("Checksum not expected %#x", checksum)
+	}
+}
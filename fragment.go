@@ -0,0 +1,84 @@
+package ipv4
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrFragmentationNeeded is returned when datagram exceeds MTU but has
+// Don't Fragment flag
+var ErrFragmentationNeeded = errors.New("fragmentation needed and don't fragment set")
+
+// Fragment splits packet to fragments which fit mtu as described in RFC791.
+// All options go to the first fragment, the rest ones get only options with
+// copied flag. Packet which already fits mtu is returned as is, packet
+// which options exceed 40 bytes is rejected with ErrOptionsTooLong.
+func (p *Packet) Fragment(mtu int) ([]*Packet, error) {
+	if length := optionsLength(p.Options); length > maxOptionsLength {
+		return nil, fmt.Errorf("%w: %d bytes", ErrOptionsTooLong, length)
+	}
+
+	options := p.marshalOptions()
+	headerLength := ipHeaderLength + len(options)
+
+	if headerLength+len(p.Data) <= mtu {
+		return []*Packet{p}, nil
+	}
+
+	if p.FlFrOff.DontFragment() {
+		return nil, fmt.Errorf("%w: %d bytes, mtu %d", ErrFragmentationNeeded, headerLength+len(p.Data), mtu)
+	}
+
+	if headerLength+fragmentUnit > mtu {
+		return nil, fmt.Errorf("mtu %d is too small for header of %d bytes", mtu, headerLength)
+	}
+
+	var copied []Option
+
+	for _, opt := range p.Options {
+		if opt.Type.Copied() == 1 {
+			copied = append(copied, opt)
+		}
+	}
+
+	offset := p.FlFrOff.FragmentOffsetBytes()
+	data := p.Data
+	opts := p.Options
+
+	var fragments []*Packet
+
+	for len(data) > 0 {
+		fragment := *p
+		fragment.Options = opts
+		fragment.Typed = nil
+		fragment.header = nil
+
+		headerLength := ipHeaderLength + len(fragment.marshalOptions())
+
+		size := (mtu - headerLength) &^ (fragmentUnit - 1)
+		if size <= 0 {
+			return nil, fmt.Errorf("mtu %d is too small for header of %d bytes", mtu, headerLength)
+		}
+
+		if size < len(data) {
+			fragment.FlFrOff.SetMoreFragments(true)
+		} else {
+			size = len(data) // the last piece keeps More Fragments flag of original
+		}
+
+		if err := fragment.FlFrOff.SetFragmentOffset(offset); err != nil {
+			return nil, err
+		}
+		fragment.Data = data[:size]
+		fragment.Length = uint16(headerLength + size)
+		fragment.VerIHL = VersionIHL{Value: 4<<4 | uint8(headerLength/4)}
+
+		fragments = append(fragments, &fragment)
+
+		data = data[size:]
+		offset += size
+		opts = copied
+	}
+
+	return fragments, nil
+}
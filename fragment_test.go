@@ -0,0 +1,147 @@
+package ipv4
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+)
+
+func Test_Packet_Fragment(t *testing.T) {
+	src, _ := IPFromString("1.2.3.4")
+	dst, _ := IPFromString("4.3.2.1")
+
+	data := make([]byte, 100)
+	for i := range data {
+		data[i] = byte(i)
+	}
+
+	rr, _ := NewRecordRouteOption(1)
+	lsrr, _ := NewLooseSourceRoute(dst)
+
+	p := New(src, dst, data).WithOptions(rr.Option(), lsrr.Option())
+	p.ID = 7
+
+	fragments, err := p.Fragment(60)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		offset  uint16
+		more    bool
+		size    int
+		options int
+	}{
+		{offset: 0, more: true, size: 24, options: 2},
+		{offset: 3, more: true, size: 32, options: 1},
+		{offset: 7, more: true, size: 32, options: 1},
+		{offset: 11, more: false, size: 12, options: 1},
+	}
+
+	if len(fragments) != len(tests) {
+		t.Fatalf("Count of fragments not expected %d", len(fragments))
+	}
+
+	var joined []byte
+
+	for i, test := range tests {
+		f := fragments[i]
+
+		if f.FlFrOff.FragmentOffset() != test.offset {
+			t.Errorf("Fragment %d offset not expected %d", i, f.FlFrOff.FragmentOffset())
+		}
+
+		if f.FlFrOff.MoreFragments() != test.more {
+			t.Errorf("Fragment %d more fragments flag not expected", i)
+		}
+
+		if len(f.Data) != test.size || len(f.Options) != test.options {
+			t.Errorf("Fragment %d size %d with %d options not expected", i, len(f.Data), len(f.Options))
+		}
+
+		if f.ID != p.ID {
+			t.Errorf("Fragment %d id not expected %d", i, f.ID)
+		}
+
+		if len(f.Marshal()) > 60 {
+			t.Errorf("Fragment %d exceeds mtu", i)
+		}
+
+		joined = append(joined, f.Data...)
+	}
+
+	if !bytes.Equal(joined, data) {
+		t.Error("fragments data not expected")
+	}
+}
+
+func Test_Packet_Fragment_Errors(t *testing.T) {
+	p := New(IPAddr{}, IPAddr{}, make([]byte, 100))
+
+	if fragments, err := p.Fragment(1500); err != nil || len(fragments) != 1 || fragments[0] != p {
+		t.Errorf("Small packet fragmented %v", err)
+	}
+
+	if _, err := p.Fragment(24); err == nil {
+		t.Error("mtu smaller than header and fragment unit accepted")
+	}
+
+	p.WithDontFragment(true)
+
+	if _, err := p.Fragment(60); !errors.Is(err, ErrFragmentationNeeded) {
+		t.Errorf("Error not expected %v", err)
+	}
+}
+
+func Test_IpSocket_WriteTo_Fragment(t *testing.T) {
+	is, r := arpSocket(t, "eth0", "192.168.0.1", 24)
+	is.SetMTU(576)
+
+	peer, _ := IPFromString("192.168.0.2")
+	is.Neighbors().Update(peer, peerMac, true, time.Now())
+
+	data := bytes.Repeat([]byte{1}, 1000)
+
+	if err := is.WriteTo(peer, data); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(r.frames) != 2 {
+		t.Fatalf("Frames not expected %d", len(r.frames))
+	}
+
+	var joined []byte
+
+	for _, frame := range r.frames {
+		p := &Packet{}
+		if err := p.Unmarshal(frame.payload); err != nil {
+			t.Fatal(err)
+		}
+
+		if len(frame.payload) > 576 || !bytes.Equal(frame.dst, peerMac) || p.FlFrOff.FragmentOffsetBytes() != len(joined) {
+			t.Errorf("Fragment not expected %v", p)
+		}
+
+		joined = append(joined, p.Data...)
+	}
+
+	if !bytes.Equal(joined, data) {
+		t.Errorf("Data not expected %v", joined)
+	}
+
+	r.frames = nil
+
+	errs := &errorRecorder{}
+	is.SetErrorReporter(errs)
+
+	p := New(is.GetIpAddr(), peer, data).WithDontFragment(true)
+
+	if err := is.WritePacket(p); !errors.Is(err, ErrFragmentationNeeded) {
+		t.Errorf("Error not expected %v", err)
+	}
+
+	if len(r.frames) != 0 || len(errs.errors) != 1 || errs.errors[0].reason != ReasonFragmentationNeeded {
+		t.Errorf("Dropped not expected %v %v", r.frames, errs.errors)
+	}
+}
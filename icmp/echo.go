@@ -0,0 +1,79 @@
+package icmp
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+// |     Type      |     Code      |          Checksum             |
+// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+// |           Identifier          |        Sequence Number        |
+// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+// |     Data ...
+// +-+-+-+-+-
+//
+//	Echo or Echo Reply message
+type Echo struct {
+	Reply bool
+	ID    uint16
+	Seq   uint16
+	Data  []byte
+}
+
+// EchoFromMessage converts generic message to echo request or reply
+func EchoFromMessage(m *Message) (*Echo, error) {
+	if m.Type != TypeEcho && m.Type != TypeEchoReply {
+		return nil, fmt.Errorf("%w: %d is not echo", ErrBadType, m.Type)
+	}
+
+	return &Echo{
+		Reply: m.Type == TypeEchoReply,
+		ID:    binary.BigEndian.Uint16(m.Rest[0:2]),
+		Seq:   binary.BigEndian.Uint16(m.Rest[2:4]),
+		Data:  m.Data,
+	}, nil
+}
+
+// Message converts echo to generic message
+func (e *Echo) Message() *Message {
+	m := &Message{Type: TypeEcho, Data: e.Data}
+
+	if e.Reply {
+		m.Type = TypeEchoReply
+	}
+
+	binary.BigEndian.PutUint16(m.Rest[0:2], e.ID)
+	binary.BigEndian.PutUint16(m.Rest[2:4], e.Seq)
+
+	return m
+}
+
+// Marshal returns wire representation of echo
+func (e *Echo) Marshal() []byte {
+	return e.Message().Marshal()
+}
+
+// Unmarshal parses echo from b
+func (e *Echo) Unmarshal(b []byte) error {
+	m := &Message{}
+
+	if err := m.Unmarshal(b); err != nil {
+		return err
+	}
+
+	echo, err := EchoFromMessage(m)
+	if err != nil {
+		return err
+	}
+
+	*e = *echo
+
+	return nil
+}
+
+// ReplyTo returns reply to echo request with the same identifier, sequence
+// number and data
+func (e *Echo) ReplyTo() *Echo {
+	return &Echo{Reply: true, ID: e.ID, Seq: e.Seq, Data: e.Data}
+}
@@ -0,0 +1,79 @@
+package icmp
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func Test_Echo_Marshal(t *testing.T) {
+	echo := &Echo{ID: 0x1234, Seq: 1, Data: []byte{0xAB, 0xCD}}
+
+	data := echo.Marshal()
+	expected := []byte{8, 0, 0x39, 0xFD, 0x12, 0x34, 0, 1, 0xAB, 0xCD}
+
+	if !bytes.Equal(data, expected) {
+		t.Errorf("Marshaled not expected %v", data)
+	}
+}
+
+func Test_Echo_Unmarshal(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    []byte
+		expected error
+	}{
+		{
+			name:  "Echo request",
+			input: []byte{8, 0, 0x39, 0xFD, 0x12, 0x34, 0, 1, 0xAB, 0xCD},
+		},
+		{
+			name:  "Echo reply",
+			input: []byte{0, 0, 0x41, 0xFD, 0x12, 0x34, 0, 1, 0xAB, 0xCD},
+		},
+		{
+			name:     "Wrong checksum",
+			input:    []byte{8, 0, 0x39, 0xFE, 0x12, 0x34, 0, 1, 0xAB, 0xCD},
+			expected: ErrBadChecksum,
+		},
+		{
+			name:     "Truncated",
+			input:    []byte{8, 0, 0x39, 0xFD, 0x12},
+			expected: ErrTruncated,
+		},
+		{
+			name:     "Not echo",
+			input:    []byte{11, 0, 0xF4, 0xFF, 0, 0, 0, 0},
+			expected: ErrBadType,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			echo := &Echo{}
+
+			err := echo.Unmarshal(test.input)
+			if !errors.Is(err, test.expected) {
+				t.Fatalf("Error not expected %v", err)
+			}
+
+			if err == nil && (echo.ID != 0x1234 || echo.Seq != 1 || !bytes.Equal(echo.Data, []byte{0xAB, 0xCD})) {
+				t.Errorf("Echo not expected %v", echo)
+			}
+		})
+	}
+}
+
+func Test_Echo_ReplyTo(t *testing.T) {
+	request := &Echo{ID: 7, Seq: 9, Data: []byte{1}}
+	reply := request.ReplyTo()
+
+	parsed := &Echo{}
+	if err := parsed.Unmarshal(reply.Marshal()); err != nil {
+		t.Fatal(err)
+	}
+
+	if !parsed.Reply || parsed.ID != 7 || parsed.Seq != 9 {
+		t.Errorf("Reply not expected %v", parsed)
+	}
+}
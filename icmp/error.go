@@ -0,0 +1,186 @@
+package icmp
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/IvMaslov/ipv4"
+)
+
+// Codes of Destination Unreachable message, RFC792 and RFC1812
+const (
+	CodeNetUnreachable           = 0
+	CodeHostUnreachable          = 1
+	CodeProtocolUnreachable      = 2
+	CodePortUnreachable          = 3
+	CodeFragmentationNeeded      = 4
+	CodeSourceRouteFailed        = 5
+	CodeNetUnknown               = 6
+	CodeHostUnknown              = 7
+	CodeSourceHostIsolated       = 8
+	CodeNetProhibited            = 9
+	CodeHostProhibited           = 10
+	CodeNetUnreachableForTOS     = 11
+	CodeHostUnreachableForTOS    = 12
+	CodeCommunicationProhibited  = 13
+	CodeHostPrecedenceViolation  = 14
+	CodePrecedenceCutoffInEffect = 15
+)
+
+// Codes of Time Exceeded message
+const (
+	CodeTTLExceeded        = 0
+	CodeReassemblyExceeded = 1
+)
+
+// Codes of Parameter Problem message
+const (
+	CodePointerIndicatesError = 0
+	CodeMissingOption         = 1
+	CodeBadLength             = 2
+)
+
+// Codes of Redirect message
+const (
+	CodeRedirectNet     = 0
+	CodeRedirectHost    = 1
+	CodeRedirectTOSNet  = 2
+	CodeRedirectTOSHost = 3
+)
+
+const (
+	// QuotePayloadRFC792 is count of payload bytes quoted after header
+	QuotePayloadRFC792 = 8
+	// maxErrorDatagram limits ICMP error datagram as RFC1812 asks
+	maxErrorDatagram = 576
+)
+
+// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+// |     Type      |     Code      |          Checksum             |
+// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+// |  Pointer, Gateway, Next-Hop MTU or unused                     |
+// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+// |      Internet Header + 64 bits of Original Data Datagram      |
+// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+//
+//	Destination Unreachable, Time Exceeded, Parameter Problem and Redirect
+//	messages. Pointer is used by Parameter Problem, Gateway by Redirect and
+//	NextHopMTU by Destination Unreachable with Fragmentation Needed code.
+type ErrorMessage struct {
+	Type       uint8
+	Code       uint8
+	Pointer    uint8
+	Gateway    ipv4.IPAddr
+	NextHopMTU uint16
+	Original   []byte // quoted header and the beginning of original datagram
+}
+
+// Quote returns header of p followed by at most payload bytes of its data,
+// packet which can't be marshaled is not quoted
+func Quote(p *ipv4.Packet, payload int) []byte {
+	data := p.Marshal()
+	if data == nil {
+		return nil
+	}
+
+	header := int(data[0]&15) * 4
+
+	return data[:header+min(payload, len(data)-header)]
+}
+
+// NewDestinationUnreachable creates Destination Unreachable message about p
+func NewDestinationUnreachable(code uint8, p *ipv4.Packet) *ErrorMessage {
+	return &ErrorMessage{Type: TypeDestinationUnreachable, Code: code, Original: Quote(p, QuotePayloadRFC792)}
+}
+
+// NewFragmentationNeeded creates Destination Unreachable message with
+// Fragmentation Needed code and MTU of the next hop from RFC1191
+func NewFragmentationNeeded(mtu uint16, p *ipv4.Packet) *ErrorMessage {
+	e := NewDestinationUnreachable(CodeFragmentationNeeded, p)
+	e.NextHopMTU = mtu
+
+	return e
+}
+
+// NewTimeExceeded creates Time Exceeded message about p
+func NewTimeExceeded(code uint8, p *ipv4.Packet) *ErrorMessage {
+	return &ErrorMessage{Type: TypeTimeExceeded, Code: code, Original: Quote(p, QuotePayloadRFC792)}
+}
+
+// NewParameterProblem creates Parameter Problem message pointing to octet
+// of p header where the problem is
+func NewParameterProblem(pointer uint8, p *ipv4.Packet) *ErrorMessage {
+	return &ErrorMessage{
+		Type:     TypeParameterProblem,
+		Code:     CodePointerIndicatesError,
+		Pointer:  pointer,
+		Original: Quote(p, QuotePayloadRFC792),
+	}
+}
+
+// NewRedirect creates Redirect message advising gateway for destination of p
+func NewRedirect(code uint8, gateway ipv4.IPAddr, p *ipv4.Packet) *ErrorMessage {
+	return &ErrorMessage{Type: TypeRedirect, Code: code, Gateway: gateway, Original: Quote(p, QuotePayloadRFC792)}
+}
+
+// IsError reports whether message type is an error one
+func IsError(t uint8) bool {
+	switch t {
+	case TypeDestinationUnreachable, TypeRedirect, TypeTimeExceeded, TypeParameterProblem:
+		return true
+	}
+
+	return false
+}
+
+// ErrorFromMessage converts generic message to error message
+func ErrorFromMessage(m *Message) (*ErrorMessage, error) {
+	if !IsError(m.Type) {
+		return nil, fmt.Errorf("%w: %d is not error", ErrBadType, m.Type)
+	}
+
+	e := &ErrorMessage{Type: m.Type, Code: m.Code, Original: m.Data}
+
+	switch m.Type {
+	case TypeParameterProblem:
+		e.Pointer = m.Rest[0]
+	case TypeRedirect:
+		copy(e.Gateway[:], m.Rest[:])
+	case TypeDestinationUnreachable:
+		if m.Code == CodeFragmentationNeeded {
+			e.NextHopMTU = binary.BigEndian.Uint16(m.Rest[2:4])
+		}
+	}
+
+	return e, nil
+}
+
+// Message converts error message to generic message
+func (e *ErrorMessage) Message() *Message {
+	m := &Message{Type: e.Type, Code: e.Code, Data: e.Original}
+
+	switch e.Type {
+	case TypeParameterProblem:
+		m.Rest[0] = e.Pointer
+	case TypeRedirect:
+		copy(m.Rest[:], e.Gateway[:])
+	case TypeDestinationUnreachable:
+		if e.Code == CodeFragmentationNeeded {
+			binary.BigEndian.PutUint16(m.Rest[2:4], e.NextHopMTU)
+		}
+	}
+
+	return m
+}
+
+// Quoted parses header of original datagram, Data of returned packet keeps
+// only quoted part of payload
+func (e *ErrorMessage) Quoted() (*ipv4.Packet, error) {
+	p := &ipv4.Packet{}
+
+	if err := p.UnmarshalHeader(e.Original); err != nil {
+		return nil, fmt.Errorf("failed to parse quoted datagram: %w", err)
+	}
+
+	return p, nil
+}
@@ -0,0 +1,98 @@
+package icmp
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/IvMaslov/ipv4"
+)
+
+func originalPacket() *ipv4.Packet {
+	src, _ := ipv4.IPFromString("192.168.0.10")
+	dst, _ := ipv4.IPFromString("8.8.8.8")
+
+	p := ipv4.New(src, dst, []byte{0x82, 0x9A, 0x82, 0x9B, 0, 20, 0, 0, 1, 2, 3, 4}).WithProtocol(ipv4.ProtocolUDP)
+	p.ID = 4242
+
+	return p
+}
+
+func Test_ErrorMessage_RoundTrip(t *testing.T) {
+	gateway, _ := ipv4.IPFromString("192.168.0.254")
+	orig := originalPacket()
+
+	tests := []struct {
+		name    string
+		message *ErrorMessage
+	}{
+		{
+			name:    "Port unreachable",
+			message: NewDestinationUnreachable(CodePortUnreachable, orig),
+		},
+		{
+			name:    "Fragmentation needed",
+			message: NewFragmentationNeeded(1400, orig),
+		},
+		{
+			name:    "TTL exceeded",
+			message: NewTimeExceeded(CodeTTLExceeded, orig),
+		},
+		{
+			name:    "Parameter problem",
+			message: NewParameterProblem(8, orig),
+		},
+		{
+			name:    "Redirect",
+			message: NewRedirect(CodeRedirectHost, gateway, orig),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m := &Message{}
+			if err := m.Unmarshal(test.message.Message().Marshal()); err != nil {
+				t.Fatal(err)
+			}
+
+			e, err := ErrorFromMessage(m)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if e.Type != test.message.Type || e.Code != test.message.Code || e.Pointer != test.message.Pointer ||
+				e.Gateway != test.message.Gateway || e.NextHopMTU != test.message.NextHopMTU {
+				t.Errorf("Message not expected %v", e)
+			}
+
+			quoted, err := e.Quoted()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if quoted.ID != orig.ID || quoted.Src != orig.Src || quoted.Dst != orig.Dst || !bytes.Equal(quoted.Data, orig.Data[:8]) {
+				t.Errorf("Quoted not expected %v", quoted)
+			}
+		})
+	}
+}
+
+func Test_ErrorFromMessage_NotError(t *testing.T) {
+	echo := &Echo{ID: 1}
+
+	if _, err := ErrorFromMessage(echo.Message()); !errors.Is(err, ErrBadType) {
+		t.Errorf("Error not expected %v", err)
+	}
+}
+
+func Test_Quote(t *testing.T) {
+	orig := originalPacket()
+
+	if len(Quote(orig, QuotePayloadRFC792)) != 28 {
+		t.Error("wrong length of RFC792 quote")
+	}
+
+	if len(Quote(orig, 1000)) != 32 {
+		t.Error("quote longer than datagram")
+	}
+}
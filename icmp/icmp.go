@@ -0,0 +1,107 @@
+// Package icmp implements ICMP for IPv4 from RFC792 on top of ipv4.IpSocket.
+// Pinger receives replies through ipv4.Dispatcher, which has to be running
+// while it pings, Ping sets up and runs dispatcher of socket by itself.
+package icmp
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/IvMaslov/ipv4"
+)
+
+// Message types from RFC792
+const (
+	TypeEchoReply              = 0
+	TypeDestinationUnreachable = 3
+	TypeRedirect               = 5
+	TypeEcho                   = 8
+	TypeTimeExceeded           = 11
+	TypeParameterProblem       = 12
+)
+
+const headerLength = 8
+
+var (
+	ErrTruncated   = errors.New("icmp message is truncated")
+	ErrBadChecksum = errors.New("wrong icmp checksum")
+	ErrBadType     = errors.New("unexpected icmp message type")
+)
+
+// 0                   1                   2                   3
+// 0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1
+// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+// |     Type      |     Code      |          Checksum             |
+// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+// |                    Rest of header                             |
+// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+// |     Data ...
+// +-+-+-+-+-
+//
+//	Generic ICMP message, meaning of Rest depends on Type
+type Message struct {
+	Type     uint8
+	Code     uint8
+	Checksum uint16
+	Rest     [4]byte
+	Data     []byte
+}
+
+// Marshal returns wire representation of message with calculated checksum
+func (m *Message) Marshal() []byte {
+	buf := make([]byte, headerLength+len(m.Data))
+
+	buf[0] = m.Type
+	buf[1] = m.Code
+	copy(buf[4:8], m.Rest[:])
+	copy(buf[8:], m.Data)
+
+	binary.BigEndian.PutUint16(buf[2:4], checksum(buf))
+
+	return buf
+}
+
+// Unmarshal parses message from b and verifies its checksum
+func (m *Message) Unmarshal(b []byte) error {
+	if len(b) < headerLength {
+		return fmt.Errorf("%w: got %d bytes", ErrTruncated, len(b))
+	}
+
+	if checksum(b) != 0 {
+		return ErrBadChecksum
+	}
+
+	m.Type = b[0]
+	m.Code = b[1]
+	m.Checksum = binary.BigEndian.Uint16(b[2:4])
+	copy(m.Rest[:], b[4:8])
+	m.Data = b[8:]
+
+	return nil
+}
+
+// FromPacket parses ICMP message carried by packet
+func FromPacket(p *ipv4.Packet) (*Message, error) {
+	if p.Protocol != ipv4.ProtocolICMP {
+		return nil, fmt.Errorf("packet of protocol %d is not icmp", p.Protocol)
+	}
+
+	m := &Message{}
+
+	if err := m.Unmarshal(p.Data); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// Packet wraps message to ICMP packet from src to dst
+func (m *Message) Packet(src, dst ipv4.IPAddr) *ipv4.Packet {
+	return ipv4.New(src, dst, m.Marshal()).WithProtocol(ipv4.ProtocolICMP)
+}
+
+// checksum uses the same algorithm as IP header checksum
+func checksum(b []byte) uint16 {
+	return (&ipv4.Packet{}).CalculateChecksum(b)
+}
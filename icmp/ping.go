@@ -0,0 +1,339 @@
+package icmp
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"github.com/IvMaslov/ipv4"
+)
+
+const (
+	defaultPingCount    = 4
+	defaultPingInterval = time.Second
+	defaultPingTimeout  = time.Second
+	defaultPingSize     = 56
+)
+
+// ErrPingerClosed is returned by Ping after Close
+var ErrPingerClosed = errors.New("pinger is closed")
+
+// PingOptions describes probes of Ping, zero fields are replaced by defaults:
+// 4 probes of 56 bytes every second waiting reply for a second
+type PingOptions struct {
+	Count    int
+	Interval time.Duration // time between starts of probes
+	Timeout  time.Duration // time to wait reply of each probe
+	Size     int           // bytes of echo data
+	TTL      uint8         // zero keeps default TTL of ipv4.New
+}
+
+// ProbeResult is outcome of single echo request
+type ProbeResult struct {
+	Seq      uint16
+	Received bool
+	From     ipv4.IPAddr
+	RTT      time.Duration
+	TTL      uint8 // TTL of reply
+}
+
+// PingStats contains per-probe results and RTT statistics of received replies
+type PingStats struct {
+	Probes   []ProbeResult
+	Sent     int
+	Received int
+	Loss     float64 // fraction of probes without reply
+	MinRTT   time.Duration
+	AvgRTT   time.Duration
+	MaxRTT   time.Duration
+	StdDev   time.Duration
+}
+
+type echoReply struct {
+	from ipv4.IPAddr
+	ttl  uint8
+	at   time.Time
+}
+
+// pendingProbe waits reply from dst, any host may reply to broadcast or
+// multicast probe
+type pendingProbe struct {
+	dst     ipv4.IPAddr
+	unicast bool
+	replies chan echoReply
+}
+
+// Pinger sends echo requests through dispatcher socket and matches replies
+// by identifier and sequence number, replies to unicast probes have to come
+// from the probed address. Dispatcher has to be running.
+type Pinger struct {
+	d      *ipv4.Dispatcher
+	id     uint16
+	remove func()
+
+	mu      sync.Mutex
+	seq     uint16
+	pending map[uint16]pendingProbe
+	closed  bool
+}
+
+// NewPinger creates pinger with random identifier and registers its handler
+// of echo replies in d
+func NewPinger(d *ipv4.Dispatcher) *Pinger {
+	p := &Pinger{
+		d:       d,
+		id:      uint16(rand.Uint32()),
+		pending: make(map[uint16]pendingProbe),
+	}
+
+	p.remove = d.HandleProtocol(ipv4.ProtocolICMP, p.handle)
+
+	return p
+}
+
+// ID returns identifier of echo requests
+func (p *Pinger) ID() uint16 {
+	return p.id
+}
+
+// Close removes handler of echo replies
+func (p *Pinger) Close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !p.closed {
+		p.closed = true
+		p.remove()
+	}
+}
+
+// Ping sends echo requests to dst and waits replies. Probes without reply
+// are reported as not received, error is returned only when request can not
+// be sent or ctx is done.
+func (p *Pinger) Ping(ctx context.Context, dst ipv4.IPAddr, opts PingOptions) (*PingStats, error) {
+	opts = withDefaults(opts)
+	stats := &PingStats{}
+
+	for i := 0; i < opts.Count; i++ {
+		start := time.Now()
+
+		result, err := p.probe(ctx, dst, opts)
+		if err != nil {
+			stats.calculate()
+			return stats, err
+		}
+
+		stats.Probes = append(stats.Probes, result)
+
+		if i == opts.Count-1 {
+			break
+		}
+
+		select {
+		case <-ctx.Done():
+			stats.calculate()
+			return stats, ctx.Err()
+		case <-time.After(time.Until(start.Add(opts.Interval))):
+		}
+	}
+
+	stats.calculate()
+
+	return stats, nil
+}
+
+// Ping sends echo requests to dst through sock as Pinger.Ping does, dispatcher
+// of sock is created and run only for the time of ping, so sock must not be
+// read by anybody else meanwhile. Reading is checked between packets, thus
+// dispatcher stops after the next packet is read. Failed reading of sock
+// stops ping with its error.
+func Ping(ctx context.Context, sock *ipv4.IpSocket, dst ipv4.IPAddr, opts PingOptions) (*PingStats, error) {
+	pingCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	d := ipv4.NewDispatcher(sock)
+
+	p := NewPinger(d)
+	defer p.Close()
+
+	readErr := make(chan error, 1)
+
+	go func() {
+		readErr <- d.Run(pingCtx)
+		cancel()
+	}()
+
+	stats, err := p.Ping(pingCtx, dst, opts)
+	if err != nil && ctx.Err() == nil {
+		select {
+		case err = <-readErr:
+		default:
+		}
+	}
+
+	return stats, err
+}
+
+func (p *Pinger) probe(ctx context.Context, dst ipv4.IPAddr, opts PingOptions) (ProbeResult, error) {
+	sock := p.d.Socket()
+
+	seq, replies, err := p.register(dst, !isBroadcast(sock, dst))
+	if err != nil {
+		return ProbeResult{}, err
+	}
+	defer p.unregister(seq)
+
+	echo := &Echo{ID: p.id, Seq: seq, Data: make([]byte, opts.Size)}
+	packet := echo.Message().Packet(sock.GetIpAddr(), dst)
+
+	if opts.TTL != 0 {
+		packet.TTL = opts.TTL
+	}
+
+	result := ProbeResult{Seq: seq}
+	sent := time.Now()
+
+	if err := sock.WritePacket(packet); err != nil {
+		return result, err
+	}
+
+	timer := time.NewTimer(opts.Timeout)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return result, ctx.Err()
+	case <-timer.C:
+		return result, nil
+	case reply := <-replies:
+		result.Received = true
+		result.From = reply.from
+		result.TTL = reply.ttl
+		result.RTT = reply.at.Sub(sent)
+
+		return result, nil
+	}
+}
+
+func (p *Pinger) register(dst ipv4.IPAddr, unicast bool) (uint16, chan echoReply, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return 0, nil, ErrPingerClosed
+	}
+
+	p.seq++
+
+	replies := make(chan echoReply, 1)
+	p.pending[p.seq] = pendingProbe{dst: dst, unicast: unicast, replies: replies}
+
+	return p.seq, replies, nil
+}
+
+func (p *Pinger) unregister(seq uint16) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	delete(p.pending, seq)
+}
+
+func (p *Pinger) handle(packet *ipv4.Packet) {
+	at := time.Now()
+
+	m, err := FromPacket(packet)
+	if err != nil || m.Type != TypeEchoReply {
+		return
+	}
+
+	echo, err := EchoFromMessage(m)
+	if err != nil || echo.ID != p.id {
+		return
+	}
+
+	p.mu.Lock()
+	probe, ok := p.pending[echo.Seq]
+	p.mu.Unlock()
+
+	if !ok || (probe.unicast && packet.Src != probe.dst) {
+		return
+	}
+
+	select {
+	case probe.replies <- echoReply{from: packet.Src, ttl: packet.TTL, at: at}:
+	default: // duplicate reply
+	}
+}
+
+// isBroadcast reports whether dst is broadcast or multicast address, so
+// replies to it may come from any host
+func isBroadcast(sock *ipv4.IpSocket, dst ipv4.IPAddr) bool {
+	if dst[0] >= 224 && dst[0] < 240 {
+		return true
+	}
+
+	if sock == nil {
+		return dst == ipv4.IPAddr{255, 255, 255, 255}
+	}
+
+	return sock.IsBroadcast(dst)
+}
+
+func withDefaults(opts PingOptions) PingOptions {
+	if opts.Count <= 0 {
+		opts.Count = defaultPingCount
+	}
+
+	if opts.Interval <= 0 {
+		opts.Interval = defaultPingInterval
+	}
+
+	if opts.Timeout <= 0 {
+		opts.Timeout = defaultPingTimeout
+	}
+
+	if opts.Size <= 0 {
+		opts.Size = defaultPingSize
+	}
+
+	return opts
+}
+
+func (s *PingStats) calculate() {
+	s.Sent = len(s.Probes)
+	s.Received = 0
+
+	var sum, sumSquares float64
+
+	for _, probe := range s.Probes {
+		if !probe.Received {
+			continue
+		}
+
+		if s.Received == 0 || probe.RTT < s.MinRTT {
+			s.MinRTT = probe.RTT
+		}
+
+		if probe.RTT > s.MaxRTT {
+			s.MaxRTT = probe.RTT
+		}
+
+		s.Received++
+		sum += float64(probe.RTT)
+		sumSquares += float64(probe.RTT) * float64(probe.RTT)
+	}
+
+	if s.Sent > 0 {
+		s.Loss = float64(s.Sent-s.Received) / float64(s.Sent)
+	}
+
+	if s.Received > 0 {
+		avg := sum / float64(s.Received)
+
+		s.AvgRTT = time.Duration(avg)
+		s.StdDev = time.Duration(math.Sqrt(math.Max(sumSquares/float64(s.Received)-avg*avg, 0)))
+	}
+}
@@ -0,0 +1,98 @@
+package icmp
+
+import (
+	"testing"
+	"time"
+
+	"github.com/IvMaslov/ipv4"
+)
+
+func Test_PingStats_calculate(t *testing.T) {
+	stats := &PingStats{Probes: []ProbeResult{
+		{Seq: 1, Received: true, RTT: 10 * time.Millisecond},
+		{Seq: 2},
+		{Seq: 3, Received: true, RTT: 30 * time.Millisecond},
+		{Seq: 4, Received: true, RTT: 20 * time.Millisecond},
+	}}
+
+	stats.calculate()
+
+	if stats.Sent != 4 || stats.Received != 3 || stats.Loss != 0.25 {
+		t.Errorf("Counters not expected %d %d %f", stats.Sent, stats.Received, stats.Loss)
+	}
+
+	if stats.MinRTT != 10*time.Millisecond || stats.MaxRTT != 30*time.Millisecond || stats.AvgRTT != 20*time.Millisecond {
+		t.Errorf("RTT not expected %v %v %v", stats.MinRTT, stats.AvgRTT, stats.MaxRTT)
+	}
+
+	if stats.StdDev < 8*time.Millisecond || stats.StdDev > 9*time.Millisecond {
+		t.Errorf("Deviation not expected %v", stats.StdDev)
+	}
+}
+
+func Test_Pinger_handle(t *testing.T) {
+	d := ipv4.NewDispatcher(nil)
+	p := NewPinger(d)
+	defer p.Close()
+
+	from, _ := ipv4.IPFromString("10.0.0.1")
+	stranger, _ := ipv4.IPFromString("10.0.0.2")
+
+	seq, replies, err := p.register(from, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	other := &Echo{Reply: true, ID: p.ID() + 1, Seq: seq}
+	d.Dispatch(other.Message().Packet(from, ipv4.IPAddr{}))
+
+	request := &Echo{ID: p.ID(), Seq: seq}
+	d.Dispatch(request.Message().Packet(from, ipv4.IPAddr{}))
+
+	spoofed := &Echo{Reply: true, ID: p.ID(), Seq: seq}
+	d.Dispatch(spoofed.Message().Packet(stranger, ipv4.IPAddr{}))
+
+	select {
+	case <-replies:
+		t.Fatal("reply of other pinger, request or reply of other host matched")
+	default:
+	}
+
+	reply := &Echo{Reply: true, ID: p.ID(), Seq: seq}
+	d.Dispatch(reply.Message().Packet(from, ipv4.IPAddr{}))
+
+	select {
+	case r := <-replies:
+		if r.from != from {
+			t.Errorf("Reply source not expected %v", r.from)
+		}
+	default:
+		t.Fatal("reply not matched")
+	}
+}
+
+func Test_Pinger_handle_Broadcast(t *testing.T) {
+	d := ipv4.NewDispatcher(nil)
+	p := NewPinger(d)
+	defer p.Close()
+
+	group, _ := ipv4.IPFromString("224.0.0.1")
+	from, _ := ipv4.IPFromString("10.0.0.2")
+
+	seq, replies, err := p.register(group, !isBroadcast(nil, group))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reply := &Echo{Reply: true, ID: p.ID(), Seq: seq}
+	d.Dispatch(reply.Message().Packet(from, ipv4.IPAddr{}))
+
+	select {
+	case r := <-replies:
+		if r.from != from {
+			t.Errorf("Reply source not expected %v", r.from)
+		}
+	default:
+		t.Fatal("reply to multicast probe not matched")
+	}
+}
@@ -0,0 +1,163 @@
+package icmp
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/IvMaslov/ipv4"
+)
+
+const (
+	defaultReportRate  = 100 // messages per second
+	defaultReportBurst = 10
+)
+
+// ErrRateLimited is returned when error message is suppressed by rate limit
+var ErrRateLimited = errors.New("icmp error rate limit exceeded")
+
+// Reporter sends ICMP error messages about datagrams dropped by socket. It
+// never reports errors about ICMP errors, fragments except the first one,
+// broadcast or multicast datagrams and datagrams of local origin, as RFC1122
+// requires. Messages are rate limited.
+type Reporter struct {
+	sock *ipv4.IpSocket
+
+	// FullQuote makes messages quote as much of original datagram as fits
+	// 576 bytes (RFC1812) instead of header and 8 bytes (RFC792)
+	FullQuote bool
+
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// NewReporter creates reporter sending messages through sock
+func NewReporter(sock *ipv4.IpSocket) *Reporter {
+	return &Reporter{
+		sock:   sock,
+		rate:   defaultReportRate,
+		burst:  defaultReportBurst,
+		tokens: defaultReportBurst,
+	}
+}
+
+// EnableErrors makes sock emit ICMP error messages for dropped datagrams
+func EnableErrors(sock *ipv4.IpSocket) *Reporter {
+	r := NewReporter(sock)
+	sock.SetErrorReporter(r)
+
+	return r
+}
+
+// SetRateLimit sets up count of messages per second and burst size
+func (r *Reporter) SetRateLimit(perSecond, burst int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.rate = float64(perSecond)
+	r.burst = float64(burst)
+	r.tokens = min(r.tokens, r.burst)
+}
+
+// ReportError implements ipv4.ErrorReporter
+func (r *Reporter) ReportError(reason ipv4.ErrorReason, p *ipv4.Packet, mtu int) error {
+	var e *ErrorMessage
+
+	switch reason {
+	case ipv4.ReasonTTLExpired:
+		e = NewTimeExceeded(CodeTTLExceeded, p)
+	case ipv4.ReasonProtocolUnreachable:
+		e = NewDestinationUnreachable(CodeProtocolUnreachable, p)
+	case ipv4.ReasonFragmentationNeeded:
+		e = NewFragmentationNeeded(uint16(mtu), p)
+	case ipv4.ReasonNetUnreachable:
+		e = NewDestinationUnreachable(CodeNetUnreachable, p)
+	case ipv4.ReasonSourceRouteFailed:
+		e = NewDestinationUnreachable(CodeSourceRouteFailed, p)
+	default:
+		return fmt.Errorf("unsupported reason %v", reason)
+	}
+
+	return r.Send(e, p)
+}
+
+// Send sends error message about p to its source
+func (r *Reporter) Send(e *ErrorMessage, p *ipv4.Packet) error {
+	local := r.sock.GetIpAddr()
+
+	if !ShouldReport(p) || p.Src == local {
+		return nil
+	}
+
+	if !r.allow(time.Now()) {
+		return ErrRateLimited
+	}
+
+	if r.FullQuote {
+		e.Original = fullQuote(p)
+	}
+
+	return r.sock.WritePacket(e.Message().Packet(local, p.Src))
+}
+
+// fullQuote quotes as much of p as fits error message of maxErrorDatagram
+// bytes with IP and ICMP headers
+func fullQuote(p *ipv4.Packet) []byte {
+	return Quote(p, maxErrorDatagram-20-headerLength-p.HeaderLength())
+}
+
+func (r *Reporter) allow(now time.Time) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if !r.last.IsZero() {
+		r.tokens = min(r.burst, r.tokens+now.Sub(r.last).Seconds()*r.rate)
+	}
+
+	r.last = now
+
+	if r.tokens < 1 {
+		return false
+	}
+
+	r.tokens--
+
+	return true
+}
+
+// ShouldReport reports whether ICMP error message may be sent about p
+func ShouldReport(p *ipv4.Packet) bool {
+	if p.FlFrOff.FragmentOffset() != 0 {
+		return false
+	}
+
+	if !unicast(p.Src) || !unicast(p.Dst) {
+		return false
+	}
+
+	// the first octet is message type even when message is damaged
+	if p.Protocol == ipv4.ProtocolICMP && len(p.Data) > 0 && IsError(p.Data[0]) {
+		return false
+	}
+
+	return true
+}
+
+func unicast(addr ipv4.IPAddr) bool {
+	switch {
+	case addr == ipv4.IPAddr{}:
+		return false
+	case addr == ipv4.IPAddr{255, 255, 255, 255}:
+		return false
+	case addr[0] >= 224: // multicast and reserved
+		return false
+	case addr[0] == 127:
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,78 @@
+package icmp
+
+import (
+	"testing"
+	"time"
+
+	"github.com/IvMaslov/ipv4"
+)
+
+func Test_ShouldReport(t *testing.T) {
+	unreachable := NewDestinationUnreachable(CodeHostUnreachable, originalPacket()).Message()
+	echo := (&Echo{ID: 1}).Message()
+
+	src, _ := ipv4.IPFromString("192.168.0.10")
+	dst, _ := ipv4.IPFromString("8.8.8.8")
+
+	fragment := originalPacket()
+	fragment.FlFrOff.SetFragmentOffset(8)
+
+	broadcast := originalPacket()
+	broadcast.Dst = ipv4.IPAddr{255, 255, 255, 255}
+
+	multicast := originalPacket()
+	multicast.Src = ipv4.IPAddr{224, 0, 0, 1}
+
+	tests := []struct {
+		name     string
+		packet   *ipv4.Packet
+		expected bool
+	}{
+		{name: "UDP datagram", packet: originalPacket(), expected: true},
+		{name: "Echo request", packet: echo.Packet(src, dst), expected: true},
+		{name: "ICMP error", packet: unreachable.Packet(src, dst)},
+		{name: "Not first fragment", packet: fragment},
+		{name: "Broadcast destination", packet: broadcast},
+		{name: "Multicast source", packet: multicast},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if ShouldReport(test.packet) != test.expected {
+				t.Errorf("Report not expected %v", !test.expected)
+			}
+		})
+	}
+}
+
+func Test_Reporter_allow(t *testing.T) {
+	r := NewReporter(nil)
+	r.SetRateLimit(1, 2)
+
+	now := time.Now()
+
+	if !r.allow(now) || !r.allow(now) || r.allow(now) {
+		t.Error("burst not respected")
+	}
+
+	if !r.allow(now.Add(1100 * time.Millisecond)) {
+		t.Error("tokens not refilled")
+	}
+}
+
+func Test_fullQuote(t *testing.T) {
+	src, _ := ipv4.IPFromString("192.168.0.10")
+	dst, _ := ipv4.IPFromString("8.8.8.8")
+
+	// IHL is not updated by WithOptions
+	p := ipv4.New(src, dst, make([]byte, 1000)).WithOptions(ipv4.Option{Type: ipv4.OptionType{Value: 7}, Length: 7, Value: make([]byte, 5)})
+
+	quote := fullQuote(p)
+	if len(quote) != maxErrorDatagram-20-headerLength {
+		t.Errorf("Quote length not expected %d", len(quote))
+	}
+
+	if quote[0]&15 != 7 {
+		t.Errorf("Quoted header not expected %v", quote[:20])
+	}
+}
@@ -0,0 +1,102 @@
+package ipv4
+
+import (
+	"hash/maphash"
+	"math/rand/v2"
+	"sync"
+)
+
+// IDGenerator assigns Identification field to outgoing datagrams
+type IDGenerator interface {
+	NextID(src, dst IPAddr, protocol uint8) uint16
+}
+
+// IDGeneratorFunc allows to use function as IDGenerator
+type IDGeneratorFunc func(src, dst IPAddr, protocol uint8) uint16
+
+func (f IDGeneratorFunc) NextID(src, dst IPAddr, protocol uint8) uint16 {
+	return f(src, dst, protocol)
+}
+
+const idCounters = 4096
+
+// sequentialIDGenerator implements double hash algorithm from RFC7739: flows
+// are spread over a table of counters by a keyed hash and each flow gets its
+// own secret offset, so IDs are sequential for a destination but can not be
+// predicted by other ones.
+type sequentialIDGenerator struct {
+	mu       sync.Mutex
+	counters [idCounters]uint16
+	index    maphash.Seed
+	offset   maphash.Seed
+}
+
+// NewSequentialIDGenerator creates generator with sequential IDs for every
+// (src, dst, protocol) and random starting point for each of them
+func NewSequentialIDGenerator() IDGenerator {
+	g := &sequentialIDGenerator{
+		index:  maphash.MakeSeed(),
+		offset: maphash.MakeSeed(),
+	}
+
+	for i := range g.counters {
+		g.counters[i] = uint16(rand.Uint32())
+	}
+
+	return g
+}
+
+func (g *sequentialIDGenerator) NextID(src, dst IPAddr, protocol uint8) uint16 {
+	var flow [9]byte
+	copy(flow[0:4], src[:])
+	copy(flow[4:8], dst[:])
+	flow[8] = protocol
+
+	index := maphash.Bytes(g.index, flow[:]) % idCounters
+	offset := uint16(maphash.Bytes(g.offset, flow[:]))
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.counters[index]++
+
+	return g.counters[index] + offset
+}
+
+// randomIDGenerator picks IDs at random avoiding recently used ones,
+// as RFC6864 and RFC7739 allow for datagrams which may be fragmented
+type randomIDGenerator struct {
+	mu     sync.Mutex
+	recent [256]uint16
+	used   map[uint16]struct{}
+	next   int
+}
+
+// NewRandomIDGenerator creates generator of random IDs which does not repeat
+// any of the last 256 ones
+func NewRandomIDGenerator() IDGenerator {
+	return &randomIDGenerator{used: make(map[uint16]struct{}, 256)}
+}
+
+func (g *randomIDGenerator) NextID(src, dst IPAddr, protocol uint8) uint16 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	for {
+		id := uint16(rand.Uint32())
+
+		if _, ok := g.used[id]; ok {
+			continue
+		}
+
+		if len(g.used) == len(g.recent) {
+			delete(g.used, g.recent[g.next])
+		}
+
+		g.recent[g.next] = id
+		g.used[id] = struct{}{}
+		g.next = (g.next + 1) % len(g.recent)
+
+		return id
+	}
+}
@@ -0,0 +1,97 @@
+package ipv4
+
+import "testing"
+
+func Test_SequentialIDGenerator_NextID(t *testing.T) {
+	g := NewSequentialIDGenerator()
+
+	src, _ := IPFromString("192.168.0.1")
+	dst1, _ := IPFromString("10.0.0.1")
+	dst2, _ := IPFromString("10.0.0.2")
+
+	first := g.NextID(src, dst1, ProtocolUDP)
+	g.NextID(src, dst2, ProtocolUDP)
+
+	for i := uint16(1); i < 1000; i++ {
+		if id := g.NextID(src, dst1, ProtocolUDP); id != first+i {
+			t.Fatalf("ID not sequential %d after %d", id, first+i-1)
+		}
+	}
+}
+
+func Test_RandomIDGenerator_NextID(t *testing.T) {
+	g := NewRandomIDGenerator()
+
+	seen := make(map[uint16]struct{})
+
+	for i := 0; i < 256; i++ {
+		id := g.NextID(IPAddr{}, IPAddr{}, ProtocolUDP)
+
+		if _, ok := seen[id]; ok {
+			t.Fatalf("ID %d repeated", id)
+		}
+
+		seen[id] = struct{}{}
+	}
+}
+
+func Test_IDGeneratorFunc_NextID(t *testing.T) {
+	var next uint16
+
+	g := IDGeneratorFunc(func(src, dst IPAddr, protocol uint8) uint16 {
+		next++
+		return next
+	})
+
+	if g.NextID(IPAddr{}, IPAddr{}, 0) != 1 || g.NextID(IPAddr{}, IPAddr{}, 0) != 2 {
+		t.Error("function is not used")
+	}
+}
+
+func Test_IpSocket_SetIDGenerator_Nil(t *testing.T) {
+	is, _ := arpSocket(t, "eth0", "192.168.0.1", 24)
+	is.SetIDGenerator(nil)
+
+	if is.GetIDGenerator() == nil {
+		t.Fatal("Default generator not restored")
+	}
+
+	dst, _ := IPFromString("192.168.0.254")
+	if err := is.WriteTo(dst, []byte("data")); err != nil {
+		t.Errorf("Error not expected %v", err)
+	}
+}
+
+func Test_IpSocket_WritePacket_ID(t *testing.T) {
+	is, r := arpSocket(t, "eth0", "192.168.0.1", 24)
+	is.SetIDGenerator(IDGeneratorFunc(func(src, dst IPAddr, protocol uint8) uint16 {
+		return 7
+	}))
+
+	dst, _ := IPFromString("192.168.0.254")
+
+	unset := New(is.GetIpAddr(), dst, nil)
+	set := New(is.GetIpAddr(), dst, nil)
+	set.ID = 42
+
+	for _, p := range []*Packet{unset, set} {
+		if err := is.WritePacket(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if len(r.frames) != 2 {
+		t.Fatalf("Frames not expected %v", r.frames)
+	}
+
+	for i, expected := range []uint16{7, 42} {
+		written := &Packet{}
+		if err := written.Unmarshal(r.frames[i].payload); err != nil || written.ID != expected {
+			t.Errorf("Written not expected %v %v", written, err)
+		}
+	}
+
+	if unset.ID != 0 {
+		t.Errorf("Packet changed %v", unset)
+	}
+}
@@ -0,0 +1,193 @@
+package ipv4
+
+import (
+	"errors"
+	"net"
+	"time"
+
+	"github.com/IvMaslov/ethernet"
+	"github.com/IvMaslov/ipv4/arp"
+)
+
+// ErrNoFrameWriter is returned when ARP is enabled on socket created without
+// link, which can not choose destination hardware address of frames
+var ErrNoFrameWriter = errors.New("socket has no link writing frames to hardware address")
+
+// ErrARPDisabled is returned when routing table is set up on socket without
+// ARP, which can not send datagram to the next hop
+var ErrARPDisabled = errors.New("arp is disabled")
+
+// FrameWriter sends frames to given hardware address, it is required by ARP
+// resolution (see NewIpSocketWithLink)
+type FrameWriter interface {
+	WriteFrame(dst net.HardwareAddr, etherType uint16, payload []byte) error
+}
+
+// EnableARP makes WritePacket send datagrams to hardware address of the next
+// hop resolved by ARP with cache aging from cfg, ReadPacket answers requests
+// for address of interface. Gateway is added to cache when its hardware
+// address is known.
+func (is *IpSocket) EnableARP(cfg arp.Config) error {
+	if is.link == nil {
+		return ErrNoFrameWriter
+	}
+
+	neighbors := arp.NewCache(cfg)
+
+	if gw := is.gatewayInfo.IP.To4(); gw != nil && len(is.gatewayInfo.HardAddr) != 0 {
+		neighbors.Update([4]byte(gw), is.gatewayInfo.HardAddr, true, time.Now())
+	}
+
+	is.neighbors = neighbors
+
+	return nil
+}
+
+// DisableARP makes socket write datagrams without hardware address, routing
+// table is removed as well (see SetRouteTable)
+func (is *IpSocket) DisableARP() {
+	is.neighbors = nil
+	is.routes = nil
+}
+
+// Neighbors returns ARP cache, it is nil when ARP is disabled
+func (is *IpSocket) Neighbors() *arp.Cache {
+	return is.neighbors
+}
+
+// AnnounceARP broadcasts gratuitous ARP to update caches of neighbors with
+// address of interface
+func (is *IpSocket) AnnounceARP() error {
+	if is.link == nil {
+		return ErrNoFrameWriter
+	}
+
+	return is.writeARP(arp.Broadcast, arp.NewGratuitous(is.GetMac(), is.GetIpAddr()))
+}
+
+// nextHop returns address which datagram is sent to without routing table:
+// destination on interface network, otherwise gateway
+func (is *IpSocket) nextHop(dst IPAddr) IPAddr {
+	if is.ipNet != nil && is.ipNet.Contains(net.IP(dst[:])) {
+		return dst
+	}
+
+	gw := is.gatewayInfo.IP.To4()
+	if gw == nil {
+		return dst
+	}
+
+	return IPAddr(gw)
+}
+
+// IsBroadcast reports whether dst is limited broadcast or directed broadcast
+// of interface network
+func (is *IpSocket) IsBroadcast(dst IPAddr) bool {
+	if dst == broadcastIP {
+		return true
+	}
+
+	if is.ipNet == nil || len(is.ipNet.Mask) != 4 || !is.ipNet.Contains(net.IP(dst[:])) {
+		return false
+	}
+
+	for i := range dst {
+		if dst[i]|is.ipNet.Mask[i] != 0xFF {
+			return false
+		}
+	}
+
+	return true
+}
+
+// writeFrame sends marshaled datagram to hardware address of the next hop,
+// datagram is queued while address is resolved
+func (is *IpSocket) writeFrame(dst, hop IPAddr, data []byte) error {
+	neighbors := is.neighbors
+	if neighbors == nil {
+		return is.ethSock.Write(data)
+	}
+
+	if is.IsBroadcast(dst) {
+		return is.link.WriteFrame(arp.Broadcast, uint16(ethernet.EtherTypeIPv4), data)
+	}
+
+	hw, request, err := neighbors.Resolve(hop, data, time.Now())
+	if err != nil {
+		return err
+	}
+
+	if request {
+		if err := is.writeARP(arp.Broadcast, arp.NewRequest(is.GetMac(), is.GetIpAddr(), hop)); err != nil {
+			return err
+		}
+	}
+
+	if hw == nil {
+		return nil
+	}
+
+	return is.link.WriteFrame(hw, uint16(ethernet.EtherTypeIPv4), data)
+}
+
+// handleARP updates cache from received packet following RFC826, sends
+// datagrams waiting for sender and answers requests for address of
+// interface. Gratuitous packets only refresh existing entries.
+func (is *IpSocket) handleARP(payload []byte) error {
+	neighbors := is.neighbors
+	if neighbors == nil {
+		return nil
+	}
+
+	p := &arp.Packet{}
+	if err := p.Unmarshal(payload); err != nil {
+		return err
+	}
+
+	local := is.GetIpAddr()
+	if p.SenderIP == local {
+		return nil
+	}
+
+	forUs := p.TargetIP == local && !p.IsGratuitous()
+
+	for _, data := range neighbors.Update(p.SenderIP, p.SenderHW, forUs, time.Now()) {
+		if err := is.link.WriteFrame(p.SenderHW, uint16(ethernet.EtherTypeIPv4), data); err != nil {
+			return err
+		}
+	}
+
+	if forUs && p.Op == arp.OpRequest {
+		return is.writeARP(p.SenderHW, p.ReplyTo(is.GetMac()))
+	}
+
+	return nil
+}
+
+func (is *IpSocket) writeARP(dst net.HardwareAddr, p *arp.Packet) error {
+	return is.link.WriteFrame(dst, arp.EtherType, p.Marshal())
+}
+
+// interfaceNetwork returns network of interface address ip
+func interfaceNetwork(iface *net.Interface, ip net.IP) *net.IPNet {
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil
+	}
+
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok || !ipNet.IP.Equal(ip) || ipNet.IP.To4() == nil {
+			continue
+		}
+
+		mask := ipNet.Mask
+		if len(mask) == net.IPv6len {
+			mask = mask[12:]
+		}
+
+		return &net.IPNet{IP: ipNet.IP.To4().Mask(mask), Mask: mask}
+	}
+
+	return nil
+}
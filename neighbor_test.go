@@ -0,0 +1,273 @@
+package ipv4
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/IvMaslov/ethernet"
+	"github.com/IvMaslov/ipv4/arp"
+	"github.com/IvMaslov/netutils"
+)
+
+type frame struct {
+	dst       net.HardwareAddr
+	etherType uint16
+	payload   []byte
+}
+
+// frameRecorder keeps frames written by socket
+type frameRecorder struct {
+	frames []frame
+}
+
+func (r *frameRecorder) WriteFrame(dst net.HardwareAddr, etherType uint16, payload []byte) error {
+	r.frames = append(r.frames, frame{dst: dst, etherType: etherType, payload: payload})
+	return nil
+}
+
+var (
+	localMac   = net.HardwareAddr{0x02, 0, 0, 0, 0, 0x01}
+	gatewayMac = net.HardwareAddr{0x02, 0, 0, 0, 0, 0xFE}
+	peerMac    = net.HardwareAddr{0x02, 0, 0, 0, 0, 0x02}
+)
+
+// arpSocket creates socket of interface name with address ip on network of
+// given prefix length, gateway is host 254 of network
+func arpSocket(t *testing.T, name, ip string, bits int) (*IpSocket, *frameRecorder) {
+	r := &frameRecorder{}
+	addr := net.ParseIP(ip).To4()
+	ipNet := &net.IPNet{IP: addr.Mask(net.CIDRMask(bits, 32)), Mask: net.CIDRMask(bits, 32)}
+
+	gateway := append(net.IP{}, ipNet.IP...)
+	gateway[3] = 254
+
+	is := newIpSocket(
+		name, r,
+		netutils.InterfaceInfo{IP: addr, HardAddr: localMac},
+		netutils.InterfaceInfo{IP: gateway, HardAddr: gatewayMac},
+		ipNet,
+		1500,
+	)
+
+	if is.Neighbors() == nil {
+		t.Fatal("ARP is not enabled with link")
+	}
+
+	return is, r
+}
+
+func Test_IpSocket_Name(t *testing.T) {
+	is, _ := arpSocket(t, "eth1", "10.0.0.1", 24)
+
+	if is.Name() != "eth1" {
+		t.Errorf("Name not expected %s", is.Name())
+	}
+}
+
+func Test_IpSocket_EnableARP_NoFrameWriter(t *testing.T) {
+	is := &IpSocket{}
+
+	if err := is.EnableARP(arp.Config{}); !errors.Is(err, ErrNoFrameWriter) {
+		t.Errorf("Error not expected %v", err)
+	}
+}
+
+func Test_IpSocket_WritePacket_NextHop(t *testing.T) {
+	tests := []struct {
+		name     string
+		dst      string
+		expected net.HardwareAddr
+	}{
+		{
+			name:     "Off-link via gateway",
+			dst:      "8.8.8.8",
+			expected: gatewayMac,
+		},
+		{
+			name:     "Limited broadcast",
+			dst:      "255.255.255.255",
+			expected: arp.Broadcast,
+		},
+		{
+			name:     "Directed broadcast",
+			dst:      "192.168.0.255",
+			expected: arp.Broadcast,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			is, r := arpSocket(t, "eth0", "192.168.0.1", 24)
+
+			dst, _ := IPFromString(test.dst)
+			if err := is.WriteTo(dst, []byte("data")); err != nil {
+				t.Fatal(err)
+			}
+
+			if len(r.frames) != 1 || r.frames[0].etherType != uint16(ethernet.EtherTypeIPv4) || !bytes.Equal(r.frames[0].dst, test.expected) {
+				t.Errorf("Frames not expected %v", r.frames)
+			}
+		})
+	}
+}
+
+func Test_IpSocket_WritePacket_Resolve(t *testing.T) {
+	is, r := arpSocket(t, "eth0", "192.168.0.1", 24)
+
+	dst, _ := IPFromString("192.168.0.2")
+	if err := is.WriteTo(dst, []byte("data")); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(r.frames) != 1 || r.frames[0].etherType != arp.EtherType || !bytes.Equal(r.frames[0].dst, arp.Broadcast) {
+		t.Fatalf("Frames not expected %v", r.frames)
+	}
+
+	request := &arp.Packet{}
+	if err := request.Unmarshal(r.frames[0].payload); err != nil {
+		t.Fatal(err)
+	}
+
+	if request.Op != arp.OpRequest || request.TargetIP != dst || request.SenderIP != is.GetIpAddr() {
+		t.Errorf("Request not expected %v", request)
+	}
+
+	reply := request.ReplyTo(peerMac)
+	if err := is.handleARP(reply.Marshal()); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(r.frames) != 2 || r.frames[1].etherType != uint16(ethernet.EtherTypeIPv4) || !bytes.Equal(r.frames[1].dst, peerMac) {
+		t.Fatalf("Queued datagram not sent %v", r.frames)
+	}
+
+	p := &Packet{}
+	if err := p.Unmarshal(r.frames[1].payload); err != nil || p.Dst != dst {
+		t.Errorf("Datagram not expected %v %v", p, err)
+	}
+
+	if err := is.WriteTo(dst, []byte("more")); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(r.frames) != 3 || !bytes.Equal(r.frames[2].dst, peerMac) {
+		t.Errorf("Frames not expected %v", r.frames)
+	}
+}
+
+func Test_IpSocket_handleARP(t *testing.T) {
+	peer := [4]byte{192, 168, 0, 2}
+	other := [4]byte{192, 168, 0, 3}
+
+	tests := []struct {
+		name    string
+		packet  *arp.Packet
+		reply   bool
+		learned bool
+	}{
+		{
+			name:    "Request for local address",
+			packet:  arp.NewRequest(peerMac, peer, [4]byte{192, 168, 0, 1}),
+			reply:   true,
+			learned: true,
+		},
+		{
+			name:   "Request for other host",
+			packet: arp.NewRequest(peerMac, peer, other),
+		},
+		{
+			name:   "Gratuitous of unknown host",
+			packet: arp.NewGratuitous(peerMac, peer),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			is, r := arpSocket(t, "eth0", "192.168.0.1", 24)
+
+			if err := is.handleARP(test.packet.Marshal()); err != nil {
+				t.Fatal(err)
+			}
+
+			if (len(r.frames) == 1) != test.reply {
+				t.Fatalf("Frames not expected %v", r.frames)
+			}
+
+			if test.reply {
+				reply := &arp.Packet{}
+				reply.Unmarshal(r.frames[0].payload)
+
+				if reply.Op != arp.OpReply || !bytes.Equal(reply.SenderHW, localMac) || !bytes.Equal(r.frames[0].dst, peerMac) {
+					t.Errorf("Reply not expected %v", reply)
+				}
+			}
+
+			if _, ok := is.Neighbors().Lookup(peer, time.Now()); ok != test.learned {
+				t.Errorf("Learned not expected %v", ok)
+			}
+		})
+	}
+}
+
+func Test_IpSocket_handleARP_Gratuitous(t *testing.T) {
+	is, r := arpSocket(t, "eth0", "192.168.0.1", 24)
+	gateway := [4]byte{192, 168, 0, 254}
+
+	if err := is.handleARP(arp.NewGratuitous(peerMac, gateway).Marshal()); err != nil {
+		t.Fatal(err)
+	}
+
+	n, _ := is.Neighbors().Lookup(gateway, time.Now())
+	if !bytes.Equal(n.HW, peerMac) || len(r.frames) != 0 {
+		t.Errorf("Neighbor not expected %v", n)
+	}
+
+	if err := is.AnnounceARP(); err != nil {
+		t.Fatal(err)
+	}
+
+	announce := &arp.Packet{}
+	if err := announce.Unmarshal(r.frames[0].payload); err != nil || !announce.IsGratuitous() {
+		t.Errorf("Announce not expected %v %v", announce, err)
+	}
+}
+
+// frames returns recv reading given frames and then io.EOF
+func frames(received ...frame) func() (uint16, []byte, error) {
+	return func() (uint16, []byte, error) {
+		if len(received) == 0 {
+			return 0, nil, io.EOF
+		}
+
+		f := received[0]
+		received = received[1:]
+
+		return f.etherType, f.payload, nil
+	}
+}
+
+func Test_IpSocket_ReadPacket_ARP(t *testing.T) {
+	is, r := arpSocket(t, "eth0", "192.168.0.1", 24)
+
+	request := arp.NewRequest(peerMac, [4]byte{192, 168, 0, 2}, [4]byte{192, 168, 0, 1})
+	is.recv = frames(
+		frame{etherType: arp.EtherType, payload: request.Marshal()},
+		frame{etherType: arp.EtherType, payload: []byte{0, 1}},
+	)
+
+	if _, err := is.ReadPacket(); !errors.Is(err, io.EOF) {
+		t.Fatalf("Error not expected %v", err)
+	}
+
+	if len(r.frames) != 1 || r.frames[0].etherType != arp.EtherType || !bytes.Equal(r.frames[0].dst, peerMac) {
+		t.Errorf("Frames not expected %v", r.frames)
+	}
+
+	if stats := is.Stats(); stats.BadARP != 1 {
+		t.Errorf("Stats not expected %v", stats)
+	}
+}
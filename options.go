@@ -1,5 +1,9 @@
 package ipv4
 
+import "fmt"
+
+const maxOptionsLength = 40 // IHL allows at most 60 bytes of header
+
 // 0                   1                   2                   3
 // 0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1
 // +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
@@ -62,27 +66,55 @@ type Option struct {
 	Value  []byte
 }
 
+// Marshal encodes option, length less than 2 is replaced by 2 and value
+// shorter than length is padded by zeros
 func (o *Option) Marshal() []byte {
 	if o.Type.Number() == 0 || o.Type.Number() == 1 {
 		return []byte{o.Type.Value}
 	}
 
-	return append([]byte{o.Type.Value, o.Length}, o.Value[:o.Length-2]...)
+	length := max(int(o.Length), 2)
+
+	buf := make([]byte, length)
+	buf[0] = o.Type.Value
+	buf[1] = uint8(length)
+	copy(buf[2:], o.Value)
+
+	return buf
 }
 
-func (o *Option) Unmarshal(b []byte) {
+// Unmarshal parses single option from the beginning of b, bytes after
+// option are ignored
+func (o *Option) Unmarshal(b []byte) error {
 	if len(b) == 0 {
-		return
+		return fmt.Errorf("%w: empty option", ErrBadOption)
 	}
 
 	o.Type.Value = b[0]
 
 	if o.Type.Number() == 0 || o.Type.Number() == 1 {
-		return
+		return nil
 	}
 
-	if len(b) >= 2 {
-		o.Length = b[1]
-		o.Value = b[2:o.Length]
+	if len(b) < 2 {
+		return fmt.Errorf("%w: option %d has no length", ErrBadOption, o.Type.Value)
 	}
+
+	if b[1] < 2 || int(b[1]) > len(b) {
+		return fmt.Errorf("%w: option %d has length %d, got %d bytes", ErrBadOption, o.Type.Value, b[1], len(b))
+	}
+
+	o.Length = b[1]
+	o.Value = b[2:o.Length]
+
+	return nil
+}
+
+// size returns count of bytes occupied by option
+func (o *Option) size() int {
+	if o.Type.Number() == 0 || o.Type.Number() == 1 {
+		return 1
+	}
+
+	return int(o.Length)
 }
@@ -0,0 +1,67 @@
+package ipv4
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// Router Alert option number, see OptionType.Number
+const OptionRouterAlert = 20
+
+// RouterAlertExamine is the only value defined by RFC2113: every router
+// shall examine packet
+const RouterAlertExamine = 0
+
+// +--------+--------+--------+--------+
+// |10010100|00000100|  2 octet value  |
+// +--------+--------+--------+--------+
+//
+//	Router Alert option from RFC2113
+//
+// The option tells routers on the path to examine contents of datagram even
+// when it is not addressed to them.
+type RouterAlertOption struct {
+	Value uint16
+}
+
+// NewRouterAlertOption creates option asking routers to examine packet
+func NewRouterAlertOption() *RouterAlertOption {
+	return &RouterAlertOption{Value: RouterAlertExamine}
+}
+
+// RouterAlertFromOption converts generic option to Router Alert option
+func RouterAlertFromOption(o Option) (*RouterAlertOption, error) {
+	if o.Type.Number() != OptionRouterAlert {
+		return nil, fmt.Errorf("%w: option %d is not router alert", ErrBadOption, o.Type.Value)
+	}
+
+	if o.Length != 4 || len(o.Value) != 2 {
+		return nil, fmt.Errorf("%w: router alert option has length %d", ErrBadOption, o.Length)
+	}
+
+	return &RouterAlertOption{Value: binary.BigEndian.Uint16(o.Value)}, nil
+}
+
+// Option converts Router Alert option to generic option
+func (r *RouterAlertOption) Option() Option {
+	return Option{
+		Type:   OptionType{Value: 0x80 | OptionRouterAlert},
+		Length: 4,
+		Value:  binary.BigEndian.AppendUint16(nil, r.Value),
+	}
+}
+
+// RouterAlert returns Router Alert option of packet if there is one
+func (p *Packet) RouterAlert() (*RouterAlertOption, bool) {
+	opt, ok := p.FindOption(OptionRouterAlert)
+	if !ok {
+		return nil, false
+	}
+
+	ra, err := RouterAlertFromOption(opt)
+	if err != nil {
+		return nil, false
+	}
+
+	return ra, true
+}
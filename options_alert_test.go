@@ -0,0 +1,108 @@
+package ipv4
+
+import (
+	"errors"
+	"testing"
+)
+
+func Test_RouterAlertFromOption(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    []byte
+		expected error
+		value    uint16
+	}{
+		{
+			name:  "Examine packet",
+			input: []byte{148, 4, 0, 0},
+			value: RouterAlertExamine,
+		},
+		{
+			name:  "Other value",
+			input: []byte{148, 4, 0, 1},
+			value: 1,
+		},
+		{
+			name:     "Wrong length",
+			input:    []byte{148, 3, 0},
+			expected: ErrBadOption,
+		},
+		{
+			name:     "Not router alert",
+			input:    []byte{149, 4, 0, 0},
+			expected: ErrBadOption,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			opt := Option{}
+			if err := opt.Unmarshal(test.input); err != nil {
+				t.Fatal(err)
+			}
+
+			ra, err := RouterAlertFromOption(opt)
+			if !errors.Is(err, test.expected) {
+				t.Fatalf("Error not expected %v", err)
+			}
+
+			if err == nil && ra.Value != test.value {
+				t.Errorf("Value not expected %d", ra.Value)
+			}
+		})
+	}
+}
+
+func Test_Packet_RouterAlert(t *testing.T) {
+	p := New(IPAddr{}, IPAddr{}, nil).WithOptions(NewRouterAlertOption().Option())
+
+	q := &Packet{}
+	if err := q.Unmarshal(p.Marshal()); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := q.RouterAlert(); !ok {
+		t.Error("router alert not found")
+	}
+
+	if _, ok := New(IPAddr{}, IPAddr{}, nil).RouterAlert(); ok {
+		t.Error("router alert found in packet without options")
+	}
+}
+
+func Test_IpSocket_ReadPacket_RouterAlert(t *testing.T) {
+	is, _ := arpSocket(t, "eth0", "192.168.0.1", 24)
+
+	peer, _ := IPFromString("192.168.0.2")
+	group, _ := IPFromString("224.0.0.22")
+	local := is.GetIpAddr()
+
+	is.recv = frames(ipFrames(
+		New(peer, group, []byte("alert")).WithOptions(NewRouterAlertOption().Option()),
+		New(peer, local, []byte("plain")),
+	)...)
+
+	var alerted []*Packet
+	is.HandleRouterAlert(func(p *Packet) { alerted = append(alerted, p) })
+
+	p, err := is.ReadPacket()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(p.Data) != "plain" {
+		t.Errorf("Read not expected %v", p)
+	}
+
+	if len(alerted) != 1 || alerted[0].Dst != group || string(alerted[0].Data) != "alert" {
+		t.Errorf("Alerted not expected %v", alerted)
+	}
+
+	// without handler alerted packets are read as others
+	is.HandleRouterAlert(nil)
+	is.recv = frames(ipFrames(New(peer, group, nil).WithOptions(NewRouterAlertOption().Option()))...)
+
+	if p, err := is.ReadPacket(); err != nil || p.Dst != group {
+		t.Errorf("Read not expected %v %v", p, err)
+	}
+}
@@ -0,0 +1,203 @@
+package ipv4
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// Experimental option number from RFC4727, it is valid with any copied and
+// class bits: 30, 94, 158 and 222
+const OptionExperiment = 30
+
+var (
+	// ErrUnknownOption is returned when there is no codec for option type
+	ErrUnknownOption = errors.New("unknown option")
+	// ErrOptionRegistered is returned on second registration of option type
+	ErrOptionRegistered = errors.New("option is already registered")
+)
+
+// TypedOption is an option decoded by registered codec, its type selects
+// encoder of the option. All typed options of the package and Option itself
+// implement it.
+type TypedOption interface {
+	OptionType() OptionType
+}
+
+// OptionCodec converts options of single type between generic and typed
+// forms, Encode gets only options of the type codec is registered for
+type OptionCodec struct {
+	Decode func(o Option) (TypedOption, error)
+	Encode func(t TypedOption) (Option, error)
+}
+
+// registry keeps codecs by the whole option type octet, so the same number
+// with different copied and class bits may be decoded differently
+var registry = struct {
+	sync.RWMutex
+	codecs map[uint8]OptionCodec
+}{
+	codecs: map[uint8]OptionCodec{
+		OptionRecordRoute:              codec(RecordRouteFromOption, (*RecordRouteOption).Option),
+		0x80 | OptionLooseSourceRoute:  codec(LooseSourceRouteFromOption, (*LooseSourceRoute).Option),
+		0x80 | OptionStrictSourceRoute: codec(StrictSourceRouteFromOption, (*StrictSourceRoute).Option),
+		2<<5 | OptionTimestamp:         codec(TimestampFromOption, (*TimestampOption).Option),
+		0x80 | OptionRouterAlert:       codec(RouterAlertFromOption, (*RouterAlertOption).Option),
+		0x80 | OptionBasicSecurity:     codec(BasicSecurityFromOption, (*BasicSecurityOption).Option),
+		0x80 | OptionCIPSO:             codec(CIPSOFromOption, (*CIPSOOption).Option),
+	},
+}
+
+// RegisterOption registers codec of option type. Packet.Unmarshal decodes
+// options with registered codecs to Packet.Typed once, options without codec
+// or failed to decode are kept there as generic Option. Packet.Marshal uses
+// Packet.Options only, typed options are added to them by
+// Packet.WithTypedOptions with registered encoders.
+func RegisterOption(t OptionType, c OptionCodec) error {
+	if t.Number() == 0 || t.Number() == 1 {
+		return fmt.Errorf("option %d has no data to decode", t.Value)
+	}
+
+	if c.Decode == nil || c.Encode == nil {
+		return fmt.Errorf("codec of option %d is incomplete", t.Value)
+	}
+
+	registry.Lock()
+	defer registry.Unlock()
+
+	if _, ok := registry.codecs[t.Value]; ok {
+		return fmt.Errorf("%w: %d", ErrOptionRegistered, t.Value)
+	}
+
+	registry.codecs[t.Value] = c
+
+	return nil
+}
+
+// UnregisterOption removes codec of option type
+func UnregisterOption(t OptionType) {
+	registry.Lock()
+	defer registry.Unlock()
+
+	delete(registry.codecs, t.Value)
+}
+
+func lookupCodec(t OptionType) (OptionCodec, error) {
+	registry.RLock()
+	c, ok := registry.codecs[t.Value]
+	registry.RUnlock()
+
+	if !ok {
+		return OptionCodec{}, fmt.Errorf("%w: %d", ErrUnknownOption, t.Value)
+	}
+
+	return c, nil
+}
+
+// Decode converts generic option to typed one with registered decoder
+func (o *Option) Decode() (TypedOption, error) {
+	c, err := lookupCodec(o.Type)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.Decode(*o)
+}
+
+// EncodeOption converts typed option to generic one with registered encoder,
+// generic option is returned as is
+func EncodeOption(t TypedOption) (Option, error) {
+	if o, ok := t.(Option); ok {
+		return o, nil
+	}
+
+	c, err := lookupCodec(t.OptionType())
+	if err != nil {
+		return Option{}, err
+	}
+
+	return c.Encode(t)
+}
+
+// OptionType returns type of generic option
+func (o Option) OptionType() OptionType {
+	return o.Type
+}
+
+// OptionType returns type of Record Route option
+func (r *RecordRouteOption) OptionType() OptionType {
+	return OptionType{Value: OptionRecordRoute}
+}
+
+// OptionType returns type of Loose Source Route option
+func (r *LooseSourceRoute) OptionType() OptionType {
+	return OptionType{Value: 0x80 | OptionLooseSourceRoute}
+}
+
+// OptionType returns type of Strict Source Route option
+func (r *StrictSourceRoute) OptionType() OptionType {
+	return OptionType{Value: 0x80 | OptionStrictSourceRoute}
+}
+
+// OptionType returns type of Internet Timestamp option
+func (t *TimestampOption) OptionType() OptionType {
+	return OptionType{Value: 2<<5 | OptionTimestamp}
+}
+
+// OptionType returns type of Router Alert option
+func (r *RouterAlertOption) OptionType() OptionType {
+	return OptionType{Value: 0x80 | OptionRouterAlert}
+}
+
+// OptionType returns type of Basic Security option
+func (b *BasicSecurityOption) OptionType() OptionType {
+	return OptionType{Value: 0x80 | OptionBasicSecurity}
+}
+
+// OptionType returns type of CIPSO option
+func (c *CIPSOOption) OptionType() OptionType {
+	return OptionType{Value: 0x80 | OptionCIPSO}
+}
+
+// decodeOptions decodes options with registered decoders, options without
+// decoder or failed to decode are kept generic
+func decodeOptions(opts []Option) []TypedOption {
+	if len(opts) == 0 {
+		return nil
+	}
+
+	typed := make([]TypedOption, 0, len(opts))
+
+	for i := range opts {
+		t, err := opts[i].Decode()
+		if err != nil {
+			t = opts[i]
+		}
+
+		typed = append(typed, t)
+	}
+
+	return typed
+}
+
+// codec makes codec of typed option T from its conversion functions
+func codec[T TypedOption](decode func(Option) (T, error), encode func(T) Option) OptionCodec {
+	return OptionCodec{
+		Decode: func(o Option) (TypedOption, error) {
+			t, err := decode(o)
+			if err != nil {
+				return nil, err
+			}
+
+			return t, nil
+		},
+		Encode: func(o TypedOption) (Option, error) {
+			t, ok := o.(T)
+			if !ok {
+				return Option{}, fmt.Errorf("%w: unexpected %T", ErrBadOption, o)
+			}
+
+			return encode(t), nil
+		},
+	}
+}
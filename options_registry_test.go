@@ -0,0 +1,105 @@
+package ipv4
+
+import (
+	"errors"
+	"testing"
+)
+
+// experimentOption is option from RFC4727 used to test registration
+type experimentOption struct {
+	Value byte
+}
+
+func (e *experimentOption) OptionType() OptionType {
+	return OptionType{Value: 0x80 | OptionExperiment}
+}
+
+var experimentCodec = OptionCodec{
+	Decode: func(o Option) (TypedOption, error) {
+		if len(o.Value) != 1 {
+			return nil, ErrBadOption
+		}
+
+		return &experimentOption{Value: o.Value[0]}, nil
+	},
+	Encode: func(t TypedOption) (Option, error) {
+		e := t.(*experimentOption)
+		return Option{Type: e.OptionType(), Length: 3, Value: []byte{e.Value}}, nil
+	},
+}
+
+func Test_RegisterOption(t *testing.T) {
+	typ := OptionType{Value: 0x80 | OptionExperiment}
+
+	if err := RegisterOption(typ, experimentCodec); err != nil {
+		t.Fatal(err)
+	}
+	defer UnregisterOption(typ)
+
+	if err := RegisterOption(typ, experimentCodec); !errors.Is(err, ErrOptionRegistered) {
+		t.Errorf("Error not expected %v", err)
+	}
+
+	if err := RegisterOption(OptionType{Value: OptionExperiment}, OptionCodec{Decode: experimentCodec.Decode}); err == nil {
+		t.Error("Codec without encoder registered")
+	}
+
+	rr, _ := NewRecordRouteOption(1)
+
+	p, err := New(IPAddr{}, IPAddr{}, nil).WithTypedOptions(&experimentOption{Value: 42}, rr, Option{Type: OptionType{Value: 0x80 | 31}, Length: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	q := &Packet{}
+	if err := q.Unmarshal(p.Marshal()); err != nil {
+		t.Fatal(err)
+	}
+
+	typed := q.Typed
+	if len(typed) != 3 {
+		t.Fatalf("Options not expected %v", typed)
+	}
+
+	if e, ok := typed[0].(*experimentOption); !ok || e.Value != 42 {
+		t.Errorf("Option not expected %v", typed[0])
+	}
+
+	if _, ok := typed[1].(*RecordRouteOption); !ok {
+		t.Errorf("Option not expected %v", typed[1])
+	}
+
+	if _, ok := typed[2].(Option); !ok {
+		t.Errorf("Option not expected %v", typed[2])
+	}
+}
+
+func Test_Packet_Unmarshal_TypedErrors(t *testing.T) {
+	opt := Option{Type: OptionType{Value: 148}, Length: 3, Value: []byte{0}}
+
+	if _, err := opt.Decode(); !errors.Is(err, ErrBadOption) {
+		t.Errorf("Error not expected %v", err)
+	}
+
+	p := &Packet{}
+	if err := p.Unmarshal(New(IPAddr{}, IPAddr{}, nil).WithOptions(opt).Marshal()); err != nil {
+		t.Fatal(err)
+	}
+
+	if o, ok := p.Typed[0].(Option); !ok || o.Type != opt.Type {
+		t.Errorf("Options not expected %v", p.Typed)
+	}
+}
+
+func Test_Packet_WithTypedOptions_Unknown(t *testing.T) {
+	rr, _ := NewRecordRouteOption(1)
+
+	p := New(IPAddr{}, IPAddr{}, nil)
+	if _, err := p.WithTypedOptions(rr, &experimentOption{Value: 42}); !errors.Is(err, ErrUnknownOption) {
+		t.Errorf("Error not expected %v", err)
+	}
+
+	if len(p.Options) != 0 {
+		t.Errorf("Options not expected %v", p.Options)
+	}
+}
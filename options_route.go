@@ -0,0 +1,308 @@
+package ipv4
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Option numbers of route options, see OptionType.Number
+const (
+	OptionLooseSourceRoute  = 3
+	OptionRecordRoute       = 7
+	OptionStrictSourceRoute = 9
+
+	routeHeaderLength = 3 // type, length and pointer octets
+	minRoutePointer   = 4 // pointer to the first route slot
+	maxRouteSlots     = 9 // options area is limited to 40 bytes
+)
+
+var (
+	// ErrRouteFull is returned when there is no free slot left in route option
+	ErrRouteFull = errors.New("route option is full")
+	// ErrRouteExhausted is returned when all source route addresses are passed
+	ErrRouteExhausted = errors.New("source route is exhausted")
+)
+
+// +--------+--------+--------+---------//--------+
+// |00000111| length | pointer|     route data    |
+// +--------+--------+--------+---------//--------+
+//
+//	Record Route option from RFC791
+//
+// Pointer is the octet offset of the next free slot counted from the option
+// type octet, the smallest legal value is 4. Route keeps all allocated slots,
+// both recorded and free ones.
+type RecordRouteOption struct {
+	Pointer uint8
+	Route   []IPAddr
+}
+
+// NewRecordRouteOption creates option with n empty slots
+func NewRecordRouteOption(n int) (*RecordRouteOption, error) {
+	if n < 1 || n > maxRouteSlots {
+		return nil, fmt.Errorf("wrong count of route slots %d", n)
+	}
+
+	return &RecordRouteOption{
+		Pointer: minRoutePointer,
+		Route:   make([]IPAddr, n),
+	}, nil
+}
+
+// RecordRouteFromOption converts generic option to Record Route option
+func RecordRouteFromOption(o Option) (*RecordRouteOption, error) {
+	if o.Type.Number() != OptionRecordRoute {
+		return nil, fmt.Errorf("%w: option %d is not record route", ErrBadOption, o.Type.Value)
+	}
+
+	pointer, route, err := unmarshalRoute(o)
+	if err != nil {
+		return nil, err
+	}
+
+	return &RecordRouteOption{Pointer: pointer, Route: route}, nil
+}
+
+// Recorded returns addresses already written to option
+func (r *RecordRouteOption) Recorded() []IPAddr {
+	return r.Route[:r.used()]
+}
+
+// Full reports whether there is no free slot left
+func (r *RecordRouteOption) Full() bool {
+	return r.used() >= len(r.Route)
+}
+
+// Record writes address of forwarding interface to the next free slot
+func (r *RecordRouteOption) Record(addr IPAddr) error {
+	if r.Full() {
+		return ErrRouteFull
+	}
+
+	r.Route[r.used()] = addr
+	r.Pointer += 4
+
+	return nil
+}
+
+// Option converts Record Route option to generic option
+func (r *RecordRouteOption) Option() Option {
+	return marshalRoute(OptionType{Value: OptionRecordRoute}, r.Pointer, r.Route)
+}
+
+func (r *RecordRouteOption) used() int {
+	if r.Pointer < minRoutePointer {
+		return 0
+	}
+
+	return int(r.Pointer-minRoutePointer) / 4
+}
+
+// +--------+--------+--------+---------//--------+
+// |10000011| length | pointer|     route data    |
+// +--------+--------+--------+---------//--------+
+//
+//	Loose Source and Record Route option from RFC791
+//
+// Until datagram is sent Route lists hops in order and Pointer is 4, on send
+// IpSocket.WritePacket moves the first hop to destination address and appends
+// the final destination to the route. While forwarding, each hop named in
+// destination takes the next address from the route and records own address
+// in its place.
+type LooseSourceRoute struct {
+	Pointer uint8
+	Route   []IPAddr
+}
+
+// NewLooseSourceRoute creates option that steers datagram through hops,
+// datagram may pass other routers between them
+func NewLooseSourceRoute(hops ...IPAddr) (*LooseSourceRoute, error) {
+	if err := validateHops(hops); err != nil {
+		return nil, err
+	}
+
+	return &LooseSourceRoute{Pointer: minRoutePointer, Route: append([]IPAddr(nil), hops...)}, nil
+}
+
+// LooseSourceRouteFromOption converts generic option to Loose Source Route option
+func LooseSourceRouteFromOption(o Option) (*LooseSourceRoute, error) {
+	if o.Type.Number() != OptionLooseSourceRoute {
+		return nil, fmt.Errorf("%w: option %d is not loose source route", ErrBadOption, o.Type.Value)
+	}
+
+	pointer, route, err := unmarshalRoute(o)
+	if err != nil {
+		return nil, err
+	}
+
+	return &LooseSourceRoute{Pointer: pointer, Route: route}, nil
+}
+
+// Done reports whether datagram has reached the last address of the route
+func (r *LooseSourceRoute) Done() bool {
+	return routeDone(r.Pointer, r.Route)
+}
+
+// Next returns the next hop and records local address in its place
+func (r *LooseSourceRoute) Next(local IPAddr) (IPAddr, error) {
+	return routeNext(&r.Pointer, r.Route, local)
+}
+
+// Option converts Loose Source Route option to generic option
+func (r *LooseSourceRoute) Option() Option {
+	return marshalRoute(OptionType{Value: 0x80 | OptionLooseSourceRoute}, r.Pointer, r.Route)
+}
+
+// +--------+--------+--------+---------//--------+
+// |10001001| length | pointer|     route data    |
+// +--------+--------+--------+---------//--------+
+//
+//	Strict Source and Record Route option from RFC791
+//
+// Works as LooseSourceRoute, but datagram must go directly from one hop to
+// the next one without intermediate routers.
+type StrictSourceRoute struct {
+	Pointer uint8
+	Route   []IPAddr
+}
+
+// NewStrictSourceRoute creates option that steers datagram exactly through hops
+func NewStrictSourceRoute(hops ...IPAddr) (*StrictSourceRoute, error) {
+	if err := validateHops(hops); err != nil {
+		return nil, err
+	}
+
+	return &StrictSourceRoute{Pointer: minRoutePointer, Route: append([]IPAddr(nil), hops...)}, nil
+}
+
+// StrictSourceRouteFromOption converts generic option to Strict Source Route option
+func StrictSourceRouteFromOption(o Option) (*StrictSourceRoute, error) {
+	if o.Type.Number() != OptionStrictSourceRoute {
+		return nil, fmt.Errorf("%w: option %d is not strict source route", ErrBadOption, o.Type.Value)
+	}
+
+	pointer, route, err := unmarshalRoute(o)
+	if err != nil {
+		return nil, err
+	}
+
+	return &StrictSourceRoute{Pointer: pointer, Route: route}, nil
+}
+
+// Done reports whether datagram has reached the last address of the route
+func (r *StrictSourceRoute) Done() bool {
+	return routeDone(r.Pointer, r.Route)
+}
+
+// Next returns the next hop and records local address in its place
+func (r *StrictSourceRoute) Next(local IPAddr) (IPAddr, error) {
+	return routeNext(&r.Pointer, r.Route, local)
+}
+
+// Option converts Strict Source Route option to generic option
+func (r *StrictSourceRoute) Option() Option {
+	return marshalRoute(OptionType{Value: 0x80 | OptionStrictSourceRoute}, r.Pointer, r.Route)
+}
+
+// originateSourceRoute prepares packet with source route option for sending:
+// the first hop becomes destination and the final destination is appended to
+// the route. Packet without such option or already on its way is returned as is,
+// source route without hops is rejected with ErrBadOption.
+func (p *Packet) originateSourceRoute() (*Packet, error) {
+	for i, opt := range p.Options {
+		number := opt.Type.Number()
+
+		if number != OptionLooseSourceRoute && number != OptionStrictSourceRoute {
+			continue
+		}
+
+		pointer, route, err := unmarshalRoute(opt)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(route) == 0 {
+			return nil, fmt.Errorf("%w: source route has no hops", ErrBadOption)
+		}
+
+		if pointer != minRoutePointer {
+			return p, nil
+		}
+
+		if len(route) == maxRouteSlots {
+			return nil, fmt.Errorf("%w: no room for final destination", ErrRouteFull)
+		}
+
+		out := *p
+		out.Dst = route[0]
+		out.Options = append([]Option(nil), p.Options...)
+		out.Options[i] = marshalRoute(opt.Type, pointer, append(route[1:], p.Dst))
+
+		return &out, nil
+	}
+
+	return p, nil
+}
+
+func validateHops(hops []IPAddr) error {
+	if len(hops) < 1 || len(hops) >= maxRouteSlots {
+		return fmt.Errorf("wrong count of hops %d", len(hops))
+	}
+
+	return nil
+}
+
+func routeDone(pointer uint8, route []IPAddr) bool {
+	return int(pointer) > routeHeaderLength+len(route)*4
+}
+
+func routeNext(pointer *uint8, route []IPAddr, local IPAddr) (IPAddr, error) {
+	if routeDone(*pointer, route) {
+		return IPAddr{}, ErrRouteExhausted
+	}
+
+	i := int(*pointer-minRoutePointer) / 4
+
+	next := route[i]
+	route[i] = local
+	*pointer += 4
+
+	return next, nil
+}
+
+// unmarshalRoute parses pointer and route data shared by route options
+func unmarshalRoute(o Option) (uint8, []IPAddr, error) {
+	if len(o.Value) < 1 || len(o.Value) != int(o.Length)-2 || (len(o.Value)-1)%4 != 0 {
+		return 0, nil, fmt.Errorf("%w: route option has length %d", ErrBadOption, o.Length)
+	}
+
+	pointer := o.Value[0]
+
+	if pointer < minRoutePointer || (pointer-minRoutePointer)%4 != 0 || int(pointer) > int(o.Length)+1 {
+		return 0, nil, fmt.Errorf("%w: route option has pointer %d", ErrBadOption, pointer)
+	}
+
+	route := make([]IPAddr, (len(o.Value)-1)/4)
+
+	for i := range route {
+		copy(route[i][:], o.Value[1+i*4:5+i*4])
+	}
+
+	return pointer, route, nil
+}
+
+// marshalRoute builds generic option from pointer and route data
+func marshalRoute(t OptionType, pointer uint8, route []IPAddr) Option {
+	value := make([]byte, 1, 1+len(route)*4)
+	value[0] = pointer
+
+	for _, addr := range route {
+		value = append(value, addr[:]...)
+	}
+
+	return Option{
+		Type:   t,
+		Length: uint8(routeHeaderLength + len(route)*4),
+		Value:  value,
+	}
+}
@@ -0,0 +1,236 @@
+package ipv4
+
+import (
+	"errors"
+	"testing"
+)
+
+func Test_RecordRouteOption_Record(t *testing.T) {
+	rr, err := NewRecordRouteOption(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ip1, _ := IPFromString("10.0.0.1")
+	ip2, _ := IPFromString("10.0.0.2")
+
+	if err := rr.Record(ip1); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := rr.Record(ip2); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := rr.Record(ip1); !errors.Is(err, ErrRouteFull) {
+		t.Errorf("Error not expected %v", err)
+	}
+
+	if rr.Pointer != 12 {
+		t.Errorf("Pointer not expected %d", rr.Pointer)
+	}
+
+	recorded := rr.Recorded()
+	if len(recorded) != 2 || recorded[0] != ip1 || recorded[1] != ip2 {
+		t.Errorf("Recorded not expected %v", recorded)
+	}
+}
+
+func Test_RecordRouteOption_Option(t *testing.T) {
+	rr, _ := NewRecordRouteOption(2)
+	rr.Record(IPAddr{10, 0, 0, 1})
+
+	opt := rr.Option()
+	expected := []byte{7, 11, 8, 10, 0, 0, 1, 0, 0, 0, 0}
+
+	data := opt.Marshal()
+	if len(data) != len(expected) {
+		t.Fatalf("Wrong length %v", data)
+	}
+
+	for i, b := range expected {
+		if data[i] != b {
+			t.Errorf("Marshaled %d not equeal expected %d", data[i], b)
+		}
+	}
+}
+
+func Test_RecordRouteFromOption(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    []byte
+		expected error
+	}{
+		{
+			name:  "Valid option",
+			input: []byte{7, 11, 8, 10, 0, 0, 1, 0, 0, 0, 0},
+		},
+		{
+			name:  "Full option",
+			input: []byte{7, 7, 8, 10, 0, 0, 1},
+		},
+		{
+			name:     "Not record route",
+			input:    []byte{131, 7, 4, 10, 0, 0, 1},
+			expected: ErrBadOption,
+		},
+		{
+			name:     "Pointer less than minimum",
+			input:    []byte{7, 7, 3, 10, 0, 0, 1},
+			expected: ErrBadOption,
+		},
+		{
+			name:     "Pointer not aligned",
+			input:    []byte{7, 7, 5, 10, 0, 0, 1},
+			expected: ErrBadOption,
+		},
+		{
+			name:     "Pointer beyond option",
+			input:    []byte{7, 7, 12, 10, 0, 0, 1},
+			expected: ErrBadOption,
+		},
+		{
+			name:     "Partial slot",
+			input:    []byte{7, 6, 4, 10, 0, 0},
+			expected: ErrBadOption,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			opt := Option{}
+			if err := opt.Unmarshal(test.input); err != nil {
+				t.Fatal(err)
+			}
+
+			_, err := RecordRouteFromOption(opt)
+			if !errors.Is(err, test.expected) {
+				t.Errorf("Error not expected %v", err)
+			}
+		})
+	}
+}
+
+func Test_LooseSourceRoute_Next(t *testing.T) {
+	hop1, _ := IPFromString("10.0.0.1")
+	hop2, _ := IPFromString("10.0.1.1")
+	local, _ := IPFromString("192.168.0.1")
+
+	lsrr, err := NewLooseSourceRoute(hop1, hop2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	next, err := lsrr.Next(local)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if next != hop1 || lsrr.Route[0] != local || lsrr.Pointer != 8 {
+		t.Errorf("Route not expected %v %v %d", next, lsrr.Route, lsrr.Pointer)
+	}
+
+	if _, err := lsrr.Next(local); err != nil {
+		t.Fatal(err)
+	}
+
+	if !lsrr.Done() {
+		t.Error("route is not done")
+	}
+
+	if _, err := lsrr.Next(local); !errors.Is(err, ErrRouteExhausted) {
+		t.Errorf("Error not expected %v", err)
+	}
+}
+
+func Test_StrictSourceRouteFromOption(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    []byte
+		expected error
+	}{
+		{
+			name:  "Valid option",
+			input: []byte{137, 7, 4, 10, 0, 0, 1},
+		},
+		{
+			name:     "Loose source route",
+			input:    []byte{131, 7, 4, 10, 0, 0, 1},
+			expected: ErrBadOption,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			opt := Option{}
+			if err := opt.Unmarshal(test.input); err != nil {
+				t.Fatal(err)
+			}
+
+			_, err := StrictSourceRouteFromOption(opt)
+			if !errors.Is(err, test.expected) {
+				t.Errorf("Error not expected %v", err)
+			}
+		})
+	}
+}
+
+func Test_Packet_originateSourceRoute(t *testing.T) {
+	src, _ := IPFromString("192.168.0.1")
+	dst, _ := IPFromString("8.8.8.8")
+	hop1, _ := IPFromString("10.0.0.1")
+	hop2, _ := IPFromString("10.0.1.1")
+
+	ssrr, _ := NewStrictSourceRoute(hop1, hop2)
+	p := New(src, dst, []byte{0}).WithOptions(ssrr.Option())
+
+	out, err := p.originateSourceRoute()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if out.Dst != hop1 {
+		t.Errorf("Destination not expected %v", out.Dst)
+	}
+
+	if p.Dst != dst || p.Options[0].Value[1] != 10 {
+		t.Error("original packet changed")
+	}
+
+	sent, err := StrictSourceRouteFromOption(out.Options[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(sent.Route) != 2 || sent.Route[0] != hop2 || sent.Route[1] != dst || sent.Pointer != 4 {
+		t.Errorf("Route not expected %v", sent.Route)
+	}
+
+	again, err := New(src, hop2, []byte{0}).WithOptions(Option{Type: OptionType{137}, Length: 7, Value: []byte{8, 10, 0, 0, 1}}).originateSourceRoute()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if again.Dst != hop2 {
+		t.Error("forwarded packet rewritten")
+	}
+}
+
+func Test_Packet_originateSourceRoute_NoHops(t *testing.T) {
+	src, _ := IPFromString("192.168.0.1")
+	dst, _ := IPFromString("192.168.0.2")
+
+	for _, typ := range []uint8{0x80 | OptionLooseSourceRoute, 0x80 | OptionStrictSourceRoute} {
+		p := New(src, dst, nil).WithOptions(Option{Type: OptionType{typ}, Length: 3, Value: []byte{4}})
+
+		if _, err := p.originateSourceRoute(); !errors.Is(err, ErrBadOption) {
+			t.Errorf("Error of option %d not expected %v", typ, err)
+		}
+
+		is, r := arpSocket(t, "eth0", "192.168.0.1", 24)
+
+		if err := is.WritePacket(p); !errors.Is(err, ErrBadOption) || len(r.frames) != 0 {
+			t.Errorf("Written option %d not expected %v %v", typ, r.frames, err)
+		}
+	}
+}
@@ -0,0 +1,415 @@
+package ipv4
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// Option numbers of security options, see OptionType.Number
+const (
+	OptionBasicSecurity = 2
+	OptionCIPSO         = 6
+)
+
+// SecurityClassification is classification level of RFC1108 Basic Security option
+type SecurityClassification uint8
+
+const (
+	ClassificationReserved4    SecurityClassification = 0x01
+	ClassificationTopSecret    SecurityClassification = 0x3D
+	ClassificationSecret       SecurityClassification = 0x5A
+	ClassificationConfidential SecurityClassification = 0x96
+	ClassificationReserved3    SecurityClassification = 0x66
+	ClassificationReserved2    SecurityClassification = 0xCC
+	ClassificationUnclassified SecurityClassification = 0xAB
+	ClassificationReserved1    SecurityClassification = 0xF1
+)
+
+// Valid reports whether level is one of defined by RFC1108
+func (c SecurityClassification) Valid() bool {
+	switch c {
+	case ClassificationReserved4, ClassificationTopSecret, ClassificationSecret, ClassificationConfidential,
+		ClassificationReserved3, ClassificationReserved2, ClassificationUnclassified, ClassificationReserved1:
+		return true
+	}
+
+	return false
+}
+
+// Protection authority flags of the first authority octet
+const (
+	AuthorityGENSER  = 0x80
+	AuthoritySIOPESI = 0x40
+	AuthoritySCI     = 0x20
+	AuthorityNSA     = 0x10
+	AuthorityDOE     = 0x08
+
+	authorityMore = 0x01 // field termination indicator, set when another octet follows
+)
+
+// +------------+------------+------------+-------------//----------+
+// |  10000010  |  XXXXXXXX  |  SSSSSSSS  |  AAAAAAA[1]    AAAAAAA0 |
+// |            |            |            |         [0]             |
+// +------------+------------+------------+-------------//----------+
+// | TYPE = 130 |   LENGTH   |   LEVEL    |  PROTECTION AUTHORITY   |
+// +------------+------------+------------+-------------//----------+
+//
+//	Basic Security option from RFC1108
+//
+// Authority keeps protection authority octets without field termination
+// indicator, it is set on marshaling.
+type BasicSecurityOption struct {
+	Classification SecurityClassification
+	Authority      []byte
+}
+
+// NewBasicSecurityOption creates option with classification level and
+// authority flags of the first octet
+func NewBasicSecurityOption(level SecurityClassification, authority uint8) (*BasicSecurityOption, error) {
+	bso := &BasicSecurityOption{Classification: level}
+
+	if authority != 0 {
+		bso.Authority = []byte{authority}
+	}
+
+	if err := bso.Validate(); err != nil {
+		return nil, err
+	}
+
+	return bso, nil
+}
+
+// BasicSecurityFromOption converts generic option to Basic Security option
+func BasicSecurityFromOption(o Option) (*BasicSecurityOption, error) {
+	if o.Type.Number() != OptionBasicSecurity {
+		return nil, fmt.Errorf("%w: option %d is not basic security", ErrBadOption, o.Type.Value)
+	}
+
+	if len(o.Value) < 1 || len(o.Value) != int(o.Length)-2 {
+		return nil, fmt.Errorf("%w: basic security option has length %d", ErrBadOption, o.Length)
+	}
+
+	bso := &BasicSecurityOption{Classification: SecurityClassification(o.Value[0])}
+
+	authority := o.Value[1:]
+
+	for i, b := range authority {
+		last := i == len(authority)-1
+
+		if (b&authorityMore != 0) == last {
+			return nil, fmt.Errorf("%w: wrong termination of protection authority octet %d", ErrBadOption, i)
+		}
+
+		bso.Authority = append(bso.Authority, b&^authorityMore)
+	}
+
+	if err := bso.Validate(); err != nil {
+		return nil, err
+	}
+
+	return bso, nil
+}
+
+// HasAuthority reports whether flag of the first authority octet is set
+func (b *BasicSecurityOption) HasAuthority(flag uint8) bool {
+	return len(b.Authority) > 0 && b.Authority[0]&flag != 0
+}
+
+// Validate checks classification level and option size
+func (b *BasicSecurityOption) Validate() error {
+	if !b.Classification.Valid() {
+		return fmt.Errorf("%w: unknown classification level %#x", ErrBadOption, uint8(b.Classification))
+	}
+
+	if 3+len(b.Authority) > maxOptionsLength {
+		return fmt.Errorf("%w: too many protection authority octets %d", ErrBadOption, len(b.Authority))
+	}
+
+	return nil
+}
+
+// Option converts Basic Security option to generic option
+func (b *BasicSecurityOption) Option() Option {
+	value := make([]byte, 1, 1+len(b.Authority))
+	value[0] = uint8(b.Classification)
+
+	for i, a := range b.Authority {
+		if i < len(b.Authority)-1 {
+			a |= authorityMore
+		} else {
+			a &^= authorityMore
+		}
+
+		value = append(value, a)
+	}
+
+	return Option{
+		Type:   OptionType{Value: 0x80 | OptionBasicSecurity},
+		Length: uint8(2 + len(value)),
+		Value:  value,
+	}
+}
+
+// CIPSOTagType is type of CIPSO tag
+type CIPSOTagType uint8
+
+const (
+	CIPSOTagRestricted CIPSOTagType = 1 // bitmap of categories
+	CIPSOTagEnumerated CIPSOTagType = 2 // list of categories
+	CIPSOTagRanged     CIPSOTagType = 5 // list of category ranges
+)
+
+const (
+	cipsoHeaderLength    = 6 // type, length and DOI octets
+	cipsoTagHeaderLength = 4 // type, length, alignment and level octets
+	maxCIPSOBitmap       = 30
+	maxCIPSOCategories   = 15
+	maxCIPSORanges       = 7
+)
+
+// CIPSORange is inclusive range of categories
+type CIPSORange struct {
+	High uint16
+	Low  uint16
+}
+
+// CIPSOTag is sensitivity label of CIPSO option. Depending on Type categories
+// are kept in Bitmap, Categories or Ranges.
+//
+//	Tag type 1: | 00000001 | length | 00000000 | level | bitmap 0-30 octets  |
+//	Tag type 2: | 00000010 | length | 00000000 | level | 0-15 categories     |
+//	Tag type 5: | 00000101 | length | 00000000 | level | 0-7 category ranges |
+type CIPSOTag struct {
+	Type       CIPSOTagType
+	Level      uint8
+	Bitmap     []byte
+	Categories []uint16
+	Ranges     []CIPSORange
+}
+
+// HasCategory reports whether category is part of label
+func (t *CIPSOTag) HasCategory(c uint16) bool {
+	switch t.Type {
+	case CIPSOTagRestricted:
+		return int(c/8) < len(t.Bitmap) && t.Bitmap[c/8]&(0x80>>(c%8)) != 0
+	case CIPSOTagEnumerated:
+		for _, cat := range t.Categories {
+			if cat == c {
+				return true
+			}
+		}
+	case CIPSOTagRanged:
+		for _, r := range t.Ranges {
+			if c >= r.Low && c <= r.High {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// Validate checks count and order of categories
+func (t *CIPSOTag) Validate() error {
+	switch t.Type {
+	case CIPSOTagRestricted:
+		if len(t.Bitmap) > maxCIPSOBitmap {
+			return fmt.Errorf("%w: category bitmap has %d octets", ErrBadOption, len(t.Bitmap))
+		}
+	case CIPSOTagEnumerated:
+		if len(t.Categories) > maxCIPSOCategories {
+			return fmt.Errorf("%w: tag has %d categories", ErrBadOption, len(t.Categories))
+		}
+
+		for i := 1; i < len(t.Categories); i++ {
+			if t.Categories[i] <= t.Categories[i-1] {
+				return fmt.Errorf("%w: categories are not in ascending order", ErrBadOption)
+			}
+		}
+	case CIPSOTagRanged:
+		if len(t.Ranges) > maxCIPSORanges {
+			return fmt.Errorf("%w: tag has %d category ranges", ErrBadOption, len(t.Ranges))
+		}
+
+		for i, r := range t.Ranges {
+			if r.Low > r.High {
+				return fmt.Errorf("%w: category range %d-%d is reversed", ErrBadOption, r.Low, r.High)
+			}
+
+			if i > 0 && r.High >= t.Ranges[i-1].Low {
+				return fmt.Errorf("%w: category ranges are not in descending order", ErrBadOption)
+			}
+		}
+	default:
+		return fmt.Errorf("%w: unsupported cipso tag type %d", ErrBadOption, t.Type)
+	}
+
+	return nil
+}
+
+func (t *CIPSOTag) marshal() []byte {
+	buf := []byte{uint8(t.Type), 0, 0, t.Level}
+
+	switch t.Type {
+	case CIPSOTagRestricted:
+		buf = append(buf, t.Bitmap...)
+	case CIPSOTagEnumerated:
+		for _, c := range t.Categories {
+			buf = binary.BigEndian.AppendUint16(buf, c)
+		}
+	case CIPSOTagRanged:
+		for _, r := range t.Ranges {
+			buf = binary.BigEndian.AppendUint16(buf, r.High)
+			buf = binary.BigEndian.AppendUint16(buf, r.Low)
+		}
+	}
+
+	buf[1] = uint8(len(buf))
+
+	return buf
+}
+
+func (t *CIPSOTag) unmarshal(b []byte) error {
+	if len(b) < cipsoTagHeaderLength || int(b[1]) < cipsoTagHeaderLength || int(b[1]) > len(b) {
+		return fmt.Errorf("%w: truncated cipso tag", ErrBadOption)
+	}
+
+	if b[2] != 0 {
+		return fmt.Errorf("%w: cipso alignment octet is %d", ErrBadOption, b[2])
+	}
+
+	t.Type = CIPSOTagType(b[0])
+	t.Level = b[3]
+
+	data := b[cipsoTagHeaderLength:b[1]]
+
+	switch t.Type {
+	case CIPSOTagRestricted:
+		t.Bitmap = data
+	case CIPSOTagEnumerated:
+		if len(data)%2 != 0 {
+			return fmt.Errorf("%w: partial cipso category", ErrBadOption)
+		}
+
+		for i := 0; i < len(data); i += 2 {
+			t.Categories = append(t.Categories, binary.BigEndian.Uint16(data[i:]))
+		}
+	case CIPSOTagRanged:
+		// low category of the last range may be omitted when it is zero
+		if len(data)%4 != 0 && len(data)%4 != 2 {
+			return fmt.Errorf("%w: partial cipso category range", ErrBadOption)
+		}
+
+		for i := 0; i < len(data); i += 4 {
+			r := CIPSORange{High: binary.BigEndian.Uint16(data[i:])}
+
+			if i+4 <= len(data) {
+				r.Low = binary.BigEndian.Uint16(data[i+2:])
+			}
+
+			t.Ranges = append(t.Ranges, r)
+		}
+	}
+
+	return t.Validate()
+}
+
+// +----------+----------+------//------+-----------//---------+
+// | 10000110 | LLLLLLLL | DDDDDDDDDDDD | TTTTTTTTTTTTTTTTTTTTT |
+// +----------+----------+------//------+-----------//---------+
+// | TYPE=134 |  LENGTH  |     DOI      |         TAGS          |
+// +----------+----------+------//------+-----------//---------+
+//
+//	Commercial IP Security Option (CIPSO)
+type CIPSOOption struct {
+	DOI  uint32
+	Tags []CIPSOTag
+}
+
+// CIPSOFromOption converts generic option to CIPSO option
+func CIPSOFromOption(o Option) (*CIPSOOption, error) {
+	if o.Type.Number() != OptionCIPSO {
+		return nil, fmt.Errorf("%w: option %d is not cipso", ErrBadOption, o.Type.Value)
+	}
+
+	if len(o.Value) < 4 || len(o.Value) != int(o.Length)-2 {
+		return nil, fmt.Errorf("%w: cipso option has length %d", ErrBadOption, o.Length)
+	}
+
+	c := &CIPSOOption{DOI: binary.BigEndian.Uint32(o.Value)}
+
+	for data := o.Value[4:]; len(data) > 0; {
+		tag := CIPSOTag{}
+
+		if err := tag.unmarshal(data); err != nil {
+			return nil, err
+		}
+
+		c.Tags = append(c.Tags, tag)
+		data = data[data[1]:]
+	}
+
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// Validate checks domain of interpretation, tags and option size
+func (c *CIPSOOption) Validate() error {
+	if c.DOI == 0 {
+		return fmt.Errorf("%w: cipso domain of interpretation 0 is reserved", ErrBadOption)
+	}
+
+	length := cipsoHeaderLength
+
+	for i := range c.Tags {
+		if err := c.Tags[i].Validate(); err != nil {
+			return err
+		}
+
+		length += len(c.Tags[i].marshal())
+	}
+
+	if length > maxOptionsLength {
+		return fmt.Errorf("%w: cipso option has length %d", ErrBadOption, length)
+	}
+
+	return nil
+}
+
+// Option converts CIPSO option to generic option
+func (c *CIPSOOption) Option() Option {
+	value := binary.BigEndian.AppendUint32(nil, c.DOI)
+
+	for i := range c.Tags {
+		value = append(value, c.Tags[i].marshal()...)
+	}
+
+	return Option{
+		Type:   OptionType{Value: 0x80 | OptionCIPSO},
+		Length: uint8(2 + len(value)),
+		Value:  value,
+	}
+}
+
+// SetBasicSecurity labels packet with Basic Security option, previous
+// label of the same kind is replaced, options have to fit 40 bytes
+func (p *Packet) SetBasicSecurity(b *BasicSecurityOption) error {
+	if err := b.Validate(); err != nil {
+		return err
+	}
+
+	return p.setOption(b.Option())
+}
+
+// SetCIPSO labels packet with CIPSO option, previous label of the same kind
+// is replaced, options have to fit 40 bytes
+func (p *Packet) SetCIPSO(c *CIPSOOption) error {
+	if err := c.Validate(); err != nil {
+		return err
+	}
+
+	return p.setOption(c.Option())
+}
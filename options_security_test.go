@@ -0,0 +1,334 @@
+package ipv4
+
+import (
+	"errors"
+	"testing"
+)
+
+func Test_BasicSecurityFromOption(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    []byte
+		expected error
+	}{
+		{
+			name:  "Without authority",
+			input: []byte{130, 3, 0x5A},
+		},
+		{
+			name:  "Single authority octet",
+			input: []byte{130, 4, 0x3D, AuthorityGENSER | AuthorityNSA},
+		},
+		{
+			name:  "Two authority octets",
+			input: []byte{130, 5, 0xAB, AuthoritySCI | authorityMore, 0x02},
+		},
+		{
+			name:     "Unknown classification",
+			input:    []byte{130, 3, 0x02},
+			expected: ErrBadOption,
+		},
+		{
+			name:     "Last octet not terminated",
+			input:    []byte{130, 4, 0x3D, AuthorityDOE | authorityMore},
+			expected: ErrBadOption,
+		},
+		{
+			name:     "Terminated too early",
+			input:    []byte{130, 5, 0x3D, AuthorityDOE, 0x02},
+			expected: ErrBadOption,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			opt := Option{}
+			if err := opt.Unmarshal(test.input); err != nil {
+				t.Fatal(err)
+			}
+
+			bso, err := BasicSecurityFromOption(opt)
+			if !errors.Is(err, test.expected) {
+				t.Fatalf("Error not expected %v", err)
+			}
+
+			if err != nil {
+				return
+			}
+
+			data := bso.Option()
+			marshaled := data.Marshal()
+
+			if len(marshaled) != len(test.input) {
+				t.Fatalf("Wrong length %v", marshaled)
+			}
+
+			for i, b := range test.input {
+				if marshaled[i] != b {
+					t.Errorf("Marshaled %d not equeal expected %d", marshaled[i], b)
+				}
+			}
+		})
+	}
+}
+
+func Test_CIPSOFromOption(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    []byte
+		expected error
+	}{
+		{
+			name:  "Restricted bitmap",
+			input: []byte{134, 12, 0, 0, 0, 3, 1, 6, 0, 5, 0xA0, 0x01},
+		},
+		{
+			name:  "Enumerated categories",
+			input: []byte{134, 14, 0, 0, 0, 3, 2, 8, 0, 5, 0, 1, 0, 9},
+		},
+		{
+			name:  "Ranged categories",
+			input: []byte{134, 18, 0, 0, 0, 3, 5, 12, 0, 5, 0, 20, 0, 10, 0, 5, 0, 1},
+		},
+		{
+			name:  "Several tags",
+			input: []byte{134, 14, 0, 0, 0, 3, 1, 4, 0, 5, 2, 4, 0, 7},
+		},
+		{
+			name:     "Reserved DOI",
+			input:    []byte{134, 10, 0, 0, 0, 0, 1, 4, 0, 5},
+			expected: ErrBadOption,
+		},
+		{
+			name:     "Unsupported tag",
+			input:    []byte{134, 10, 0, 0, 0, 3, 7, 4, 0, 5},
+			expected: ErrBadOption,
+		},
+		{
+			name:     "Nonzero alignment octet",
+			input:    []byte{134, 10, 0, 0, 0, 3, 1, 4, 1, 5},
+			expected: ErrBadOption,
+		},
+		{
+			name:     "Tag beyond option",
+			input:    []byte{134, 10, 0, 0, 0, 3, 1, 6, 0, 5},
+			expected: ErrBadOption,
+		},
+		{
+			name:     "Categories not ascending",
+			input:    []byte{134, 14, 0, 0, 0, 3, 2, 8, 0, 5, 0, 9, 0, 1},
+			expected: ErrBadOption,
+		},
+		{
+			name:     "Reversed range",
+			input:    []byte{134, 14, 0, 0, 0, 3, 5, 8, 0, 5, 0, 1, 0, 9},
+			expected: ErrBadOption,
+		},
+		{
+			name:     "Overlapped ranges",
+			input:    []byte{134, 18, 0, 0, 0, 3, 5, 12, 0, 5, 0, 20, 0, 10, 0, 15, 0, 1},
+			expected: ErrBadOption,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			opt := Option{}
+			if err := opt.Unmarshal(test.input); err != nil {
+				t.Fatal(err)
+			}
+
+			c, err := CIPSOFromOption(opt)
+			if !errors.Is(err, test.expected) {
+				t.Fatalf("Error not expected %v", err)
+			}
+
+			if err != nil {
+				return
+			}
+
+			data := c.Option()
+			marshaled := data.Marshal()
+
+			if len(marshaled) != len(test.input) {
+				t.Fatalf("Wrong length %v", marshaled)
+			}
+
+			for i, b := range test.input {
+				if marshaled[i] != b {
+					t.Errorf("Marshaled %d not equeal expected %d", marshaled[i], b)
+				}
+			}
+		})
+	}
+}
+
+func Test_CIPSOTag_HasCategory(t *testing.T) {
+	tests := []struct {
+		name     string
+		tag      CIPSOTag
+		category uint16
+		expected bool
+	}{
+		{
+			name:     "Bitmap set",
+			tag:      CIPSOTag{Type: CIPSOTagRestricted, Bitmap: []byte{0x00, 0x40}},
+			category: 9,
+			expected: true,
+		},
+		{
+			name:     "Bitmap beyond",
+			tag:      CIPSOTag{Type: CIPSOTagRestricted, Bitmap: []byte{0xFF}},
+			category: 9,
+		},
+		{
+			name:     "Enumerated",
+			tag:      CIPSOTag{Type: CIPSOTagEnumerated, Categories: []uint16{1, 9}},
+			category: 9,
+			expected: true,
+		},
+		{
+			name:     "Ranged",
+			tag:      CIPSOTag{Type: CIPSOTagRanged, Ranges: []CIPSORange{{High: 20, Low: 10}}},
+			category: 9,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if test.tag.HasCategory(test.category) != test.expected {
+				t.Errorf("Category %d not expected", test.category)
+			}
+		})
+	}
+}
+
+func Test_Packet_SetCIPSO(t *testing.T) {
+	p := New(IPAddr{}, IPAddr{}, nil)
+
+	if err := p.SetCIPSO(&CIPSOOption{DOI: 3, Tags: []CIPSOTag{{Type: CIPSOTagEnumerated, Level: 1, Categories: []uint16{2}}}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := p.SetCIPSO(&CIPSOOption{DOI: 4, Tags: []CIPSOTag{{Type: CIPSOTagRestricted, Level: 2}}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := p.SetCIPSO(&CIPSOOption{DOI: 0}); !errors.Is(err, ErrBadOption) {
+		t.Errorf("Error not expected %v", err)
+	}
+
+	if len(p.Options) != 1 {
+		t.Fatalf("label not replaced %v", p.Options)
+	}
+
+	q := &Packet{}
+	if err := q.Unmarshal(p.Marshal()); err != nil {
+		t.Fatal(err)
+	}
+
+	opt, ok := q.FindOption(OptionCIPSO)
+	if !ok {
+		t.Fatal("label not found")
+	}
+
+	c, err := CIPSOFromOption(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c.DOI != 4 || c.Tags[0].Level != 2 {
+		t.Errorf("Label not expected %v", c)
+	}
+}
+
+func Test_Packet_SetBasicSecurity(t *testing.T) {
+	p := New(IPAddr{}, IPAddr{}, nil)
+
+	bso, err := NewBasicSecurityOption(ClassificationSecret, AuthorityGENSER)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := p.SetBasicSecurity(bso); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := NewBasicSecurityOption(0x42, 0); !errors.Is(err, ErrBadOption) {
+		t.Errorf("Error not expected %v", err)
+	}
+
+	opt, ok := p.FindOption(OptionBasicSecurity)
+	if !ok {
+		t.Fatal("label not found")
+	}
+
+	got, err := BasicSecurityFromOption(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got.Classification != ClassificationSecret || !got.HasAuthority(AuthorityGENSER) {
+		t.Errorf("Label not expected %v", got)
+	}
+}
+
+func Test_Packet_SetSecurity_Padded(t *testing.T) {
+	// 5 bytes option is padded by two No Operation and End Of Option list
+	opt := Option{Type: OptionType{Value: 0x80 | OptionExperiment}, Length: 5, Value: []byte{1, 2, 3}}
+	padded := New(IPAddr{}, IPAddr{}, nil).WithOptions(opt).Marshal()
+
+	bso, err := NewBasicSecurityOption(ClassificationSecret, AuthorityGENSER)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		set    func(p *Packet) error
+		number uint8
+	}{
+		{
+			name:   "Basic security",
+			set:    func(p *Packet) error { return p.SetBasicSecurity(bso) },
+			number: OptionBasicSecurity,
+		},
+		{
+			name: "CIPSO",
+			set: func(p *Packet) error {
+				return p.SetCIPSO(&CIPSOOption{DOI: 3, Tags: []CIPSOTag{{Type: CIPSOTagRestricted, Level: 2}}})
+			},
+			number: OptionCIPSO,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p := &Packet{}
+			if err := p.Unmarshal(padded); err != nil {
+				t.Fatal(err)
+			}
+
+			if len(p.Options) != 4 {
+				t.Fatalf("Padding not expected %v", p.Options)
+			}
+
+			if err := test.set(p); err != nil {
+				t.Fatal(err)
+			}
+
+			if len(p.Options) != 2 || p.Options[1].Type.Number() != test.number {
+				t.Fatalf("Options not expected %v", p.Options)
+			}
+
+			q := &Packet{}
+			if err := q.Unmarshal(p.Marshal()); err != nil {
+				t.Fatal(err)
+			}
+
+			if _, ok := q.FindOption(test.number); !ok || q.Options[0].Type != opt.Type {
+				t.Errorf("Options not expected %v", q.Options)
+			}
+		})
+	}
+}
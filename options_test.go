@@ -1,6 +1,9 @@
 package ipv4
 
-import "testing"
+import (
+	"errors"
+	"testing"
+)
 
 func Test_OptionType_Copied(t *testing.T) {
 	tests := []struct {
@@ -120,6 +123,16 @@ func Test_Option_Marshal(t *testing.T) {
 			option:   Option{Type: OptionType{Value: 231}, Length: 3, Value: []byte{0xFF, 0xFE, 0xFD}},
 			expected: []byte{231, 3, 0xFF},
 		},
+		{
+			name:     "Length less than 2",
+			option:   Option{Type: OptionType{Value: 231}, Length: 1, Value: []byte{0xFF}},
+			expected: []byte{231, 2},
+		},
+		{
+			name:     "Value shorter than length",
+			option:   Option{Type: OptionType{Value: 231}, Length: 4, Value: []byte{0xFF}},
+			expected: []byte{231, 4, 0xFF, 0x00},
+		},
 	}
 
 	for _, test := range tests {
@@ -188,3 +201,41 @@ func Test_Option_Unmarshal(t *testing.T) {
 		})
 	}
 }
+
+func Test_Option_Unmarshal_Errors(t *testing.T) {
+	tests := []struct {
+		name  string
+		input []byte
+	}{
+		{
+			name:  "Empty input",
+			input: []byte{},
+		},
+		{
+			name:  "Missing length",
+			input: []byte{231},
+		},
+		{
+			name:  "Zero length",
+			input: []byte{231, 0, 0xFF},
+		},
+		{
+			name:  "Length less than two",
+			input: []byte{231, 1, 0xFF},
+		},
+		{
+			name:  "Length beyond input",
+			input: []byte{231, 4, 0xFF},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p := Option{}
+
+			if err := p.Unmarshal(test.input); !errors.Is(err, ErrBadOption) {
+				t.Errorf("Error not expected %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,276 @@
+package ipv4
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Internet Timestamp option number, see OptionType.Number
+const OptionTimestamp = 4
+
+const (
+	timestampHeaderLength = 4 // type, length, pointer and overflow/flag octets
+	minTimestampPointer   = 5 // pointer to the first timestamp slot
+	maxTimestampOverflow  = 15
+
+	// set in timestamp when time is not in milliseconds since midnight UT
+	NonStandardTimestamp = 1 << 31
+)
+
+// ErrTimestampOverflow is returned when overflow counter of full timestamp
+// option overflows itself, RFC791 requires to discard such datagram
+var ErrTimestampOverflow = errors.New("timestamp overflow counter overflowed")
+
+// TimestampFlag describes format of timestamp option data
+type TimestampFlag uint8
+
+const (
+	// TimestampOnly stores only timestamps, 4 bytes each
+	TimestampOnly TimestampFlag = 0
+	// TimestampWithAddress stores address of each hop followed by timestamp
+	TimestampWithAddress TimestampFlag = 1
+	// TimestampPrespecified stores timestamps only for addresses set by sender
+	TimestampPrespecified TimestampFlag = 3
+)
+
+// slotSize returns count of bytes of one entry
+func (f TimestampFlag) slotSize() int {
+	if f == TimestampOnly {
+		return 4
+	}
+
+	return 8
+}
+
+// TimestampEntry is a single slot of timestamp option, Addr is not used
+// with TimestampOnly flag
+type TimestampEntry struct {
+	Addr IPAddr
+	Time uint32
+}
+
+// +--------+--------+--------+--------+
+// |01000100| length | pointer|oflw|flg|
+// +--------+--------+--------+--------+
+// |         internet address          |
+// +--------+--------+--------+--------+
+// |             timestamp             |
+// +--------+--------+--------+--------+
+// |                 .                 |
+//
+//	Internet Timestamp option from RFC791
+//
+// Pointer is the octet offset of the next free slot counted from the option
+// type octet, the smallest legal value is 5. Overflow counts hops which could
+// not stamp because there was no free slot left.
+type TimestampOption struct {
+	Pointer  uint8
+	Overflow uint8
+	Flag     TimestampFlag
+	Entries  []TimestampEntry
+}
+
+// NewTimestampOption creates option with n empty slots for flag
+// TimestampOnly or TimestampWithAddress
+func NewTimestampOption(flag TimestampFlag, n int) (*TimestampOption, error) {
+	if flag != TimestampOnly && flag != TimestampWithAddress {
+		return nil, fmt.Errorf("wrong timestamp flag %d", flag)
+	}
+
+	if n < 1 || timestampHeaderLength+n*flag.slotSize() > maxOptionsLength {
+		return nil, fmt.Errorf("wrong count of timestamp slots %d", n)
+	}
+
+	return &TimestampOption{
+		Pointer: minTimestampPointer,
+		Flag:    flag,
+		Entries: make([]TimestampEntry, n),
+	}, nil
+}
+
+// NewPrespecifiedTimestampOption creates option which is stamped only by
+// listed addresses in the same order
+func NewPrespecifiedTimestampOption(addrs ...IPAddr) (*TimestampOption, error) {
+	if len(addrs) < 1 || timestampHeaderLength+len(addrs)*8 > maxOptionsLength {
+		return nil, fmt.Errorf("wrong count of timestamp addresses %d", len(addrs))
+	}
+
+	entries := make([]TimestampEntry, len(addrs))
+
+	for i, addr := range addrs {
+		entries[i].Addr = addr
+	}
+
+	return &TimestampOption{
+		Pointer: minTimestampPointer,
+		Flag:    TimestampPrespecified,
+		Entries: entries,
+	}, nil
+}
+
+// TimestampFromOption converts generic option to Internet Timestamp option
+func TimestampFromOption(o Option) (*TimestampOption, error) {
+	if o.Type.Number() != OptionTimestamp {
+		return nil, fmt.Errorf("%w: option %d is not timestamp", ErrBadOption, o.Type.Value)
+	}
+
+	if len(o.Value) < 2 || len(o.Value) != int(o.Length)-2 {
+		return nil, fmt.Errorf("%w: timestamp option has length %d", ErrBadOption, o.Length)
+	}
+
+	t := &TimestampOption{
+		Pointer:  o.Value[0],
+		Overflow: o.Value[1] >> 4,
+		Flag:     TimestampFlag(o.Value[1] & 15),
+	}
+
+	if t.Flag != TimestampOnly && t.Flag != TimestampWithAddress && t.Flag != TimestampPrespecified {
+		return nil, fmt.Errorf("%w: timestamp option has flag %d", ErrBadOption, t.Flag)
+	}
+
+	size := t.Flag.slotSize()
+	data := o.Value[2:]
+
+	if len(data)%size != 0 {
+		return nil, fmt.Errorf("%w: timestamp option has length %d", ErrBadOption, o.Length)
+	}
+
+	if t.Pointer < minTimestampPointer || int(t.Pointer-minTimestampPointer)%size != 0 || int(t.Pointer) > int(o.Length)+1 {
+		return nil, fmt.Errorf("%w: timestamp option has pointer %d", ErrBadOption, t.Pointer)
+	}
+
+	t.Entries = make([]TimestampEntry, len(data)/size)
+
+	for i := range t.Entries {
+		slot := data[i*size : (i+1)*size]
+
+		if size == 8 {
+			copy(t.Entries[i].Addr[:], slot[:4])
+			slot = slot[4:]
+		}
+
+		t.Entries[i].Time = binary.BigEndian.Uint32(slot)
+	}
+
+	return t, nil
+}
+
+// Recorded returns already stamped entries
+func (t *TimestampOption) Recorded() []TimestampEntry {
+	return t.Entries[:t.used()]
+}
+
+// Full reports whether there is no free slot left
+func (t *TimestampOption) Full() bool {
+	return t.used() >= len(t.Entries)
+}
+
+// Stamp records passing of datagram through local address at now. When
+// option is full overflow counter is incremented instead. Prespecified
+// option is stamped only when the next address matches local.
+func (t *TimestampOption) Stamp(local IPAddr, now time.Time) error {
+	if t.Full() {
+		if t.Overflow >= maxTimestampOverflow {
+			return ErrTimestampOverflow
+		}
+
+		t.Overflow++
+
+		return nil
+	}
+
+	i := t.used()
+
+	switch t.Flag {
+	case TimestampWithAddress:
+		t.Entries[i].Addr = local
+	case TimestampPrespecified:
+		if t.Entries[i].Addr != local {
+			return nil
+		}
+	}
+
+	t.Entries[i].Time = TimestampFromTime(now)
+	t.Pointer += uint8(t.Flag.slotSize())
+
+	return nil
+}
+
+// Option converts Internet Timestamp option to generic option
+func (t *TimestampOption) Option() Option {
+	size := t.Flag.slotSize()
+
+	value := make([]byte, 2, 2+len(t.Entries)*size)
+	value[0] = t.Pointer
+	value[1] = t.Overflow<<4 | uint8(t.Flag)&15
+
+	for _, entry := range t.Entries {
+		if size == 8 {
+			value = append(value, entry.Addr[:]...)
+		}
+
+		value = binary.BigEndian.AppendUint32(value, entry.Time)
+	}
+
+	return Option{
+		Type:   OptionType{Value: 2<<5 | OptionTimestamp},
+		Length: uint8(2 + len(value)),
+		Value:  value,
+	}
+}
+
+func (t *TimestampOption) used() int {
+	if t.Pointer < minTimestampPointer {
+		return 0
+	}
+
+	return int(t.Pointer-minTimestampPointer) / t.Flag.slotSize()
+}
+
+// StampTimestamp stamps timestamp option of packet if there is one,
+// see TimestampOption.Stamp
+func (p *Packet) StampTimestamp(local IPAddr, now time.Time) error {
+	for i, opt := range p.Options {
+		if opt.Type.Number() != OptionTimestamp {
+			continue
+		}
+
+		t, err := TimestampFromOption(opt)
+		if err != nil {
+			return err
+		}
+
+		if err := t.Stamp(local, now); err != nil {
+			return err
+		}
+
+		p.Options[i] = t.Option()
+
+		return nil
+	}
+
+	return nil
+}
+
+// TimestampFromTime returns milliseconds since midnight UT
+func TimestampFromTime(t time.Time) uint32 {
+	t = t.UTC()
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+
+	return uint32(t.Sub(midnight).Milliseconds())
+}
+
+// TimeFromTimestamp converts milliseconds since midnight UT to time of the
+// same day as day. Non standard timestamps can not be converted.
+func TimeFromTimestamp(ts uint32, day time.Time) (time.Time, error) {
+	if ts&NonStandardTimestamp != 0 {
+		return time.Time{}, fmt.Errorf("timestamp %d is not standard", ts)
+	}
+
+	day = day.UTC()
+	midnight := time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, time.UTC)
+
+	return midnight.Add(time.Duration(ts) * time.Millisecond), nil
+}
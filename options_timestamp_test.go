@@ -0,0 +1,197 @@
+package ipv4
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func Test_TimestampOption_Stamp(t *testing.T) {
+	local, _ := IPFromString("10.0.0.1")
+	other, _ := IPFromString("10.0.0.2")
+	now := time.Date(2025, 6, 13, 1, 2, 3, 4000000, time.UTC)
+
+	tests := []struct {
+		name    string
+		flag    TimestampFlag
+		addrs   []IPAddr
+		pointer uint8
+		entry   TimestampEntry
+	}{
+		{
+			name:    "Timestamps only",
+			flag:    TimestampOnly,
+			pointer: 9,
+			entry:   TimestampEntry{Time: 3723004},
+		},
+		{
+			name:    "Timestamps with address",
+			flag:    TimestampWithAddress,
+			pointer: 13,
+			entry:   TimestampEntry{Addr: local, Time: 3723004},
+		},
+		{
+			name:    "Prespecified address",
+			flag:    TimestampPrespecified,
+			addrs:   []IPAddr{local, other},
+			pointer: 13,
+			entry:   TimestampEntry{Addr: local, Time: 3723004},
+		},
+		{
+			name:    "Other prespecified address",
+			flag:    TimestampPrespecified,
+			addrs:   []IPAddr{other, local},
+			pointer: 5,
+			entry:   TimestampEntry{Addr: other},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var ts *TimestampOption
+			var err error
+
+			if test.flag == TimestampPrespecified {
+				ts, err = NewPrespecifiedTimestampOption(test.addrs...)
+			} else {
+				ts, err = NewTimestampOption(test.flag, 2)
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := ts.Stamp(local, now); err != nil {
+				t.Fatal(err)
+			}
+
+			if ts.Pointer != test.pointer {
+				t.Errorf("Pointer not expected %d", ts.Pointer)
+			}
+
+			if ts.Entries[0] != test.entry {
+				t.Errorf("Entry not expected %v", ts.Entries[0])
+			}
+		})
+	}
+}
+
+func Test_TimestampOption_Overflow(t *testing.T) {
+	ts, _ := NewTimestampOption(TimestampOnly, 1)
+	now := time.Now()
+
+	for i := 0; i < 16; i++ {
+		if err := ts.Stamp(IPAddr{}, now); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if ts.Overflow != 15 {
+		t.Errorf("Overflow not expected %d", ts.Overflow)
+	}
+
+	if err := ts.Stamp(IPAddr{}, now); !errors.Is(err, ErrTimestampOverflow) {
+		t.Errorf("Error not expected %v", err)
+	}
+}
+
+func Test_TimestampFromOption(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    []byte
+		expected error
+	}{
+		{
+			name:  "Timestamps only",
+			input: []byte{68, 12, 9, 0, 0, 0, 0, 1, 0, 0, 0, 0},
+		},
+		{
+			name:  "Full with overflow",
+			input: []byte{68, 12, 13, 0x30, 10, 0, 0, 1, 0, 0, 0, 1},
+		},
+		{
+			name:     "Wrong flag",
+			input:    []byte{68, 12, 5, 2, 0, 0, 0, 1, 0, 0, 0, 0},
+			expected: ErrBadOption,
+		},
+		{
+			name:     "Partial slot",
+			input:    []byte{68, 10, 5, 1, 10, 0, 0, 1, 0, 0},
+			expected: ErrBadOption,
+		},
+		{
+			name:     "Pointer not aligned",
+			input:    []byte{68, 12, 9, 1, 10, 0, 0, 1, 0, 0, 0, 1},
+			expected: ErrBadOption,
+		},
+		{
+			name:     "Pointer less than minimum",
+			input:    []byte{68, 8, 4, 0, 0, 0, 0, 1},
+			expected: ErrBadOption,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			opt := Option{}
+			if err := opt.Unmarshal(test.input); err != nil {
+				t.Fatal(err)
+			}
+
+			ts, err := TimestampFromOption(opt)
+			if !errors.Is(err, test.expected) {
+				t.Fatalf("Error not expected %v", err)
+			}
+
+			if err != nil {
+				return
+			}
+
+			data := ts.Option()
+			marshaled := data.Marshal()
+
+			for i, b := range test.input {
+				if marshaled[i] != b {
+					t.Errorf("Marshaled %d not equeal expected %d", marshaled[i], b)
+				}
+			}
+		})
+	}
+}
+
+func Test_Packet_StampTimestamp(t *testing.T) {
+	local, _ := IPFromString("10.0.0.1")
+
+	ts, _ := NewTimestampOption(TimestampWithAddress, 1)
+	p := New(IPAddr{}, IPAddr{}, nil).WithOptions(ts.Option())
+
+	if err := p.StampTimestamp(local, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	stamped, err := TimestampFromOption(p.Options[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(stamped.Recorded()) != 1 || stamped.Recorded()[0].Addr != local {
+		t.Errorf("Recorded not expected %v", stamped.Recorded())
+	}
+}
+
+func Test_TimeFromTimestamp(t *testing.T) {
+	now := time.Date(2025, 6, 13, 23, 59, 59, 999000000, time.UTC)
+
+	got, err := TimeFromTimestamp(TimestampFromTime(now), now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !got.Equal(now) {
+		t.Errorf("Time not expected %v", got)
+	}
+
+	if _, err := TimeFromTimestamp(NonStandardTimestamp|1, now); err == nil {
+		t.Error("non standard timestamp converted")
+	}
+}
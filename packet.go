@@ -1,8 +1,11 @@
 package ipv4
 
 import (
+	"bytes"
 	"encoding/binary"
+	"errors"
 	"fmt"
+	"math"
 	"strconv"
 	"strings"
 )
@@ -35,8 +38,75 @@ func (f *FlagsFrOffset) Flags() uint16 {
 	return f.Value >> 13
 }
 
+const (
+	flagReserved       = 0x8000
+	flagDontFragment   = 0x4000
+	flagMoreFragments  = 0x2000
+	fragmentOffsetMask = 0x1FFF
+
+	fragmentUnit = 8 // fragment offset is measured in units of 8 octets
+)
+
 func (f *FlagsFrOffset) FragmentOffset() uint16 {
-	return f.Value & 0x1FFF
+	return f.Value & fragmentOffsetMask
+}
+
+// FragmentOffsetBytes returns fragment offset in octets
+func (f *FlagsFrOffset) FragmentOffsetBytes() int {
+	return int(f.FragmentOffset()) * fragmentUnit
+}
+
+// SetFragmentOffset sets fragment offset in octets, it must be multiple of 8
+func (f *FlagsFrOffset) SetFragmentOffset(bytes int) error {
+	if bytes%fragmentUnit != 0 {
+		return fmt.Errorf("fragment offset %d is not multiple of %d", bytes, fragmentUnit)
+	}
+
+	if bytes < 0 || bytes/fragmentUnit > fragmentOffsetMask {
+		return fmt.Errorf("fragment offset %d is out of range", bytes)
+	}
+
+	f.Value = f.Value&^fragmentOffsetMask | uint16(bytes/fragmentUnit)
+
+	return nil
+}
+
+// Reserved returns reserved flag, it must be zero
+func (f *FlagsFrOffset) Reserved() bool {
+	return f.Value&flagReserved != 0
+}
+
+// DontFragment returns Don't Fragment flag
+func (f *FlagsFrOffset) DontFragment() bool {
+	return f.Value&flagDontFragment != 0
+}
+
+// MoreFragments returns More Fragments flag, it is clear in the last fragment
+func (f *FlagsFrOffset) MoreFragments() bool {
+	return f.Value&flagMoreFragments != 0
+}
+
+// SetReserved sets reserved flag, it is kept zero by senders
+func (f *FlagsFrOffset) SetReserved(v bool) {
+	f.setFlag(flagReserved, v)
+}
+
+// SetDontFragment sets Don't Fragment flag, such datagram is not fragmented
+func (f *FlagsFrOffset) SetDontFragment(v bool) {
+	f.setFlag(flagDontFragment, v)
+}
+
+// SetMoreFragments sets More Fragments flag of all fragments but the last
+func (f *FlagsFrOffset) SetMoreFragments(v bool) {
+	f.setFlag(flagMoreFragments, v)
+}
+
+func (f *FlagsFrOffset) setFlag(flag uint16, v bool) {
+	if v {
+		f.Value |= flag
+	} else {
+		f.Value &^= flag
+	}
 }
 
 type IPAddr [4]byte
@@ -92,6 +162,33 @@ func IPFromBytes(b []byte) (IPAddr, error) {
 
 const ipHeaderLength = 20
 
+// Upper layer protocol numbers assigned by IANA, see Packet.Protocol
+const (
+	ProtocolICMP = 1
+	ProtocolIGMP = 2
+	ProtocolIPIP = 4
+	ProtocolTCP  = 6
+	ProtocolUDP  = 17
+	ProtocolGRE  = 47
+	ProtocolESP  = 50
+	ProtocolAH   = 51
+	ProtocolOSPF = 89
+	ProtocolSCTP = 132
+)
+
+// Errors returned by Packet.Unmarshal for malformed datagrams
+var (
+	ErrTruncated      = errors.New("packet is truncated")
+	ErrBadVersion     = errors.New("wrong ip version")
+	ErrBadIHL         = errors.New("wrong internet header length")
+	ErrBadTotalLength = errors.New("wrong total length")
+	ErrBadOption      = errors.New("malformed option")
+)
+
+// ErrOptionsTooLong is returned for options which do not fit 40 bytes of
+// header allowed by IHL
+var ErrOptionsTooLong = errors.New("options exceed 40 bytes")
+
 // Describe IP datagram from RFC791  https://datatracker.ietf.org/doc/html/rfc791
 type Packet struct {
 	VerIHL   VersionIHL // combine Version and IHL fields
@@ -107,6 +204,11 @@ type Packet struct {
 
 	Data    []byte
 	Options []Option
+	// Typed keeps options decoded by Unmarshal with registered codecs (see
+	// RegisterOption), Marshal encodes Options only
+	Typed []TypedOption
+
+	header []byte // copy of raw header kept by Unmarshal for checksum verification
 }
 
 func New(src, dst IPAddr, data []byte) *Packet {
@@ -115,9 +217,9 @@ func New(src, dst IPAddr, data []byte) *Packet {
 		TOS:      0,
 		Length:   uint16(ipHeaderLength + len(data)),
 		ID:       0,
-		FlFrOff:  FlagsFrOffset{Value: 16384}, // don't fragment flag
+		FlFrOff:  FlagsFrOffset{}, // may be fragmented, see Packet.Fragment
 		TTL:      64,
-		Protocol: 6,
+		Protocol: ProtocolTCP,
 		Src:      src,
 		Dst:      dst,
 		Data:     data,
@@ -126,23 +228,147 @@ func New(src, dst IPAddr, data []byte) *Packet {
 	return p
 }
 
+// WithOptions appends options to packet
 func (p *Packet) WithOptions(opts ...Option) *Packet {
 	p.Options = append(p.Options, opts...)
 
 	return p
 }
 
+// WithTypedOptions appends typed options encoded with registered codecs (see
+// RegisterOption), packet is not changed when one of them can't be encoded
+func (p *Packet) WithTypedOptions(opts ...TypedOption) (*Packet, error) {
+	encoded := make([]Option, 0, len(opts))
+
+	for _, opt := range opts {
+		o, err := EncodeOption(opt)
+		if err != nil {
+			return p, err
+		}
+
+		encoded = append(encoded, o)
+	}
+
+	p.Options = append(p.Options, encoded...)
+
+	return p, nil
+}
+
+// WithProtocol sets up upper layer protocol, by default is TCP
+func (p *Packet) WithProtocol(protocol uint8) *Packet {
+	p.Protocol = protocol
+
+	return p
+}
+
+// WithDontFragment sets up Don't Fragment flag, such packet is not
+// fragmented on send
+func (p *Packet) WithDontFragment(v bool) *Packet {
+	p.FlFrOff.SetDontFragment(v)
+
+	return p
+}
+
+// IsFragment reports whether packet is a fragment of bigger datagram
+func (p *Packet) IsFragment() bool {
+	return p.FlFrOff.MoreFragments() || p.FlFrOff.FragmentOffset() != 0
+}
+
+// FindOption returns the first option with number, see OptionType.Number
+func (p *Packet) FindOption(number uint8) (Option, bool) {
+	for _, opt := range p.Options {
+		if opt.Type.Number() == number {
+			return opt, true
+		}
+	}
+
+	return Option{}, false
+}
+
+// setOption replaces option with the same number or appends new one before
+// End Of Option list, it fails when options do not fit header then
+func (p *Packet) setOption(o Option) error {
+	opts := append([]Option(nil), withoutPadding(p.Options)...)
+	replaced := false
+
+	for i, opt := range opts {
+		if opt.Type.Number() == o.Type.Number() {
+			opts[i] = o
+			replaced = true
+
+			break
+		}
+	}
+
+	if !replaced {
+		opts = append(opts, o)
+	}
+
+	if length := optionsLength(opts); length > maxOptionsLength {
+		return fmt.Errorf("%w: %d bytes", ErrOptionsTooLong, length)
+	}
+
+	p.Options = opts
+
+	return nil
+}
+
+// withoutPadding returns options preceding End Of Option list and No
+// Operation options padding it, setOption inserts labels before them and
+// marshalOptions pads options again
+func withoutPadding(opts []Option) []Option {
+	for i, opt := range opts {
+		if opt.Type.Number() != 0 { // End Of Option list
+			continue
+		}
+
+		for i > 0 && opts[i-1].Type.Number() == 1 { // No Operation
+			i--
+		}
+
+		return opts[:i]
+	}
+
+	return opts
+}
+
+// Marshal returns wire representation of packet. IHL and Total Length are
+// derived from current options and payload, packet itself is not changed,
+// so it is safe to marshal the same packet several times.
+// Packet which can't be encoded, because options exceed 40 bytes or total
+// length exceeds 65535 bytes, is marshaled to nil. Callers which may get such
+// packet have to use MarshalChecked to tell it from valid one.
 func (p *Packet) Marshal() []byte {
+	data, err := p.MarshalChecked()
+	if err != nil {
+		return nil
+	}
+
+	return data
+}
+
+// MarshalChecked works as Marshal, but reports packet which can't be
+// encoded: options exceeding 40 bytes with ErrOptionsTooLong and total length
+// exceeding 65535 bytes with ErrBadTotalLength
+func (p *Packet) MarshalChecked() ([]byte, error) {
+	if length := optionsLength(p.Options); length > maxOptionsLength {
+		return nil, fmt.Errorf("%w: %d bytes", ErrOptionsTooLong, length)
+	}
+
 	options := p.marshalOptions() // first of all we marshal options
 
-	p.Length += uint16(len(options)) // we need to recalculate length with options
+	headerLength := ipHeaderLength + len(options)
 
-	buf := make([]byte, len(p.Data)+ipHeaderLength+len(options)) // create buffer for whole packet
+	if length := headerLength + len(p.Data); length > math.MaxUint16 {
+		return nil, fmt.Errorf("%w: %d exceeds %d bytes", ErrBadTotalLength, length, math.MaxUint16)
+	}
+
+	buf := make([]byte, headerLength+len(p.Data)) // create buffer for whole packet
 
 	// start to fill required fields
-	buf[0] = p.VerIHL.Value
+	buf[0] = p.VerIHL.Version()<<4 | uint8(headerLength/4)
 	buf[1] = p.TOS
-	binary.BigEndian.PutUint16(buf[2:4], p.Length)
+	binary.BigEndian.PutUint16(buf[2:4], uint16(len(buf)))
 	binary.BigEndian.PutUint16(buf[4:6], p.ID)
 	binary.BigEndian.PutUint16(buf[6:8], p.FlFrOff.Value)
 	buf[8] = p.TTL
@@ -151,18 +377,35 @@ func (p *Packet) Marshal() []byte {
 	copy(buf[16:20], p.Dst[:])
 
 	// copy options bytes
-	copy(buf[20:20+len(options)], options[:])
+	copy(buf[20:headerLength], options)
 
 	// copy payload bytes
-	copy(buf[20+len(options):], p.Data)
+	copy(buf[headerLength:], p.Data)
 
-	// recalculate checksum
-	p.Checksum = p.CalculateChecksum(buf[:20+len(options)])
-	binary.BigEndian.PutUint16(buf[10:12], p.Checksum)
+	// calculate checksum
+	binary.BigEndian.PutUint16(buf[10:12], p.CalculateChecksum(buf[:headerLength]))
 
-	return buf
+	return buf, nil
+}
+
+// HeaderLength returns length of header produced by Marshal, which may differ
+// from IHL field of packet built or changed by hand
+func (p *Packet) HeaderLength() int {
+	return ipHeaderLength + len(p.marshalOptions())
 }
 
+// optionsLength returns count of bytes occupied by opts without padding
+func optionsLength(opts []Option) int {
+	length := 0
+
+	for i := range opts {
+		length += len(opts[i].Marshal())
+	}
+
+	return length
+}
+
+// marshalOptions encodes options padded to 32 bit words
 func (p *Packet) marshalOptions() []byte {
 	buf := make([]byte, 0, len(p.Options)*3)
 
@@ -181,18 +424,47 @@ func (p *Packet) marshalOptions() []byte {
 		buf = append(buf, eool.Marshal()...)
 	}
 
-	// we have to change IHL field
-	p.VerIHL.Value += uint8(len(buf) / 4)
-
 	return buf
 }
 
-func (p *Packet) Unmarshal(data []byte) {
+// Unmarshal parses IP datagram from data. Returned error wraps one of
+// ErrTruncated, ErrBadVersion, ErrBadIHL, ErrBadTotalLength or ErrBadOption.
+func (p *Packet) Unmarshal(data []byte) error {
+	if err := p.UnmarshalHeader(data); err != nil {
+		return err
+	}
+
+	if int(p.Length) > len(data) {
+		return fmt.Errorf("%w: %d, got %d bytes", ErrBadTotalLength, p.Length, len(data))
+	}
+
+	return nil
+}
+
+// UnmarshalHeader works as Unmarshal, but accepts datagram truncated after
+// header, e.g. quoted by ICMP error message. Data keeps the received part
+// of payload.
+func (p *Packet) UnmarshalHeader(data []byte) error {
 	if len(data) < ipHeaderLength {
-		return
+		return fmt.Errorf("%w: got %d bytes, need at least %d", ErrTruncated, len(data), ipHeaderLength)
 	}
 
 	p.VerIHL = VersionIHL{Value: data[0]}
+
+	if p.VerIHL.Version() != 4 {
+		return fmt.Errorf("%w: %d", ErrBadVersion, p.VerIHL.Version())
+	}
+
+	headerLength := int(p.VerIHL.IHL()) * 4
+
+	if headerLength < ipHeaderLength {
+		return fmt.Errorf("%w: %d", ErrBadIHL, p.VerIHL.IHL())
+	}
+
+	if headerLength > len(data) {
+		return fmt.Errorf("%w: header is %d bytes, got %d", ErrTruncated, headerLength, len(data))
+	}
+
 	p.TOS = data[1]
 	p.Length = binary.BigEndian.Uint16(data[2:4])
 	p.ID = binary.BigEndian.Uint16(data[4:6])
@@ -201,45 +473,108 @@ func (p *Packet) Unmarshal(data []byte) {
 	p.Protocol = data[9]
 	p.Checksum = binary.BigEndian.Uint16(data[10:12])
 
-	p.Src, _ = IPFromBytes(data[12:16])
-	p.Dst, _ = IPFromBytes(data[16:20])
+	if int(p.Length) < headerLength {
+		return fmt.Errorf("%w: %d is less than header length %d", ErrBadTotalLength, p.Length, headerLength)
+	}
 
-	p.unmarshalOptions(data)
+	var err error
 
-	p.Data = data[p.VerIHL.IHL()*4:]
-}
+	p.Src, err = IPFromBytes(data[12:16])
+	if err != nil {
+		return fmt.Errorf("failed to parse source addr: %w", err)
+	}
+
+	p.Dst, err = IPFromBytes(data[16:20])
+	if err != nil {
+		return fmt.Errorf("failed to parse destination addr: %w", err)
+	}
+
+	p.Options = nil
+	p.header = append([]byte(nil), data[:headerLength]...)
 
-func (p *Packet) unmarshalOptions(data []byte) {
-	if p.VerIHL.IHL()*4 == ipHeaderLength {
-		return
+	if err := p.unmarshalOptions(data[ipHeaderLength:headerLength]); err != nil {
+		return err
 	}
 
-	optionsNotEmpty := true
-	pointer := 20
+	p.Typed = decodeOptions(p.Options)
+
+	// link layer may pad short frames, so payload ends at total length
+	p.Data = data[headerLength:min(int(p.Length), len(data))]
+
+	return nil
+}
 
-	for optionsNotEmpty {
+func (p *Packet) unmarshalOptions(data []byte) error {
+	pointer := 0
+
+	for pointer < len(data) {
 		opt := Option{}
 
-		if data[pointer]&31 == 0 || data[pointer]&31 == 1 {
-			opt.Unmarshal(data[pointer : pointer+1])
-			p.Options = append(p.Options, opt)
-			pointer++
+		if err := opt.Unmarshal(data[pointer:]); err != nil {
+			return err
+		}
 
-			if opt.Type.Number() == 0 {
-				optionsNotEmpty = false
-			}
-		} else {
-			length := data[pointer+1]
-			opt.Unmarshal(data[pointer : pointer+int(length)])
-			p.Options = append(p.Options, opt)
+		p.Options = append(p.Options, opt)
+		pointer += opt.size()
 
-			pointer += int(length)
+		if opt.Type.Number() == 0 { // End Of Option list
+			break
 		}
+	}
 
-		if pointer == int(p.VerIHL.IHL()*4) {
-			optionsNotEmpty = false
+	return nil
+}
+
+// VerifyChecksum reports whether header checksum is correct. For parsed packets
+// the original header bytes are checked while fields are not changed,
+// otherwise header is built from fields.
+func (p *Packet) VerifyChecksum() bool {
+	header := p.header
+
+	if !p.headerMatches() {
+		data, err := p.MarshalChecked()
+		if err != nil {
+			return false
 		}
+
+		header = data[:(data[0]&15)*4]
+		binary.BigEndian.PutUint16(header[10:12], p.Checksum)
 	}
+
+	return p.CalculateChecksum(header) == 0
+}
+
+// headerMatches reports whether header kept by Unmarshal still describes
+// fields of packet
+func (p *Packet) headerMatches() bool {
+	h := p.header
+	if len(h) < ipHeaderLength {
+		return false
+	}
+
+	if h[0] != p.VerIHL.Value || h[1] != p.TOS || h[8] != p.TTL || h[9] != p.Protocol ||
+		binary.BigEndian.Uint16(h[2:4]) != p.Length ||
+		binary.BigEndian.Uint16(h[4:6]) != p.ID ||
+		binary.BigEndian.Uint16(h[6:8]) != p.FlFrOff.Value ||
+		binary.BigEndian.Uint16(h[10:12]) != p.Checksum ||
+		IPAddr(h[12:16]) != p.Src || IPAddr(h[16:20]) != p.Dst {
+		return false
+	}
+
+	// options are compared parsed, as padding is not kept in fields
+	parsed := &Packet{}
+	if err := parsed.unmarshalOptions(h[ipHeaderLength:]); err != nil || len(parsed.Options) != len(p.Options) {
+		return false
+	}
+
+	for i, opt := range parsed.Options {
+		o := p.Options[i]
+		if o.Type != opt.Type || o.Length != opt.Length || !bytes.Equal(o.Value, opt.Value) {
+			return false
+		}
+	}
+
+	return true
 }
 
 func (p *Packet) CalculateChecksum(data []byte) uint16 {
@@ -258,3 +593,25 @@ func (p *Packet) CalculateChecksum(data []byte) uint16 {
 
 	return ^(uint16(sum))
 }
+
+// PseudoHeaderChecksum calculates checksum of upper layer segment (TCP, UDP)
+// covering IPv4 pseudo header from RFC793 and RFC768
+//
+//	+--------+--------+--------+--------+
+//	|          source address           |
+//	+--------+--------+--------+--------+
+//	|        destination address        |
+//	+--------+--------+--------+--------+
+//	|  zero  |protocol|  segment length |
+//	+--------+--------+--------+--------+
+func PseudoHeaderChecksum(src, dst IPAddr, protocol uint8, segment []byte) uint16 {
+	buf := make([]byte, 12+len(segment))
+
+	copy(buf[0:4], src[:])
+	copy(buf[4:8], dst[:])
+	buf[9] = protocol
+	binary.BigEndian.PutUint16(buf[10:12], uint16(len(segment)))
+	copy(buf[12:], segment)
+
+	return (&Packet{}).CalculateChecksum(buf)
+}
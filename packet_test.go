@@ -1,6 +1,9 @@
 package ipv4
 
 import (
+	"bytes"
+	"encoding/binary"
+	"errors"
 	"fmt"
 	"testing"
 )
@@ -116,6 +119,87 @@ func Test_FlagsFrOffset_GetFragmentOffset(t *testing.T) {
 	}
 }
 
+func Test_FlagsFrOffset_NamedFlags(t *testing.T) {
+	tests := []struct {
+		name     string
+		flfr     FlagsFrOffset
+		reserved bool
+		df       bool
+		mf       bool
+	}{
+		{
+			name: "No flags",
+			flfr: FlagsFrOffset{Value: 8191},
+		},
+		{
+			name: "Don't Fragment",
+			flfr: FlagsFrOffset{Value: 16384},
+			df:   true,
+		},
+		{
+			name: "More Fragments",
+			flfr: FlagsFrOffset{Value: 8192 + 5},
+			mf:   true,
+		},
+		{
+			name:     "All flags",
+			flfr:     FlagsFrOffset{Value: 65535},
+			reserved: true,
+			df:       true,
+			mf:       true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if test.flfr.Reserved() != test.reserved {
+				t.Errorf("Reserved not expected %v", test.flfr.Reserved())
+			}
+
+			if test.flfr.DontFragment() != test.df {
+				t.Errorf("DontFragment not expected %v", test.flfr.DontFragment())
+			}
+
+			if test.flfr.MoreFragments() != test.mf {
+				t.Errorf("MoreFragments not expected %v", test.flfr.MoreFragments())
+			}
+		})
+	}
+}
+
+func Test_FlagsFrOffset_Setters(t *testing.T) {
+	f := FlagsFrOffset{}
+
+	f.SetDontFragment(true)
+	f.SetMoreFragments(true)
+	f.SetReserved(true)
+	f.SetMoreFragments(false)
+
+	if f.Value != 0xC000 {
+		t.Errorf("Value not expected %#x", f.Value)
+	}
+
+	if err := f.SetFragmentOffset(1480); err != nil {
+		t.Fatal(err)
+	}
+
+	if f.FragmentOffset() != 185 || f.FragmentOffsetBytes() != 1480 || !f.DontFragment() {
+		t.Errorf("Value not expected %#x", f.Value)
+	}
+
+	if err := f.SetFragmentOffset(1481); err == nil {
+		t.Error("not aligned offset accepted")
+	}
+
+	if err := f.SetFragmentOffset(65536); err == nil {
+		t.Error("too big offset accepted")
+	}
+
+	if f.FragmentOffsetBytes() != 1480 {
+		t.Error("offset changed by failed setter")
+	}
+}
+
 func Test_Packet_Marshal(t *testing.T) {
 	ip1, _ := IPFromString("1.2.3.4")
 	ip2, _ := IPFromString("1.2.3.4")
@@ -293,3 +377,317 @@ func Test_Packet_Unmarshal_WithOptions(t *testing.T) {
 		t.Error("wrong length field")
 	}
 }
+
+func Test_Packet_Unmarshal_Errors(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    []byte
+		expected error
+	}{
+		{
+			name:     "Empty buffer",
+			input:    []byte{},
+			expected: ErrTruncated,
+		},
+		{
+			name:     "Short header",
+			input:    []byte{69, 0, 0, 21, 0, 0, 0, 0, 64, 6},
+			expected: ErrTruncated,
+		},
+		{
+			name:     "Wrong version",
+			input:    []byte{101, 0, 0, 21, 0, 0, 0, 0, 64, 6, 255, 255, 1, 2, 3, 4, 1, 2, 3, 4, 0},
+			expected: ErrBadVersion,
+		},
+		{
+			name:     "IHL less than minimum",
+			input:    []byte{68, 0, 0, 21, 0, 0, 0, 0, 64, 6, 255, 255, 1, 2, 3, 4, 1, 2, 3, 4, 0},
+			expected: ErrBadIHL,
+		},
+		{
+			name:     "IHL beyond buffer",
+			input:    []byte{79, 0, 0, 21, 0, 0, 0, 0, 64, 6, 255, 255, 1, 2, 3, 4, 1, 2, 3, 4, 0},
+			expected: ErrTruncated,
+		},
+		{
+			name:     "Total length less than header",
+			input:    []byte{69, 0, 0, 19, 0, 0, 0, 0, 64, 6, 255, 255, 1, 2, 3, 4, 1, 2, 3, 4, 0},
+			expected: ErrBadTotalLength,
+		},
+		{
+			name:     "Total length beyond buffer",
+			input:    []byte{69, 0, 0, 22, 0, 0, 0, 0, 64, 6, 255, 255, 1, 2, 3, 4, 1, 2, 3, 4, 0},
+			expected: ErrBadTotalLength,
+		},
+		{
+			name:     "Option without length",
+			input:    []byte{70, 0, 0, 24, 0, 0, 0, 0, 64, 6, 255, 255, 1, 2, 3, 4, 1, 2, 3, 4, 1, 1, 1, 134},
+			expected: ErrBadOption,
+		},
+		{
+			name:     "Option length beyond header",
+			input:    []byte{70, 0, 0, 24, 0, 0, 0, 0, 64, 6, 255, 255, 1, 2, 3, 4, 1, 2, 3, 4, 134, 8, 0, 0},
+			expected: ErrBadOption,
+		},
+		{
+			name:     "Option with zero length",
+			input:    []byte{70, 0, 0, 24, 0, 0, 0, 0, 64, 6, 255, 255, 1, 2, 3, 4, 1, 2, 3, 4, 134, 0, 0, 0},
+			expected: ErrBadOption,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p := &Packet{}
+			err := p.Unmarshal(test.input)
+
+			if !errors.Is(err, test.expected) {
+				t.Errorf("Error not expected %v", err)
+			}
+		})
+	}
+}
+
+func Test_Packet_Unmarshal_Padding(t *testing.T) {
+	data := []byte{69, 0, 0, 21, 0, 0, 0, 0, 64, 6, 255, 255, 1, 2, 3, 4, 1, 2, 3, 4, 7, 0, 0, 0}
+
+	p := &Packet{}
+	if err := p.Unmarshal(data); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(p.Data) != 1 || p.Data[0] != 7 {
+		t.Error("link layer padding not trimmed: ", p.Data)
+	}
+}
+
+func Test_Packet_VerifyChecksum(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    []byte
+		expected bool
+	}{
+		{
+			name:     "Correct checksum",
+			input:    []byte{69, 0, 0, 21, 0, 0, 64, 0, 64, 6, 50, 216, 1, 2, 3, 4, 1, 2, 3, 4, 0},
+			expected: true,
+		},
+		{
+			name:     "Wrong checksum",
+			input:    []byte{69, 0, 0, 21, 0, 0, 64, 0, 64, 6, 50, 217, 1, 2, 3, 4, 1, 2, 3, 4, 0},
+			expected: false,
+		},
+		{
+			name:     "Correct checksum with options",
+			input:    []byte{70, 0, 0, 25, 0, 0, 64, 0, 64, 6, 47, 211, 1, 2, 3, 4, 1, 2, 3, 4, 1, 1, 1, 0, 0},
+			expected: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p := &Packet{}
+			if err := p.Unmarshal(test.input); err != nil {
+				t.Fatal(err)
+			}
+
+			if p.VerifyChecksum() != test.expected {
+				t.Errorf("Checksum verification not expected %v", !test.expected)
+			}
+		})
+	}
+}
+
+func Test_Packet_VerifyChecksum_Built(t *testing.T) {
+	ip1, _ := IPFromString("1.2.3.4")
+	ip2, _ := IPFromString("4.3.2.1")
+
+	p := New(ip1, ip2, []byte{0})
+	p.Checksum = binary.BigEndian.Uint16(p.Marshal()[10:12])
+
+	if !p.VerifyChecksum() {
+		t.Error("checksum of marshaled packet is wrong")
+	}
+
+	p.TTL--
+
+	if p.VerifyChecksum() {
+		t.Error("checksum of changed packet is correct")
+	}
+}
+
+func Test_Packet_VerifyChecksum_Changed(t *testing.T) {
+	data := []byte{70, 0, 0, 25, 0, 0, 64, 0, 64, 6, 47, 211, 1, 2, 3, 4, 1, 2, 3, 4, 1, 1, 1, 0, 0}
+
+	p := &Packet{}
+	if err := p.Unmarshal(data); err != nil {
+		t.Fatal(err)
+	}
+
+	// received buffer is reused for the next frame
+	data[10]++
+
+	if !p.VerifyChecksum() {
+		t.Error("checksum depends on received buffer")
+	}
+
+	p.TTL--
+
+	if p.VerifyChecksum() {
+		t.Error("checksum of changed packet is correct")
+	}
+
+	p.TTL++
+	p.Options[0] = Option{Type: OptionType{0}} // End Of Option list
+
+	if p.VerifyChecksum() {
+		t.Error("checksum of packet with changed options is correct")
+	}
+}
+
+func Test_Packet_Marshal_OptionsLimit(t *testing.T) {
+	opt := Option{Type: OptionType{Value: 7}, Length: 12, Value: make([]byte, 10)}
+
+	p := New(IPAddr{}, IPAddr{}, []byte{1}).WithOptions(opt, opt, opt)
+
+	data, err := p.MarshalChecked()
+	if err != nil || data[0] != 0x4E || len(data) != 20+36+1 {
+		t.Errorf("Marshaled not expected %v %v", data, err)
+	}
+
+	// the fourth option exceeds 40 bytes
+	p.WithOptions(opt)
+
+	if _, err := p.MarshalChecked(); !errors.Is(err, ErrOptionsTooLong) {
+		t.Errorf("Error not expected %v", err)
+	}
+
+	if data := p.Marshal(); data != nil {
+		t.Errorf("Marshaled not expected %v", data)
+	}
+
+	if _, err := p.Fragment(1500); !errors.Is(err, ErrOptionsTooLong) {
+		t.Errorf("Error not expected %v", err)
+	}
+
+	q := New(IPAddr{}, IPAddr{}, nil).WithOptions(opt, opt, opt)
+	if err := q.setOption(Option{Type: OptionType{Value: 0x80 | OptionCIPSO}, Length: 6, Value: make([]byte, 4)}); !errors.Is(err, ErrOptionsTooLong) || len(q.Options) != 3 {
+		t.Errorf("Options not expected %v %v", q.Options, err)
+	}
+}
+
+func Test_Packet_Marshal_TotalLengthLimit(t *testing.T) {
+	p := New(IPAddr{}, IPAddr{}, make([]byte, 65535-20))
+
+	if data, err := p.MarshalChecked(); err != nil || len(data) != 65535 {
+		t.Errorf("Marshaled not expected %d %v", len(data), err)
+	}
+
+	p.Data = append(p.Data, 0)
+
+	if _, err := p.MarshalChecked(); !errors.Is(err, ErrBadTotalLength) {
+		t.Errorf("Error not expected %v", err)
+	}
+
+	if data := p.Marshal(); data != nil {
+		t.Errorf("Marshaled not expected %d bytes", len(data))
+	}
+}
+
+func Test_Packet_Marshal_Idempotent(t *testing.T) {
+	ip1, _ := IPFromString("1.2.3.4")
+	ip2, _ := IPFromString("4.3.2.1")
+
+	p := New(ip1, ip2, []byte{0, 1, 2}).WithOptions(Option{Type: OptionType{134}, Length: 3, Value: []byte{14}})
+
+	first := p.Marshal()
+	second := p.Marshal()
+
+	if !bytes.Equal(first, second) {
+		t.Errorf("marshaled twice differently: %v - %v", first, second)
+	}
+
+	if p.VerIHL.IHL() != 5 || p.Length != 23 {
+		t.Error("packet mutated by marshaling")
+	}
+
+	if first[0] != 70 || binary.BigEndian.Uint16(first[2:4]) != uint16(len(first)) {
+		t.Error("wrong IHL or total length: ", first)
+	}
+}
+
+func FuzzPacket_Unmarshal(f *testing.F) {
+	f.Add([]byte{69, 0, 0, 21, 0, 0, 0, 0, 64, 6, 255, 255, 1, 2, 3, 4, 1, 2, 3, 4, 0})
+	f.Add([]byte{71, 0, 0, 33, 0, 0, 0, 0, 64, 6, 255, 255, 1, 2, 3, 4, 1, 2, 3, 4, 134, 3, 14, 166, 3, 15, 1, 0, 0, 1, 2, 3, 4})
+	f.Add([]byte{70, 0, 0, 24, 0, 0, 0, 0, 64, 6, 255, 255, 1, 2, 3, 4, 1, 2, 3, 4, 134, 0, 0, 0})
+	f.Add([]byte{70, 0, 0, 24, 0, 0, 0, 0, 64, 6, 255, 255, 1, 2, 3, 4, 1, 2, 3, 4, 131, 3, 4, 0})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		p := &Packet{}
+		if err := p.Unmarshal(data); err != nil {
+			return
+		}
+
+		// successfully parsed packet has to survive round trip
+		q := &Packet{}
+		if err := q.Unmarshal(p.Marshal()); err != nil {
+			t.Errorf("failed to unmarshal marshaled packet: %v", err)
+		}
+
+		// source route of parsed packet has to be originated or rejected
+		if out, err := p.originateSourceRoute(); err == nil && out.Marshal() == nil {
+			t.Errorf("failed to marshal originated packet %v", out)
+		}
+	})
+}
+
+func Test_Packet_WithProtocol(t *testing.T) {
+	p := New(IPAddr{}, IPAddr{}, nil)
+
+	if p.Protocol != ProtocolTCP {
+		t.Errorf("Default protocol not expected %d", p.Protocol)
+	}
+
+	data := p.WithProtocol(ProtocolUDP).Marshal()
+
+	if data[9] != ProtocolUDP {
+		t.Errorf("Marshaled protocol not expected %d", data[9])
+	}
+}
+
+func Test_Packet_UnmarshalHeader(t *testing.T) {
+	data := []byte{69, 0, 0, 60, 0, 0, 0, 0, 64, 17, 255, 255, 1, 2, 3, 4, 1, 2, 3, 4, 0, 53, 0, 53, 0, 40, 0, 0}
+
+	p := &Packet{}
+
+	if err := p.Unmarshal(data); !errors.Is(err, ErrBadTotalLength) {
+		t.Errorf("Error not expected %v", err)
+	}
+
+	if err := p.UnmarshalHeader(data); err != nil {
+		t.Fatal(err)
+	}
+
+	if p.Length != 60 || p.Protocol != ProtocolUDP || len(p.Data) != 8 {
+		t.Errorf("Header not expected %v", p)
+	}
+}
+
+func Test_PseudoHeaderChecksum(t *testing.T) {
+	src, _ := IPFromString("192.168.0.1")
+	dst, _ := IPFromString("192.168.0.2")
+
+	// UDP datagram from port 1000 to port 2000 with 2 bytes of data
+	segment := []byte{0x03, 0xE8, 0x07, 0xD0, 0x00, 0x0A, 0x00, 0x00, 0x41, 0x42}
+
+	sum := PseudoHeaderChecksum(src, dst, ProtocolUDP, segment)
+	binary.BigEndian.PutUint16(segment[6:8], sum)
+
+	if PseudoHeaderChecksum(src, dst, ProtocolUDP, segment) != 0 {
+		t.Errorf("Checksum not verified %#x", sum)
+	}
+
+	if sum != 0x318C {
+		t.Errorf("Checksum not expected %#x", sum)
+	}
+}
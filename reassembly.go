@@ -0,0 +1,304 @@
+package ipv4
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	defaultReassemblyTimeout = 30 * time.Second
+	defaultReassemblyMemory  = 4 << 20
+
+	// memory charged for every incomplete datagram besides payload: header
+	// of the first fragment, hole and fragment lists, bookkeeping
+	datagramOverhead = 256
+
+	maxDatagramLength = 0xFFFF
+	infinity          = maxDatagramLength + 1 // end of hole after unknown last fragment
+)
+
+var (
+	// ErrFragmentOverlap is returned when fragment overlaps data already
+	// received, the whole datagram is dropped to defend from teardrop attacks
+	ErrFragmentOverlap = errors.New("fragment overlaps received data")
+	// ErrBadFragment is returned for fragment which can not be part of datagram
+	ErrBadFragment = errors.New("malformed fragment")
+	// ErrReassemblyMemory is returned when fragment does not fit memory budget
+	ErrReassemblyMemory = errors.New("reassembly memory budget exceeded")
+)
+
+// ReassemblyConfig describes limits of fragment reassembly, zero fields are
+// replaced by defaults: 30 seconds and 4 MiB
+type ReassemblyConfig struct {
+	Timeout   time.Duration // time to wait for all fragments since the first one
+	MaxMemory int           // bytes buffered for all datagrams, payload and per datagram overhead
+}
+
+// fragmentKey identifies fragments of one datagram, RFC791
+type fragmentKey struct {
+	src      IPAddr
+	dst      IPAddr
+	protocol uint8
+	id       uint16
+}
+
+// hole is a range of missing octets, RFC815
+type hole struct {
+	first int
+	last  int
+}
+
+type fragmentRange struct {
+	first int
+	last  int
+	data  []byte
+}
+
+type datagram struct {
+	first     *Packet // fragment with zero offset, it gives header of datagram
+	holes     []hole
+	fragments []fragmentRange
+	end       int // octet after the last received one
+	size      int // bytes of buffered payload and overhead
+	expires   time.Time
+}
+
+// Reassembler collects fragments into datagrams using hole descriptors
+// from RFC815. It is safe for concurrent use.
+type Reassembler struct {
+	mu sync.Mutex
+
+	timeout   time.Duration
+	maxMemory int
+	memory    int
+
+	datagrams map[fragmentKey]*datagram
+	order     []fragmentKey // keys by arrival of the first fragment, for eviction
+}
+
+// NewReassembler creates reassembler with limits from cfg
+func NewReassembler(cfg ReassemblyConfig) *Reassembler {
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = defaultReassemblyTimeout
+	}
+
+	if cfg.MaxMemory <= 0 {
+		cfg.MaxMemory = defaultReassemblyMemory
+	}
+
+	return &Reassembler{
+		timeout:   cfg.Timeout,
+		maxMemory: cfg.MaxMemory,
+		datagrams: make(map[fragmentKey]*datagram),
+	}
+}
+
+// Add handles received packet. Not fragmented packet is returned as is,
+// fragment is kept until the whole datagram is collected, then datagram is
+// returned. On error all fragments of the datagram are dropped.
+func (r *Reassembler) Add(p *Packet, now time.Time) (*Packet, error) {
+	if !p.IsFragment() {
+		return p, nil
+	}
+
+	more := p.FlFrOff.MoreFragments()
+	offset := p.FlFrOff.FragmentOffsetBytes()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.expire(now)
+
+	key := fragmentKey{src: p.Src, dst: p.Dst, protocol: p.Protocol, id: p.ID}
+
+	frag := fragmentRange{first: offset, last: offset + len(p.Data) - 1, data: append([]byte(nil), p.Data...)}
+
+	if err := validateFragment(frag, more); err != nil {
+		r.drop(key)
+		return nil, err
+	}
+
+	if charge := len(p.Data) + datagramOverhead; charge > r.maxMemory {
+		r.drop(key)
+		return nil, fmt.Errorf("%w: fragment of %d bytes", ErrReassemblyMemory, len(p.Data))
+	}
+
+	for r.memory+r.charge(key, len(p.Data)) > r.maxMemory && len(r.order) > 0 {
+		r.drop(r.order[0])
+	}
+
+	d, ok := r.datagrams[key]
+	if !ok {
+		d = &datagram{
+			holes:   []hole{{first: 0, last: infinity}},
+			size:    datagramOverhead,
+			expires: now.Add(r.timeout),
+		}
+
+		r.datagrams[key] = d
+		r.order = append(r.order, key)
+		r.memory += datagramOverhead
+	}
+
+	duplicate, err := d.add(frag, more)
+	if err != nil {
+		r.drop(key)
+		return nil, err
+	}
+
+	if duplicate {
+		return nil, nil
+	}
+
+	if offset == 0 {
+		d.first = p
+	}
+
+	d.size += len(p.Data)
+	r.memory += len(p.Data)
+
+	if len(d.holes) != 0 {
+		return nil, nil
+	}
+
+	r.drop(key)
+
+	if int(d.first.VerIHL.IHL())*4+d.end > maxDatagramLength {
+		return nil, fmt.Errorf("%w: datagram of %d bytes", ErrBadFragment, int(d.first.VerIHL.IHL())*4+d.end)
+	}
+
+	return d.assemble(), nil
+}
+
+// Len returns count of incomplete datagrams
+func (r *Reassembler) Len() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return len(r.datagrams)
+}
+
+// Expire drops incomplete datagrams whose timeout is over and returns their count
+func (r *Reassembler) Expire(now time.Time) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.expire(now)
+}
+
+func (r *Reassembler) expire(now time.Time) int {
+	count := 0
+
+	for len(r.order) > 0 {
+		d := r.datagrams[r.order[0]]
+		if now.Before(d.expires) {
+			break
+		}
+
+		r.drop(r.order[0])
+		count++
+	}
+
+	return count
+}
+
+// charge returns memory taken by fragment with size bytes of payload, the
+// first fragment of datagram is charged for overhead as well
+func (r *Reassembler) charge(key fragmentKey, size int) int {
+	if _, ok := r.datagrams[key]; ok {
+		return size
+	}
+
+	return size + datagramOverhead
+}
+
+func (r *Reassembler) drop(key fragmentKey) {
+	d, ok := r.datagrams[key]
+	if !ok {
+		return
+	}
+
+	r.memory -= d.size
+	delete(r.datagrams, key)
+
+	for i, k := range r.order {
+		if k == key {
+			r.order = append(r.order[:i], r.order[i+1:]...)
+			break
+		}
+	}
+}
+
+func validateFragment(frag fragmentRange, more bool) error {
+	if frag.last < frag.first {
+		return fmt.Errorf("%w: empty fragment at %d", ErrBadFragment, frag.first)
+	}
+
+	if more && len(frag.data)%fragmentUnit != 0 {
+		return fmt.Errorf("%w: %d bytes is not multiple of %d", ErrBadFragment, len(frag.data), fragmentUnit)
+	}
+
+	if ipHeaderLength+frag.last >= maxDatagramLength {
+		return fmt.Errorf("%w: fragment ends at %d beyond maximum datagram length", ErrBadFragment, frag.last)
+	}
+
+	return nil
+}
+
+// add fills holes covered by fragment as described in RFC815. Fragment must
+// lie inside a single hole, only exact duplicates of received fragments
+// are tolerated.
+func (d *datagram) add(frag fragmentRange, more bool) (bool, error) {
+	for _, f := range d.fragments {
+		if f.first == frag.first && f.last == frag.last {
+			return true, nil
+		}
+	}
+
+	if !more && d.end > frag.last+1 {
+		return false, fmt.Errorf("%w: last fragment ends at %d before received data", ErrBadFragment, frag.last)
+	}
+
+	for i, h := range d.holes {
+		if frag.first < h.first || frag.last > h.last {
+			continue
+		}
+
+		holes := append([]hole(nil), d.holes[:i]...)
+
+		if frag.first > h.first {
+			holes = append(holes, hole{first: h.first, last: frag.first - 1})
+		}
+
+		if frag.last < h.last && more {
+			holes = append(holes, hole{first: frag.last + 1, last: h.last})
+		}
+
+		d.holes = append(holes, d.holes[i+1:]...)
+		d.fragments = append(d.fragments, frag)
+		d.end = max(d.end, frag.last+1)
+
+		return false, nil
+	}
+
+	return false, fmt.Errorf("%w: octets %d-%d", ErrFragmentOverlap, frag.first, frag.last)
+}
+
+func (d *datagram) assemble() *Packet {
+	data := make([]byte, d.end)
+
+	for _, f := range d.fragments {
+		copy(data[f.first:], f.data)
+	}
+
+	p := *d.first
+	p.Data = data
+	p.FlFrOff.SetMoreFragments(false)
+	p.FlFrOff.SetFragmentOffset(0)
+	p.Length = uint16(int(p.VerIHL.IHL())*4 + len(data))
+	p.header = nil
+
+	return &p
+}
@@ -0,0 +1,268 @@
+package ipv4
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+)
+
+func fragmentsOf(t *testing.T, size, mtu int) (*Packet, []*Packet) {
+	src, _ := IPFromString("1.2.3.4")
+	dst, _ := IPFromString("4.3.2.1")
+
+	data := make([]byte, size)
+	for i := range data {
+		data[i] = byte(i)
+	}
+
+	p := New(src, dst, data)
+	p.ID = 42
+
+	fragments, err := p.Fragment(mtu)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return p, fragments
+}
+
+func Test_Reassembler_Add(t *testing.T) {
+	tests := []struct {
+		name  string
+		order []int
+	}{
+		{
+			name:  "In order",
+			order: []int{0, 1, 2, 3},
+		},
+		{
+			name:  "Reversed",
+			order: []int{3, 2, 1, 0},
+		},
+		{
+			name:  "Shuffled with duplicate",
+			order: []int{2, 0, 2, 3, 1},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p, fragments := fragmentsOf(t, 100, 52)
+			r := NewReassembler(ReassemblyConfig{})
+			now := time.Now()
+
+			var full *Packet
+
+			for i, idx := range test.order {
+				got, err := r.Add(fragments[idx], now)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if got != nil && i != len(test.order)-1 {
+					t.Fatal("datagram completed too early")
+				}
+
+				full = got
+			}
+
+			if full == nil {
+				t.Fatal("datagram not completed")
+			}
+
+			if !bytes.Equal(full.Data, p.Data) || full.FlFrOff.Value != 0 || int(full.Length) != len(full.Marshal()) {
+				t.Errorf("Datagram not expected %v", full)
+			}
+
+			if r.Len() != 0 {
+				t.Error("datagram not released")
+			}
+		})
+	}
+}
+
+func Test_Reassembler_Add_NotFragmented(t *testing.T) {
+	p := New(IPAddr{}, IPAddr{}, []byte{1})
+	r := NewReassembler(ReassemblyConfig{})
+
+	got, err := r.Add(p, time.Now())
+	if err != nil || got != p {
+		t.Errorf("Packet not expected %v %v", got, err)
+	}
+}
+
+func Test_Reassembler_Add_Overlap(t *testing.T) {
+	_, fragments := fragmentsOf(t, 100, 52)
+	r := NewReassembler(ReassemblyConfig{})
+	now := time.Now()
+
+	if _, err := r.Add(fragments[0], now); err != nil {
+		t.Fatal(err)
+	}
+
+	// teardrop: the second fragment starts inside the first one
+	overlap := *fragments[1]
+	overlap.FlFrOff = FlagsFrOffset{Value: flagMoreFragments | 2}
+
+	if _, err := r.Add(&overlap, now); !errors.Is(err, ErrFragmentOverlap) {
+		t.Errorf("Error not expected %v", err)
+	}
+
+	if r.Len() != 0 {
+		t.Error("overlapped datagram not dropped")
+	}
+}
+
+func Test_Reassembler_Add_Errors(t *testing.T) {
+	tests := []struct {
+		name     string
+		flags    uint16
+		size     int
+		expected error
+	}{
+		{
+			name:     "Not aligned middle fragment",
+			flags:    flagMoreFragments | 1,
+			size:     7,
+			expected: ErrBadFragment,
+		},
+		{
+			name:     "Beyond maximum datagram",
+			flags:    0x1FFF,
+			size:     16,
+			expected: ErrBadFragment,
+		},
+		{
+			name:     "Empty fragment",
+			flags:    1,
+			size:     0,
+			expected: ErrBadFragment,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p := New(IPAddr{}, IPAddr{}, make([]byte, test.size))
+			p.FlFrOff = FlagsFrOffset{Value: test.flags}
+
+			r := NewReassembler(ReassemblyConfig{})
+
+			if _, err := r.Add(p, time.Now()); !errors.Is(err, test.expected) {
+				t.Errorf("Error not expected %v", err)
+			}
+		})
+	}
+}
+
+func Test_Reassembler_Add_LastBeforeReceived(t *testing.T) {
+	_, fragments := fragmentsOf(t, 100, 52)
+	r := NewReassembler(ReassemblyConfig{})
+	now := time.Now()
+
+	if _, err := r.Add(fragments[2], now); err != nil {
+		t.Fatal(err)
+	}
+
+	last := *fragments[1]
+	last.FlFrOff = FlagsFrOffset{Value: fragments[1].FlFrOff.FragmentOffset()}
+
+	if _, err := r.Add(&last, now); !errors.Is(err, ErrBadFragment) {
+		t.Errorf("Error not expected %v", err)
+	}
+}
+
+func Test_Reassembler_Expire(t *testing.T) {
+	_, fragments := fragmentsOf(t, 100, 52)
+	r := NewReassembler(ReassemblyConfig{Timeout: time.Second})
+	now := time.Now()
+
+	if _, err := r.Add(fragments[0], now); err != nil {
+		t.Fatal(err)
+	}
+
+	if r.Expire(now.Add(time.Second/2)) != 0 {
+		t.Error("datagram expired too early")
+	}
+
+	if r.Expire(now.Add(time.Second)) != 1 || r.Len() != 0 {
+		t.Error("datagram not expired")
+	}
+}
+
+func Test_Reassembler_MaxMemory(t *testing.T) {
+	_, fragments := fragmentsOf(t, 100, 52)
+	r := NewReassembler(ReassemblyConfig{MaxMemory: 40 + datagramOverhead})
+	now := time.Now()
+
+	if _, err := r.Add(fragments[0], now); err != nil {
+		t.Fatal(err)
+	}
+
+	other := *fragments[1]
+	other.ID++
+
+	// the oldest datagram is evicted to fit the budget
+	if _, err := r.Add(&other, now); err != nil {
+		t.Fatal(err)
+	}
+
+	if r.Len() != 1 {
+		t.Errorf("Count of datagrams not expected %d", r.Len())
+	}
+
+	big := New(IPAddr{}, IPAddr{}, make([]byte, 48))
+	big.FlFrOff = FlagsFrOffset{Value: flagMoreFragments}
+
+	if _, err := r.Add(big, now); !errors.Is(err, ErrReassemblyMemory) {
+		t.Errorf("Error not expected %v", err)
+	}
+}
+
+func Test_Reassembler_MaxMemory_Overhead(t *testing.T) {
+	r := NewReassembler(ReassemblyConfig{MaxMemory: 2 * (8 + datagramOverhead)})
+	now := time.Now()
+
+	// tiny fragments of many datagrams are limited by overhead
+	for id := uint16(0); id < 3; id++ {
+		p := New(IPAddr{}, IPAddr{}, make([]byte, 8))
+		p.ID = id
+		p.FlFrOff = FlagsFrOffset{Value: flagMoreFragments}
+
+		if _, err := r.Add(p, now); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if r.Len() != 2 {
+		t.Errorf("Count of datagrams not expected %d", r.Len())
+	}
+}
+
+func Test_IpSocket_ReadPacket_Reassembly(t *testing.T) {
+	is, _ := arpSocket(t, "eth0", "192.168.0.1", 24)
+	is.EnableReassembly(ReassemblyConfig{})
+
+	full, fragments := fragmentsOf(t, 1000, 300)
+
+	// fragments arrive out of order
+	fragments[0], fragments[len(fragments)-1] = fragments[len(fragments)-1], fragments[0]
+	is.recv = frames(ipFrames(fragments...)...)
+
+	p, err := is.ReadPacket()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if p.IsFragment() || !bytes.Equal(p.Data, full.Data) {
+		t.Errorf("Read not expected %v", p)
+	}
+
+	// without reassembly fragments are read as they are
+	is.DisableReassembly()
+	is.recv = frames(ipFrames(fragments...)...)
+
+	if p, err := is.ReadPacket(); err != nil || !p.IsFragment() {
+		t.Errorf("Read not expected %v %v", p, err)
+	}
+}
@@ -0,0 +1,49 @@
+package ipv4
+
+import "errors"
+
+// ErrTTLExpired is returned when datagram with zero TTL is about to be sent
+var ErrTTLExpired = errors.New("ttl expired")
+
+// ErrorReason describes why datagram was dropped by socket
+type ErrorReason int
+
+const (
+	// ReasonTTLExpired is reported for datagram with zero TTL
+	ReasonTTLExpired ErrorReason = iota
+	// ReasonProtocolUnreachable is reported for datagram addressed to local
+	// interface whose protocol is not handled by Dispatcher
+	ReasonProtocolUnreachable
+	// ReasonFragmentationNeeded is reported for datagram exceeding MTU but
+	// having Don't Fragment flag
+	ReasonFragmentationNeeded
+	// ReasonNetUnreachable is reported for datagram whose destination has no
+	// route
+	ReasonNetUnreachable
+	// ReasonSourceRouteFailed is reported for datagram whose strict source
+	// route can't be followed
+	ReasonSourceRouteFailed
+)
+
+func (r ErrorReason) String() string {
+	switch r {
+	case ReasonTTLExpired:
+		return "ttl expired"
+	case ReasonProtocolUnreachable:
+		return "protocol unreachable"
+	case ReasonFragmentationNeeded:
+		return "fragmentation needed"
+	case ReasonNetUnreachable:
+		return "net unreachable"
+	case ReasonSourceRouteFailed:
+		return "source route failed"
+	}
+
+	return "unknown reason"
+}
+
+// ErrorReporter notifies source of dropped datagram, e.g. with ICMP error
+// message. Reporter is called synchronously, mtu is MTU of egress interface.
+type ErrorReporter interface {
+	ReportError(reason ErrorReason, p *Packet, mtu int) error
+}
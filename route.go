@@ -0,0 +1,498 @@
+package ipv4
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const procRouteFile = "/proc/net/route"
+
+// route flags of /proc/net/route
+const (
+	rtfUp      = 0x0001
+	rtfGateway = 0x0002
+)
+
+var (
+	// ErrNoRoute is returned when routing table has no route to destination
+	ErrNoRoute = errors.New("no route to destination")
+	// ErrRouteInterface is returned when all routes of destination go
+	// through interfaces other than socket one
+	ErrRouteInterface = errors.New("route goes through other interface")
+	// ErrBadPrefix is returned for prefix length over 32 or non-contiguous mask
+	ErrBadPrefix = errors.New("wrong prefix")
+)
+
+// Route directs datagrams to destinations of prefix
+type Route struct {
+	Prefix  IPAddr
+	Bits    int    // length of prefix
+	NextHop IPAddr // gateway, zero for destinations on link
+	Iface   string // egress interface
+	Metric  int
+}
+
+// Contains reports whether dst belongs to prefix of route
+func (r Route) Contains(dst IPAddr) bool {
+	return maskKey(ipKey(dst), r.Bits) == ipKey(r.Prefix)
+}
+
+// Gateway returns address which datagram to dst is sent to: next hop or dst
+// itself for route on link
+func (r Route) Gateway(dst IPAddr) IPAddr {
+	if r.NextHop == (IPAddr{}) {
+		return dst
+	}
+
+	return r.NextHop
+}
+
+func (r Route) String() string {
+	s := fmt.Sprintf("%s/%d", r.Prefix.String(), r.Bits)
+
+	if r.NextHop != (IPAddr{}) {
+		s += " via " + r.NextHop.String()
+	}
+
+	if r.Iface != "" {
+		s += " dev " + r.Iface
+	}
+
+	return s + " metric " + strconv.Itoa(r.Metric)
+}
+
+// same reports whether routes differ by metric only
+func (r Route) same(o Route) bool {
+	return r.Prefix == o.Prefix && r.Bits == o.Bits && r.NextHop == o.NextHop && r.Iface == o.Iface
+}
+
+// routeNode is node of path compressed binary trie (Patricia trie), node
+// without routes only branches lookup
+type routeNode struct {
+	key    uint32
+	bits   int
+	routes []Route // sorted by metric
+	child  [2]*routeNode
+}
+
+// RouteTable keeps routes and finds the longest prefix matching destination,
+// routes of the same prefix are preferred by the lowest metric. It is safe for
+// concurrent use.
+type RouteTable struct {
+	mu   sync.RWMutex
+	root *routeNode
+	size int
+}
+
+// NewRouteTable creates empty routing table
+func NewRouteTable() *RouteTable {
+	return &RouteTable{}
+}
+
+// LoadRouteTable reads routing table of kernel from /proc/net/route
+func LoadRouteTable() (*RouteTable, error) {
+	f, err := os.Open(procRouteFile)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return ParseRouteTable(f)
+}
+
+// ParseRouteTable reads routing table in format of /proc/net/route, routes
+// which are not up are skipped
+func ParseRouteTable(r io.Reader) (*RouteTable, error) {
+	t := NewRouteTable()
+	scanner := bufio.NewScanner(r)
+
+	for line := 0; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if line == 0 || len(fields) == 0 { // header
+			continue
+		}
+
+		if len(fields) < 8 {
+			return nil, fmt.Errorf("route line %d: got %d fields", line, len(fields))
+		}
+
+		var values [5]uint32
+
+		// Destination, Gateway, Flags, Metric, Mask
+		for i, field := range []string{fields[1], fields[2], fields[3], fields[6], fields[7]} {
+			base := 16
+			if i == 3 {
+				base = 10
+			}
+
+			v, err := strconv.ParseUint(field, base, 32)
+			if err != nil {
+				return nil, fmt.Errorf("route line %d: %w", line, err)
+			}
+
+			values[i] = uint32(v)
+		}
+
+		if values[2]&rtfUp == 0 {
+			continue
+		}
+
+		// addresses are written in host byte order
+		mask := procAddr(values[4])
+
+		bits, size := net.IPMask(mask[:]).Size()
+		if size == 0 {
+			return nil, fmt.Errorf("route line %d: %w: mask %v", line, ErrBadPrefix, mask.String())
+		}
+
+		route := Route{
+			Prefix: procAddr(values[0]),
+			Bits:   bits,
+			Iface:  fields[0],
+			Metric: int(values[3]),
+		}
+
+		if values[2]&rtfGateway != 0 {
+			route.NextHop = procAddr(values[1])
+		}
+
+		if err := t.Add(route); err != nil {
+			return nil, fmt.Errorf("route line %d: %w", line, err)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+// Add inserts route, host bits of prefix are cleared. Route differing from
+// existing one by metric only replaces it.
+func (t *RouteTable) Add(r Route) error {
+	if r.Bits < 0 || r.Bits > 32 {
+		return fmt.Errorf("%w: length %d", ErrBadPrefix, r.Bits)
+	}
+
+	key := maskKey(ipKey(r.Prefix), r.Bits)
+	r.Prefix = keyIP(key)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	np := &t.root
+
+	for {
+		n := *np
+		if n == nil {
+			*np = &routeNode{key: key, bits: r.Bits}
+			t.size += (*np).add(r)
+
+			return nil
+		}
+
+		common := commonBits(n.key, key, min(n.bits, r.Bits))
+
+		switch {
+		case common == n.bits && common == r.Bits:
+			t.size += n.add(r)
+			return nil
+		case common == n.bits: // n is ancestor of new prefix
+			np = &n.child[keyBit(key, n.bits)]
+			continue
+		}
+
+		leaf := &routeNode{key: key, bits: r.Bits}
+		t.size += leaf.add(r)
+
+		if common == r.Bits { // new prefix is ancestor of n
+			leaf.child[keyBit(n.key, r.Bits)] = n
+			*np = leaf
+
+			return nil
+		}
+
+		branch := &routeNode{key: maskKey(key, common), bits: common}
+		branch.child[keyBit(key, common)] = leaf
+		branch.child[keyBit(n.key, common)] = n
+		*np = branch
+
+		return nil
+	}
+}
+
+// Delete removes route with the same prefix, next hop and interface, metric
+// is ignored. It reports whether route was found.
+func (t *RouteTable) Delete(r Route) bool {
+	if r.Bits < 0 || r.Bits > 32 {
+		return false
+	}
+
+	key := maskKey(ipKey(r.Prefix), r.Bits)
+	r.Prefix = keyIP(key)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var path []**routeNode
+
+	np := &t.root
+
+	for *np != nil && (*np).bits < r.Bits && maskKey(key, (*np).bits) == (*np).key {
+		path = append(path, np)
+		np = &(*np).child[keyBit(key, (*np).bits)]
+	}
+
+	n := *np
+	if n == nil || n.bits != r.Bits || n.key != key {
+		return false
+	}
+
+	i := 0
+	for i < len(n.routes) && !n.routes[i].same(r) {
+		i++
+	}
+
+	if i == len(n.routes) {
+		return false
+	}
+
+	n.routes = append(n.routes[:i], n.routes[i+1:]...)
+	t.size--
+
+	// remove nodes which do not branch anymore
+	for path = append(path, np); len(path) > 0; path = path[:len(path)-1] {
+		np := path[len(path)-1]
+		n := *np
+
+		if len(n.routes) != 0 {
+			break
+		}
+
+		switch {
+		case n.child[0] != nil && n.child[1] != nil:
+			return true
+		case n.child[0] != nil:
+			*np = n.child[0]
+		default:
+			*np = n.child[1]
+		}
+	}
+
+	return true
+}
+
+// Lookup returns route of the longest prefix containing dst
+func (t *RouteTable) Lookup(dst IPAddr) (Route, bool) {
+	routes := t.LookupAll(dst)
+	if len(routes) == 0 {
+		return Route{}, false
+	}
+
+	return routes[0], true
+}
+
+// LookupAll returns all routes of the longest prefix containing dst ordered
+// by metric
+func (t *RouteTable) LookupAll(dst IPAddr) []Route {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	nodes := t.matching(dst)
+	if len(nodes) == 0 {
+		return nil
+	}
+
+	return append([]Route{}, nodes[0].routes...)
+}
+
+// LookupIface returns route of the longest prefix containing dst among
+// routes through interface iface, routes without interface go through any
+// one. Shorter prefixes are tried when all routes of longer one go through
+// other interfaces.
+func (t *RouteTable) LookupIface(dst IPAddr, iface string) (Route, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	for _, n := range t.matching(dst) {
+		for _, r := range n.routes {
+			if r.Iface == "" || r.Iface == iface {
+				return r, true
+			}
+		}
+	}
+
+	return Route{}, false
+}
+
+// matching returns nodes with routes of prefixes containing dst, the longest
+// prefix goes first
+func (t *RouteTable) matching(dst IPAddr) []*routeNode {
+	key := ipKey(dst)
+
+	var nodes []*routeNode
+
+	for n := t.root; n != nil && maskKey(key, n.bits) == n.key; {
+		if len(n.routes) != 0 {
+			nodes = append([]*routeNode{n}, nodes...)
+		}
+
+		if n.bits == 32 {
+			break
+		}
+
+		n = n.child[keyBit(key, n.bits)]
+	}
+
+	return nodes
+}
+
+// Routes returns all routes ordered by prefix
+func (t *RouteTable) Routes() []Route {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	routes := make([]Route, 0, t.size)
+
+	var walk func(n *routeNode)
+	walk = func(n *routeNode) {
+		if n == nil {
+			return
+		}
+
+		routes = append(routes, n.routes...)
+		walk(n.child[0])
+		walk(n.child[1])
+	}
+
+	walk(t.root)
+
+	return routes
+}
+
+// Len returns count of routes
+func (t *RouteTable) Len() int {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	return t.size
+}
+
+// SetRouteTable makes WritePacket choose next hop by routes of t, it requires
+// ARP to send datagrams to hardware address of the next hop (see EnableARP).
+// Socket honours only routes through its own interface: the longest prefix
+// with route through interface of socket is chosen, among its routes the one
+// with the lowest metric. Nil table restores sending off-link datagrams to
+// default gateway of interface.
+func (is *IpSocket) SetRouteTable(t *RouteTable) error {
+	if t != nil && is.neighbors == nil {
+		return ErrARPDisabled
+	}
+
+	is.routes = t
+
+	return nil
+}
+
+// GetRouteTable returns routing table set by SetRouteTable
+func (is *IpSocket) GetRouteTable() *RouteTable {
+	return is.routes
+}
+
+// route returns address which datagram to dst is sent to
+func (is *IpSocket) route(dst IPAddr) (IPAddr, error) {
+	if dst == broadcastIP {
+		return dst, nil
+	}
+
+	table := is.routes
+	if table == nil {
+		return is.nextHop(dst), nil
+	}
+
+	if r, ok := table.LookupIface(dst, is.ifName); ok {
+		return r.Gateway(dst), nil
+	}
+
+	if r, ok := table.Lookup(dst); ok {
+		return IPAddr{}, fmt.Errorf("%w: %v", ErrRouteInterface, r)
+	}
+
+	return IPAddr{}, fmt.Errorf("%w: %v", ErrNoRoute, dst.String())
+}
+
+// add inserts route keeping order by metric, it returns count of added routes
+func (n *routeNode) add(r Route) int {
+	added := 1
+
+	for i := range n.routes {
+		if n.routes[i].same(r) {
+			n.routes = append(n.routes[:i], n.routes[i+1:]...)
+			added = 0
+
+			break
+		}
+	}
+
+	i := 0
+	for i < len(n.routes) && n.routes[i].Metric <= r.Metric {
+		i++
+	}
+
+	n.routes = append(n.routes, Route{})
+	copy(n.routes[i+1:], n.routes[i:])
+	n.routes[i] = r
+
+	return added
+}
+
+func ipKey(ip IPAddr) uint32 {
+	return binary.BigEndian.Uint32(ip[:])
+}
+
+func keyIP(key uint32) IPAddr {
+	var ip IPAddr
+	binary.BigEndian.PutUint32(ip[:], key)
+
+	return ip
+}
+
+func procAddr(v uint32) IPAddr {
+	var ip IPAddr
+	binary.NativeEndian.PutUint32(ip[:], v)
+
+	return ip
+}
+
+// maskKey clears all bits of key after the first bits
+func maskKey(key uint32, bits int) uint32 {
+	if bits == 0 {
+		return 0
+	}
+
+	return key & (^uint32(0) << (32 - bits))
+}
+
+// keyBit returns bit of key at position i counting from the most significant
+func keyBit(key uint32, i int) int {
+	return int(key>>(31-i)) & 1
+}
+
+// commonBits returns length of common prefix of keys limited by limit
+func commonBits(a, b uint32, limit int) int {
+	n := 0
+	for n < limit && keyBit(a, n) == keyBit(b, n) {
+		n++
+	}
+
+	return n
+}
@@ -0,0 +1,282 @@
+package ipv4
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func route(t *testing.T, prefix string, bits int, nextHop, iface string, metric int) Route {
+	r := Route{Bits: bits, Iface: iface, Metric: metric}
+
+	var err error
+
+	if r.Prefix, err = IPFromString(prefix); err != nil {
+		t.Fatal(err)
+	}
+
+	if nextHop != "" {
+		if r.NextHop, err = IPFromString(nextHop); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return r
+}
+
+func routeTable(t *testing.T) *RouteTable {
+	table := NewRouteTable()
+
+	for _, r := range []Route{
+		route(t, "0.0.0.0", 0, "192.168.0.254", "eth0", 100),
+		route(t, "0.0.0.0", 0, "10.0.0.254", "eth1", 50),
+		route(t, "192.168.0.0", 24, "", "eth0", 0),
+		route(t, "10.0.0.0", 8, "", "eth1", 0),
+		route(t, "10.1.0.0", 16, "10.0.0.1", "eth1", 0),
+		route(t, "10.1.2.3", 32, "10.0.0.2", "eth1", 0),
+		route(t, "10.128.0.0", 9, "10.0.0.3", "eth1", 0),
+	} {
+		if err := table.Add(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return table
+}
+
+func Test_RouteTable_Lookup(t *testing.T) {
+	table := routeTable(t)
+
+	tests := []struct {
+		dst      string
+		expected string
+	}{
+		{dst: "8.8.8.8", expected: "0.0.0.0/0 via 10.0.0.254 dev eth1 metric 50"},
+		{dst: "192.168.0.7", expected: "192.168.0.0/24 dev eth0 metric 0"},
+		{dst: "192.168.1.7", expected: "0.0.0.0/0 via 10.0.0.254 dev eth1 metric 50"},
+		{dst: "10.2.0.1", expected: "10.0.0.0/8 dev eth1 metric 0"},
+		{dst: "10.1.2.4", expected: "10.1.0.0/16 via 10.0.0.1 dev eth1 metric 0"},
+		{dst: "10.1.2.3", expected: "10.1.2.3/32 via 10.0.0.2 dev eth1 metric 0"},
+		{dst: "10.200.0.1", expected: "10.128.0.0/9 via 10.0.0.3 dev eth1 metric 0"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.dst, func(t *testing.T) {
+			dst, _ := IPFromString(test.dst)
+
+			r, ok := table.Lookup(dst)
+			if !ok || r.String() != test.expected {
+				t.Errorf("Route not expected %v", r)
+			}
+
+			if !r.Contains(dst) {
+				t.Errorf("Route %v does not contain %v", r, test.dst)
+			}
+		})
+	}
+}
+
+func Test_RouteTable_Lookup_NoDefault(t *testing.T) {
+	table := NewRouteTable()
+	table.Add(route(t, "192.168.0.0", 24, "", "eth0", 0))
+
+	dst, _ := IPFromString("192.168.1.1")
+	if r, ok := table.Lookup(dst); ok {
+		t.Errorf("Route not expected %v", r)
+	}
+}
+
+func Test_RouteTable_Add(t *testing.T) {
+	table := routeTable(t)
+
+	if err := table.Add(Route{Bits: 33}); !errors.Is(err, ErrBadPrefix) {
+		t.Errorf("Error not expected %v", err)
+	}
+
+	// host bits are cleared, route with other metric replaces existing one
+	if err := table.Add(route(t, "192.168.0.77", 24, "", "eth0", 5)); err != nil {
+		t.Fatal(err)
+	}
+
+	if table.Len() != 7 {
+		t.Errorf("Length not expected %d", table.Len())
+	}
+
+	dst, _ := IPFromString("192.168.0.1")
+	if r, _ := table.Lookup(dst); r.Metric != 5 {
+		t.Errorf("Route not expected %v", r)
+	}
+}
+
+func Test_RouteTable_Delete(t *testing.T) {
+	table := routeTable(t)
+
+	if table.Delete(route(t, "10.1.0.0", 16, "10.0.0.9", "eth1", 0)) {
+		t.Error("Route with other next hop deleted")
+	}
+
+	for _, r := range []Route{
+		route(t, "10.1.0.0", 16, "10.0.0.1", "eth1", 0),
+		route(t, "10.0.0.0", 8, "", "eth1", 0),
+		route(t, "0.0.0.0", 0, "10.0.0.254", "eth1", 0),
+	} {
+		if !table.Delete(r) {
+			t.Errorf("Route not deleted %v", r)
+		}
+	}
+
+	tests := []struct {
+		dst      string
+		expected string
+	}{
+		{dst: "10.1.2.4", expected: "0.0.0.0/0 via 192.168.0.254 dev eth0 metric 100"},
+		{dst: "10.1.2.3", expected: "10.1.2.3/32 via 10.0.0.2 dev eth1 metric 0"},
+		{dst: "10.200.0.1", expected: "10.128.0.0/9 via 10.0.0.3 dev eth1 metric 0"},
+	}
+
+	for _, test := range tests {
+		dst, _ := IPFromString(test.dst)
+
+		if r, _ := table.Lookup(dst); r.String() != test.expected {
+			t.Errorf("Route to %v not expected %v", test.dst, r)
+		}
+	}
+
+	if len(table.Routes()) != 4 || table.Len() != 4 {
+		t.Errorf("Routes not expected %v", table.Routes())
+	}
+}
+
+const procRoute = `Iface	Destination	Gateway 	Flags	RefCnt	Use	Metric	Mask		MTU	Window	IRTT
+eth0	00000000	FE00A8C0	0003	0	0	100	00000000	0	0	0
+eth0	0000A8C0	00000000	0001	0	0	100	00FFFFFF	0	0	0
+eth1	0000000A	00000000	0000	0	0	0	000000FF	0	0	0
+`
+
+func Test_ParseRouteTable(t *testing.T) {
+	table, err := ParseRouteTable(strings.NewReader(procRoute))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	routes := table.Routes()
+	if len(routes) != 2 {
+		t.Fatalf("Routes not expected %v", routes)
+	}
+
+	// addresses are in host byte order, little endian is assumed
+	if routes[0].String() != "0.0.0.0/0 via 192.168.0.254 dev eth0 metric 100" ||
+		routes[1].String() != "192.168.0.0/24 dev eth0 metric 100" {
+		t.Errorf("Routes not expected %v", routes)
+	}
+
+	if _, err := ParseRouteTable(strings.NewReader("header\neth0\t00000000\n")); err == nil {
+		t.Error("Short line parsed")
+	}
+
+	if _, err := ParseRouteTable(strings.NewReader("header\neth0 00000000 00000000 0001 0 0 0 00FF00FF\n")); !errors.Is(err, ErrBadPrefix) {
+		t.Errorf("Error not expected %v", err)
+	}
+}
+
+func Test_IpSocket_route(t *testing.T) {
+	is, r := arpSocket(t, "eth0", "192.168.0.1", 24)
+
+	if err := is.SetRouteTable(routeTable(t)); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		dst      string
+		expected string
+		err      error
+	}{
+		{dst: "192.168.0.7", expected: "192.168.0.7"},
+		{dst: "255.255.255.255", expected: "255.255.255.255"},
+		{dst: "8.8.8.8", expected: "192.168.0.254"},  // default through eth1 has lower metric
+		{dst: "10.2.0.1", expected: "192.168.0.254"}, // 10.0.0.0/8 goes through eth1
+	}
+
+	for _, test := range tests {
+		t.Run(test.dst, func(t *testing.T) {
+			dst, _ := IPFromString(test.dst)
+
+			hop, err := is.route(dst)
+			if !errors.Is(err, test.err) {
+				t.Fatalf("Error not expected %v", err)
+			}
+
+			if err == nil && hop.String() != test.expected {
+				t.Errorf("Next hop not expected %v", hop.String())
+			}
+		})
+	}
+
+	// gateway of route is used instead of default gateway of interface
+	table := NewRouteTable()
+	table.Add(route(t, "192.168.0.0", 24, "", "eth0", 0))
+	table.Add(route(t, "172.16.0.0", 12, "192.168.0.2", "eth0", 0))
+	is.SetRouteTable(table)
+	is.Neighbors().Update([4]byte{192, 168, 0, 2}, peerMac, true, time.Now())
+
+	dst, _ := IPFromString("172.16.0.1")
+	if err := is.WriteTo(dst, []byte("data")); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(r.frames) != 1 || !bytes.Equal(r.frames[0].dst, peerMac) {
+		t.Fatalf("Frames not expected %v", r.frames)
+	}
+
+	r.frames = nil
+
+	dst, _ = IPFromString("8.8.8.8")
+	if err := is.WriteTo(dst, []byte("data")); !errors.Is(err, ErrNoRoute) {
+		t.Errorf("Error not expected %v", err)
+	}
+
+	if len(r.frames) != 0 {
+		t.Errorf("Frames not expected %v", r.frames)
+	}
+}
+
+func Test_IpSocket_route_ShorterPrefix(t *testing.T) {
+	is, r := arpSocket(t, "eth0", "192.168.0.1", 24)
+
+	table := NewRouteTable()
+	table.Add(route(t, "10.0.0.0", 24, "", "eth1", 0))
+	table.Add(route(t, "0.0.0.0", 0, "192.168.0.254", "eth0", 0))
+	is.SetRouteTable(table)
+	is.Neighbors().Update([4]byte{192, 168, 0, 254}, gatewayMac, true, time.Now())
+
+	dst, _ := IPFromString("10.0.0.7")
+	if err := is.WriteTo(dst, []byte("data")); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(r.frames) != 1 || !bytes.Equal(r.frames[0].dst, gatewayMac) {
+		t.Fatalf("Frames not expected %v", r.frames)
+	}
+
+	// there is no route through eth0 without default one
+	table.Delete(route(t, "0.0.0.0", 0, "192.168.0.254", "eth0", 0))
+
+	if _, err := is.route(dst); !errors.Is(err, ErrRouteInterface) {
+		t.Errorf("Error not expected %v", err)
+	}
+}
+
+func Test_IpSocket_SetRouteTable_NoARP(t *testing.T) {
+	is, _ := arpSocket(t, "eth0", "192.168.0.1", 24)
+	is.DisableARP()
+
+	if err := is.SetRouteTable(NewRouteTable()); !errors.Is(err, ErrARPDisabled) {
+		t.Errorf("Error not expected %v", err)
+	}
+
+	if err := is.SetRouteTable(nil); err != nil {
+		t.Errorf("Error not expected %v", err)
+	}
+}
@@ -1,27 +1,85 @@
 package ipv4
 
 import (
+	"errors"
+	"fmt"
 	"net"
+	"sync/atomic"
+	"time"
 
 	"github.com/IvMaslov/ethernet"
+	"github.com/IvMaslov/ipv4/arp"
 	"github.com/IvMaslov/netutils"
 )
 
 var broadcastIP = IPAddr{255, 255, 255, 255}
 
+// ChecksumMode describes how socket treats header checksum of received datagrams
+type ChecksumMode int
+
+const (
+	// ChecksumVerify drops datagrams with wrong header checksum
+	ChecksumVerify ChecksumMode = iota
+	// ChecksumVerifyAllowZero works as ChecksumVerify, but accepts zero checksum
+	// which is left by NIC checksum offload on captured outgoing datagrams
+	ChecksumVerifyAllowZero
+	// ChecksumIgnore accepts datagrams without checksum verification
+	ChecksumIgnore
+)
+
+// SocketStats contains counters of datagrams dropped by socket
+type SocketStats struct {
+	Malformed   uint64 // datagrams failed to unmarshal
+	BadChecksum uint64 // datagrams with wrong header checksum
+	BadFragment uint64 // fragments rejected by reassembly
+	BadARP      uint64 // ARP packets failed to parse or answer
+}
+
+// PacketHandler processes packet intercepted by socket
+type PacketHandler func(p *Packet)
+
 type IpSocket struct {
 	ethSock     *ethernet.EtherSocket
+	recv        func() (etherType uint16, payload []byte, err error)
+	link        FrameWriter
+	ifName      string
 	ipInfo      *netutils.InterfaceInfo
 	gatewayInfo *netutils.InterfaceInfo
+	ipNet       *net.IPNet
 
 	dstIP IPAddr
+	mtu   int
+
+	protocol uint8
+	bound    bool
+
+	checksumMode ChecksumMode
+	dscp         dscpPolicy
+	idGen        IDGenerator
+
+	routerAlertHandler PacketHandler
+	errorReporter      ErrorReporter
+
+	reassembler *Reassembler
+	neighbors   *arp.Cache
+	routes      *RouteTable
+
+	malformed   atomic.Uint64
+	badChecksum atomic.Uint64
+	badFragment atomic.Uint64
+	badARP      atomic.Uint64
 }
 
 func NewIpSocket(es *ethernet.EtherSocket) (*IpSocket, error) {
-	ipSock := &IpSocket{
-		ethSock: es,
-	}
+	return NewIpSocketWithLink(es, nil)
+}
 
+// NewIpSocketWithLink creates socket which writes frames through link to
+// hardware address of the next hop resolved by ARP with default cache aging
+// (see EnableARP). Ethernet socket writes every frame to the same hardware
+// address, so link has to address frames itself. Nil link makes socket write
+// through es.
+func NewIpSocketWithLink(es *ethernet.EtherSocket, link FrameWriter) (*IpSocket, error) {
 	ipInfo, err := netutils.GetInterfaceInfo(es.Name())
 	if err != nil {
 		return nil, err
@@ -32,16 +90,125 @@ func NewIpSocket(es *ethernet.EtherSocket) (*IpSocket, error) {
 		return nil, err
 	}
 
-	ipSock.ipInfo = &ipInfo
-	ipSock.gatewayInfo = &gatewayInfo
-	ipSock.dstIP = broadcastIP // by default write to all
+	iface, err := net.InterfaceByName(es.Name())
+	if err != nil {
+		return nil, err
+	}
+
+	ipSock := newIpSocket(es.Name(), link, ipInfo, gatewayInfo, interfaceNetwork(iface, ipInfo.IP), iface.MTU)
+	ipSock.ethSock = es
+	ipSock.recv = func() (uint16, []byte, error) {
+		frame, err := es.ReadFrame()
+		if err != nil {
+			return 0, nil, err
+		}
+
+		return uint16(frame.EtherType), frame.Payload, nil
+	}
 
 	return ipSock, nil
 }
 
-// Name returns of interface
+// newIpSocket creates socket of interface described by arguments, frames are
+// neither read nor written through ethernet socket yet
+func newIpSocket(name string, link FrameWriter, ipInfo, gatewayInfo netutils.InterfaceInfo, ipNet *net.IPNet, mtu int) *IpSocket {
+	ipSock := &IpSocket{
+		link:         link,
+		ifName:       name,
+		ipInfo:       &ipInfo,
+		gatewayInfo:  &gatewayInfo,
+		ipNet:        ipNet,
+		mtu:          mtu,
+		dstIP:        broadcastIP, // by default write to all
+		checksumMode: ChecksumVerify,
+		idGen:        NewSequentialIDGenerator(),
+	}
+
+	if link != nil {
+		ipSock.EnableARP(arp.Config{}) // never fails with link
+	}
+
+	return ipSock
+}
+
+// BindProtocol binds socket to upper layer protocol: Write and WriteTo send
+// packets of protocol, Read and ReadPacket return only packets of protocol
+func (is *IpSocket) BindProtocol(protocol uint8) {
+	is.protocol = protocol
+	is.bound = true
+}
+
+// UnbindProtocol makes socket read packets of any protocol and send TCP ones
+func (is *IpSocket) UnbindProtocol() {
+	is.protocol = 0
+	is.bound = false
+}
+
+// GetProtocol returns protocol socket is bound to
+func (is *IpSocket) GetProtocol() (uint8, bool) {
+	return is.protocol, is.bound
+}
+
+// SetIDGenerator sets up generator of Identification field for packets sent
+// by Write and WriteTo, by default IDs are sequential per destination. Nil
+// restores the default generator.
+func (is *IpSocket) SetIDGenerator(g IDGenerator) {
+	if g == nil {
+		g = NewSequentialIDGenerator()
+	}
+
+	is.idGen = g
+}
+
+// GetIDGenerator returns generator of Identification field
+func (is *IpSocket) GetIDGenerator() IDGenerator {
+	return is.idGen
+}
+
+// SetChecksumMode sets up header checksum verification of received datagrams,
+// by default is ChecksumVerify
+func (is *IpSocket) SetChecksumMode(mode ChecksumMode) {
+	is.checksumMode = mode
+}
+
+// HandleRouterAlert registers handler for packets carrying Router Alert
+// option. Such packets are passed to handler instead of ReadPacket whatever
+// their destination is, nil handler disables interception.
+func (is *IpSocket) HandleRouterAlert(h PacketHandler) {
+	is.routerAlertHandler = h
+}
+
+// EnableReassembly makes ReadPacket return only complete datagrams,
+// fragments are collected with limits from cfg
+func (is *IpSocket) EnableReassembly(cfg ReassemblyConfig) {
+	is.reassembler = NewReassembler(cfg)
+}
+
+// DisableReassembly makes ReadPacket return fragments as they are received
+func (is *IpSocket) DisableReassembly() {
+	is.reassembler = nil
+}
+
+// SetErrorReporter sets up reporter notified when socket drops datagram:
+// datagram with zero TTL or exceeding MTU with Don't Fragment flag on send,
+// datagram of protocol without handler on dispatch, nil disables reports
+func (is *IpSocket) SetErrorReporter(r ErrorReporter) {
+	is.errorReporter = r
+}
+
+// Stats returns counters of dropped datagrams
+func (is *IpSocket) Stats() SocketStats {
+	return SocketStats{
+		Malformed:   is.malformed.Load(),
+		BadChecksum: is.badChecksum.Load(),
+		BadFragment: is.badFragment.Load(),
+		BadARP:      is.badARP.Load(),
+	}
+}
+
+// Name returns name of interface
 func (is *IpSocket) Name() string {
-	return is.ethSock.Name()
+	return is.ifName
 }
 
 // GetIp returns ip address of interface
@@ -49,6 +216,16 @@ func (is *IpSocket) GetIp() net.IP {
 	return is.ipInfo.IP
 }
 
+// GetIpAddr returns ip address of interface as IPAddr
+func (is *IpSocket) GetIpAddr() IPAddr {
+	ip := is.ipInfo.IP.To4()
+	if ip == nil {
+		return IPAddr{}
+	}
+
+	return IPAddr(ip)
+}
+
 // GetGatewayIp returns ip address of interface's gateway
 func (is *IpSocket) GetGatewayIp() net.IP {
 	return is.gatewayInfo.IP
@@ -59,6 +236,57 @@ func (is *IpSocket) GetDstIp() IPAddr {
 	return is.dstIP
 }
 
+// GetMTU returns maximum size of datagram sent without fragmentation
+func (is *IpSocket) GetMTU() int {
+	return is.mtu
+}
+
+// SetMTU overrides MTU of interface, e.g. to match path MTU
+func (is *IpSocket) SetMTU(mtu int) {
+	is.mtu = mtu
+}
+
+// SetDSCP sets up code point of packets sent by Write and WriteTo
+func (is *IpSocket) SetDSCP(d DSCP) error {
+	if d > maxDSCP {
+		return fmt.Errorf("wrong dscp %d", d)
+	}
+
+	is.dscp.mu.Lock()
+	defer is.dscp.mu.Unlock()
+
+	is.dscp.dflt = d
+
+	return nil
+}
+
+// SetDSCPFor sets up code point of packets sent by Write and WriteTo to dst,
+// it overrides one set by SetDSCP
+func (is *IpSocket) SetDSCPFor(dst IPAddr, d DSCP) error {
+	if d > maxDSCP {
+		return fmt.Errorf("wrong dscp %d", d)
+	}
+
+	is.dscp.mu.Lock()
+	defer is.dscp.mu.Unlock()
+
+	if is.dscp.perDest == nil {
+		is.dscp.perDest = make(map[IPAddr]DSCP)
+	}
+
+	is.dscp.perDest[dst] = d
+
+	return nil
+}
+
+// ClearDSCPFor removes code point set up for dst by SetDSCPFor
+func (is *IpSocket) ClearDSCPFor(dst IPAddr) {
+	is.dscp.mu.Lock()
+	defer is.dscp.mu.Unlock()
+
+	delete(is.dscp.perDest, dst)
+}
+
 // GetMac returns mac address of underlying device
 func (is *IpSocket) GetMac() net.HardwareAddr {
 	return is.ipInfo.HardAddr
@@ -79,21 +307,65 @@ func (is *IpSocket) Read() ([]byte, error) {
 	return p.Data, nil
 }
 
-// ReadPacket returns full ip packet with data
+// ReadPacket returns full ip packet with data, malformed datagrams and
+// datagrams with wrong checksum (see SetChecksumMode) are dropped, packets
+// with Router Alert option may be intercepted (see HandleRouterAlert).
+// ARP packets are handled by socket when ARP is enabled. When reassembly
+// is enabled only complete datagrams are returned, bound socket returns only
+// packets of its protocol.
 func (is *IpSocket) ReadPacket() (*Packet, error) {
 	for {
-		frame, err := is.ethSock.ReadFrame()
+		etherType, payload, err := is.recv()
 		if err != nil {
 			return nil, err
 		}
 
-		if frame.EtherType != ethernet.EtherTypeIPv4 {
+		if etherType == arp.EtherType {
+			if err := is.handleARP(payload); err != nil {
+				is.badARP.Add(1)
+			}
+
+			continue
+		}
+
+		if etherType != uint16(ethernet.EtherTypeIPv4) {
 			continue
 		}
 
 		p := &Packet{}
 
-		p.Unmarshal(frame.Payload)
+		if err := p.Unmarshal(payload); err != nil {
+			is.malformed.Add(1)
+			continue
+		}
+
+		if !is.checksumValid(p) {
+			is.badChecksum.Add(1)
+			continue
+		}
+
+		if is.reassembler != nil {
+			p, err = is.reassembler.Add(p, time.Now())
+			if err != nil {
+				is.badFragment.Add(1)
+				continue
+			}
+
+			if p == nil { // datagram is not complete yet
+				continue
+			}
+		}
+
+		if is.routerAlertHandler != nil {
+			if _, ok := p.RouterAlert(); ok {
+				is.routerAlertHandler(p)
+				continue
+			}
+		}
+
+		if is.bound && p.Protocol != is.protocol {
+			continue
+		}
 
 		return p, nil
 	}
@@ -106,21 +378,99 @@ func (is *IpSocket) Connect(to IPAddr) {
 
 // Write sends data to destination ip
 func (is *IpSocket) Write(data []byte) error {
-	p := New(IPAddr(is.ipInfo.IP), is.dstIP, data)
-
-	return is.WritePacket(p)
+	return is.WriteTo(is.dstIP, data)
 }
 
 // WriteTo sends data to certain ip address
 func (is *IpSocket) WriteTo(to IPAddr, data []byte) error {
-	p := New(IPAddr(is.ipInfo.IP), to, data)
+	p := New(is.GetIpAddr(), to, data).WithDSCP(is.dscp.lookup(to))
+
+	if is.bound {
+		p.WithProtocol(is.protocol)
+	}
 
 	return is.WritePacket(p)
 }
 
-// WritePacket sends ready packet
+// WritePacket sends ready packet, packet with zero ID gets one from ID
+// generator of socket (see SetIDGenerator). For packet with source route
+// option which is not sent yet, destination is set to the first hop and final
+// destination is moved to the end of the route, given packet is not changed.
+// Packet exceeding MTU is fragmented, unless it has Don't Fragment flag, then
+// ErrFragmentationNeeded is returned. Datagram with zero TTL is not sent.
+// Next hop is chosen by routing table (see SetRouteTable), otherwise it is
+// destination on interface network or gateway. When ARP is enabled datagram
+// is sent to hardware address of the next hop.
 func (is *IpSocket) WritePacket(p *Packet) error {
-	data := p.Marshal()
+	if p.TTL == 0 {
+		is.reportError(ReasonTTLExpired, p)
+		return ErrTTLExpired
+	}
+
+	if p.ID == 0 {
+		identified := *p
+		identified.ID = is.idGen.NextID(p.Src, p.Dst, p.Protocol)
+		p = &identified
+	}
+
+	p, err := p.originateSourceRoute()
+	if err != nil {
+		return err
+	}
+
+	hop, err := is.route(p.Dst)
+	if err != nil {
+		if errors.Is(err, ErrNoRoute) {
+			is.reportError(ReasonNetUnreachable, p)
+		}
+
+		return err
+	}
+
+	fragments, err := p.Fragment(is.mtu)
+	if err != nil {
+		if errors.Is(err, ErrFragmentationNeeded) {
+			is.reportError(ReasonFragmentationNeeded, p)
+		}
+
+		return err
+	}
 
-	return is.ethSock.Write(data)
+	for _, f := range fragments {
+		data, err := f.MarshalChecked()
+		if err != nil {
+			return err
+		}
+
+		if err := is.writeFrame(f.Dst, hop, data); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (is *IpSocket) checksumValid(p *Packet) bool {
+	switch is.checksumMode {
+	case ChecksumIgnore:
+		return true
+	case ChecksumVerifyAllowZero:
+		if p.Checksum == 0 {
+			return true
+		}
+	}
+
+	return p.VerifyChecksum()
+}
+
+func (is *IpSocket) reportError(reason ErrorReason, p *Packet) {
+	is.reportErrorMTU(reason, p, is.mtu)
+}
+
+// reportErrorMTU reports error with mtu of other interface, e.g. egress one
+// of forwarded datagram
+func (is *IpSocket) reportErrorMTU(reason ErrorReason, p *Packet, mtu int) {
+	if is.errorReporter != nil {
+		is.errorReporter.ReportError(reason, p, mtu)
+	}
 }
@@ -0,0 +1,116 @@
+package ipv4
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/IvMaslov/ethernet"
+)
+
+func Test_IpSocket_ReadPacket_Checksum(t *testing.T) {
+	local, _ := IPFromString("192.168.0.1")
+	peer, _ := IPFromString("192.168.0.2")
+
+	bad := New(peer, local, []byte("bad")).Marshal()
+	bad[10]++
+
+	zero := New(peer, local, []byte("zero")).Marshal()
+	zero[10], zero[11] = 0, 0
+
+	tests := []struct {
+		name     string
+		mode     ChecksumMode
+		expected []string
+		dropped  uint64
+	}{
+		{name: "Verify", mode: ChecksumVerify, expected: []string{"good"}, dropped: 2},
+		{name: "Verify allow zero", mode: ChecksumVerifyAllowZero, expected: []string{"zero", "good"}, dropped: 1},
+		{name: "Ignore", mode: ChecksumIgnore, expected: []string{"bad", "zero", "good"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			is, _ := arpSocket(t, "eth0", "192.168.0.1", 24)
+			is.SetChecksumMode(test.mode)
+
+			etherType := uint16(ethernet.EtherTypeIPv4)
+			is.recv = frames(
+				frame{etherType: etherType, payload: bad},
+				frame{etherType: etherType, payload: zero},
+				frame{etherType: etherType, payload: New(peer, local, []byte("good")).Marshal()},
+			)
+
+			var read []string
+
+			for {
+				p, err := is.ReadPacket()
+				if errors.Is(err, io.EOF) {
+					break
+				}
+
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				read = append(read, string(p.Data))
+			}
+
+			if strings.Join(read, ",") != strings.Join(test.expected, ",") {
+				t.Errorf("Read not expected %v", read)
+			}
+
+			if stats := is.Stats(); stats.BadChecksum != test.dropped {
+				t.Errorf("Stats not expected %v", stats)
+			}
+		})
+	}
+}
+
+func Test_IpSocket_BindProtocol(t *testing.T) {
+	is, r := arpSocket(t, "eth0", "192.168.0.1", 24)
+
+	peer, _ := IPFromString("192.168.0.2")
+	local := is.GetIpAddr()
+
+	is.BindProtocol(ProtocolUDP)
+	is.recv = frames(ipFrames(
+		New(peer, local, []byte("icmp")).WithProtocol(ProtocolICMP),
+		New(peer, local, []byte("udp")).WithProtocol(ProtocolUDP),
+		New(peer, local, []byte("tcp")).WithProtocol(ProtocolTCP),
+	)...)
+
+	p, err := is.ReadPacket()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if p.Protocol != ProtocolUDP || string(p.Data) != "udp" {
+		t.Errorf("Read not expected %v", p)
+	}
+
+	if p, err := is.ReadPacket(); !errors.Is(err, io.EOF) {
+		t.Errorf("Read not expected %v %v", p, err)
+	}
+
+	is.Neighbors().Update(peer, peerMac, true, time.Now())
+
+	if err := is.WriteTo(peer, []byte("data")); err != nil {
+		t.Fatal(err)
+	}
+
+	written := &Packet{}
+	if err := written.Unmarshal(r.frames[len(r.frames)-1].payload); err != nil || written.Protocol != ProtocolUDP {
+		t.Errorf("Written not expected %v %v", written, err)
+	}
+
+	// unbound socket reads any protocol
+	is.UnbindProtocol()
+	is.recv = frames(ipFrames(New(peer, local, nil).WithProtocol(ProtocolICMP))...)
+
+	if p, err := is.ReadPacket(); err != nil || p.Protocol != ProtocolICMP {
+		t.Errorf("Read not expected %v %v", p, err)
+	}
+}
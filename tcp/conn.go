@@ -0,0 +1,813 @@
+package tcp
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/IvMaslov/ipv4"
+)
+
+const (
+	defaultMSS = 536 // RFC1122
+	bufferSize = 64 * 1024
+	maxWindow  = 65535
+	maxRetries = 8
+
+	timeWait = 2 * 30 * time.Second // 2 MSL
+
+	defaultFinTimeout = 60 * time.Second // tcp_fin_timeout of Linux
+)
+
+var (
+	ErrConnRefused = errors.New("tcp connection refused")
+	ErrConnReset   = errors.New("tcp connection reset by peer")
+	ErrTimeout     = errors.New("tcp connection timed out")
+)
+
+// State is state of connection from RFC793
+type State int
+
+const (
+	StateClosed State = iota
+	StateSynSent
+	StateSynReceived
+	StateEstablished
+	StateFinWait1
+	StateFinWait2
+	StateCloseWait
+	StateClosing
+	StateLastAck
+	StateTimeWait
+)
+
+func (s State) String() string {
+	switch s {
+	case StateClosed:
+		return "CLOSED"
+	case StateSynSent:
+		return "SYN-SENT"
+	case StateSynReceived:
+		return "SYN-RECEIVED"
+	case StateEstablished:
+		return "ESTABLISHED"
+	case StateFinWait1:
+		return "FIN-WAIT-1"
+	case StateFinWait2:
+		return "FIN-WAIT-2"
+	case StateCloseWait:
+		return "CLOSE-WAIT"
+	case StateClosing:
+		return "CLOSING"
+	case StateLastAck:
+		return "LAST-ACK"
+	case StateTimeWait:
+		return "TIME-WAIT"
+	}
+
+	return fmt.Sprintf("state(%d)", int(s))
+}
+
+// Addr is address of TCP connection end, it implements net.Addr
+type Addr struct {
+	IP   ipv4.IPAddr
+	Port uint16
+}
+
+// Network returns name of network
+func (a *Addr) Network() string {
+	return "tcp"
+}
+
+func (a *Addr) String() string {
+	return net.JoinHostPort(a.IP.String(), fmt.Sprint(a.Port))
+}
+
+var _ net.Conn = (*Conn)(nil)
+
+// Conn is TCP connection, it implements net.Conn. Write returns once data
+// is buffered, Close sends queued data and FIN in background.
+type Conn struct {
+	stack  *Stack
+	local  Addr
+	remote Addr
+
+	listener *Listener // listener of passive connection until it is accepted
+
+	mu         sync.Mutex
+	state      State
+	err        error // reason of abnormal termination
+	userClosed bool
+	changed    chan struct{} // closed on every change of state or buffers
+
+	// send sequence variables
+	iss     uint32
+	sndUna  uint32
+	sndNxt  uint32
+	sndWnd  uint32
+	sndWL1  uint32
+	sndWL2  uint32
+	mss     int
+	sendBuf bytes.Buffer // data from sndUna not acknowledged yet
+
+	finQueued bool
+	finSent   bool
+
+	// receive sequence variables
+	irs         uint32
+	rcvNxt      uint32
+	recvBuf     bytes.Buffer
+	finReceived bool
+
+	rto       *rtoEstimator
+	timer     *time.Timer
+	timerGen  uint64
+	retries   int
+	rttTiming bool
+	rttSeq    uint32
+	rttStart  time.Time
+
+	readDeadline  time.Time
+	writeDeadline time.Time
+}
+
+func newConn(s *Stack, local, remote Addr, iss uint32) *Conn {
+	return &Conn{
+		stack:   s,
+		local:   local,
+		remote:  remote,
+		changed: make(chan struct{}),
+		iss:     iss,
+		sndUna:  iss,
+		sndNxt:  iss + 1,
+		mss:     min(defaultMSS, int(s.mss)),
+		rto:     newRTOEstimator(),
+	}
+}
+
+// State returns current state of connection
+func (c *Conn) State() State {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.state
+}
+
+// Read reads received data, io.EOF is returned after peer closed connection
+// and all data is read
+func (c *Conn) Read(b []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for {
+		switch {
+		case c.userClosed:
+			return 0, net.ErrClosed
+		case c.recvBuf.Len() > 0:
+			before := c.rcvWindow()
+			n, _ := c.recvBuf.Read(b)
+
+			// window update to sender waiting for window to open
+			if before < c.mss && c.rcvWindow() >= c.mss && c.state == StateEstablished {
+				c.sendAck()
+			}
+
+			return n, nil
+		case c.finReceived:
+			return 0, io.EOF
+		case c.err != nil:
+			return 0, c.err
+		case c.state == StateClosed:
+			return 0, net.ErrClosed
+		}
+
+		if err := c.wait(c.readDeadline); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// Write buffers data and sends it as window allows, it blocks while send
+// buffer is full
+func (c *Conn) Write(b []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	written := 0
+
+	for written < len(b) {
+		switch {
+		case c.userClosed:
+			return written, net.ErrClosed
+		case c.err != nil:
+			return written, c.err
+		case c.state != StateEstablished && c.state != StateCloseWait:
+			return written, net.ErrClosed
+		}
+
+		if space := bufferSize - c.sendBuf.Len(); space > 0 {
+			n := min(space, len(b)-written)
+
+			c.sendBuf.Write(b[written : written+n])
+			written += n
+
+			c.output()
+
+			continue
+		}
+
+		if err := c.wait(c.writeDeadline); err != nil {
+			return written, err
+		}
+	}
+
+	return written, nil
+}
+
+// Close sends FIN after buffered data, connection stays in the stack until
+// peer acknowledges it and closes its side. Peer which acknowledges FIN but
+// never sends own one is dropped after FIN-WAIT-2 timeout (see
+// Stack.SetFinTimeout).
+func (c *Conn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.userClosed {
+		return net.ErrClosed
+	}
+
+	c.userClosed = true
+
+	switch c.state {
+	case StateSynSent, StateSynReceived:
+		c.terminate(nil)
+	case StateEstablished:
+		c.finQueued = true
+		c.state = StateFinWait1
+	case StateCloseWait:
+		c.finQueued = true
+		c.state = StateLastAck
+	}
+
+	c.output()
+	c.notify()
+
+	return nil
+}
+
+// Reset aborts connection sending RST, buffered data is discarded
+func (c *Conn) Reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.userClosed = true
+
+	if c.state != StateClosed && c.state != StateSynSent {
+		c.send(FlagRST, c.sndNxt, nil)
+	}
+
+	c.terminate(nil)
+}
+
+// LocalAddr returns local address of connection
+func (c *Conn) LocalAddr() net.Addr {
+	return &Addr{IP: c.local.IP, Port: c.local.Port}
+}
+
+// RemoteAddr returns address of peer
+func (c *Conn) RemoteAddr() net.Addr {
+	return &Addr{IP: c.remote.IP, Port: c.remote.Port}
+}
+
+// SetDeadline sets up read and write deadlines
+func (c *Conn) SetDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.readDeadline = t
+	c.writeDeadline = t
+	c.notify()
+
+	return nil
+}
+
+// SetReadDeadline sets up time after which Read fails with
+// os.ErrDeadlineExceeded, zero value disables deadline
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.readDeadline = t
+	c.notify()
+
+	return nil
+}
+
+// SetWriteDeadline sets up time after which Write blocked on full buffer
+// fails with os.ErrDeadlineExceeded, zero value disables deadline
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.writeDeadline = t
+	c.notify()
+
+	return nil
+}
+
+// wait releases lock until connection changes or deadline expires
+func (c *Conn) wait(deadline time.Time) error {
+	if !deadline.IsZero() && !time.Now().Before(deadline) {
+		return os.ErrDeadlineExceeded
+	}
+
+	changed := c.changed
+
+	c.mu.Unlock()
+	defer c.mu.Lock()
+
+	var expired <-chan time.Time
+
+	if !deadline.IsZero() {
+		timer := time.NewTimer(time.Until(deadline))
+		defer timer.Stop()
+
+		expired = timer.C
+	}
+
+	select {
+	case <-changed:
+		return nil
+	case <-expired:
+		return os.ErrDeadlineExceeded
+	}
+}
+
+func (c *Conn) notify() {
+	close(c.changed)
+	c.changed = make(chan struct{})
+}
+
+// open sends SYN of active open
+func (c *Conn) open() error {
+	c.state = StateSynSent
+	c.startRTT(c.iss + 1)
+	c.armTimer()
+
+	return c.send(FlagSYN, c.iss, nil)
+}
+
+// accept sends SYN-ACK in response to SYN of passive open
+func (c *Conn) accept(syn *Segment) {
+	c.state = StateSynReceived
+	c.irs = syn.Seq
+	c.rcvNxt = syn.Seq + 1
+	c.sndWnd = uint32(syn.Window)
+	c.sndWL1 = syn.Seq
+	c.peerMSS(syn)
+
+	c.startRTT(c.iss + 1)
+	c.armTimer()
+	c.send(FlagSYN|FlagACK, c.iss, nil)
+}
+
+func (c *Conn) handle(seg *Segment) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	switch c.state {
+	case StateClosed:
+		return
+	case StateSynSent:
+		c.handleSynSent(seg)
+	default:
+		c.handleSynchronized(seg)
+	}
+
+	c.output()
+	c.notify()
+}
+
+func (c *Conn) handleSynSent(seg *Segment) {
+	if seg.Has(FlagACK) && seg.Ack != c.iss+1 {
+		if !seg.Has(FlagRST) {
+			c.send(FlagRST, seg.Ack, nil)
+		}
+
+		return
+	}
+
+	if seg.Has(FlagRST) {
+		if seg.Has(FlagACK) {
+			c.terminate(ErrConnRefused)
+		}
+
+		return
+	}
+
+	if !seg.Has(FlagSYN) {
+		return
+	}
+
+	c.irs = seg.Seq
+	c.rcvNxt = seg.Seq + 1
+	c.peerMSS(seg)
+
+	if !seg.Has(FlagACK) { // simultaneous open
+		c.state = StateSynReceived
+		c.sndWnd = uint32(seg.Window)
+		c.sndWL1 = seg.Seq
+		c.send(FlagSYN|FlagACK, c.iss, nil)
+
+		return
+	}
+
+	c.acknowledge(seg.Ack)
+	c.sndWnd = uint32(seg.Window)
+	c.sndWL1 = seg.Seq
+	c.sndWL2 = seg.Ack
+	c.state = StateEstablished
+	c.sendAck()
+}
+
+func (c *Conn) handleSynchronized(seg *Segment) {
+	if !c.acceptable(seg) {
+		if !seg.Has(FlagRST) {
+			c.sendAck()
+		}
+
+		return
+	}
+
+	if seg.Has(FlagRST) {
+		if seg.Seq == c.rcvNxt {
+			c.terminate(ErrConnReset)
+		}
+
+		return
+	}
+
+	seq, flags, data := seg.Seq, seg.Flags, seg.Data
+	needAck := false
+
+	// drop part of segment which is already received
+	if seqLT(seq, c.rcvNxt) {
+		if flags&FlagSYN != 0 {
+			flags &^= FlagSYN
+			seq++
+		}
+
+		skip := min(int(c.rcvNxt-seq), len(data))
+		data = data[skip:]
+		seq += uint32(skip)
+
+		if seqLT(seq, c.rcvNxt) { // duplicate FIN
+			flags &^= FlagFIN
+			seq = c.rcvNxt
+		}
+
+		needAck = true
+	}
+
+	if flags&FlagSYN != 0 { // challenge ACK from RFC5961
+		c.sendAck()
+		return
+	}
+
+	if !seg.Has(FlagACK) {
+		return
+	}
+
+	if c.state == StateSynReceived {
+		if !seqLT(c.sndUna, seg.Ack) || !seqLEQ(seg.Ack, c.sndNxt) {
+			c.send(FlagRST, seg.Ack, nil)
+			return
+		}
+
+		c.state = StateEstablished
+
+		if c.listener != nil {
+			c.listener.ready(c)
+			c.listener = nil
+
+			if c.state == StateClosed { // backlog is full
+				return
+			}
+		}
+	}
+
+	if seqLT(c.sndNxt, seg.Ack) { // acknowledges data not sent yet
+		c.sendAck()
+		return
+	}
+
+	if seqLT(c.sndUna, seg.Ack) {
+		c.acknowledge(seg.Ack)
+	}
+
+	c.retries = 0
+	c.updateWindow(seg)
+
+	if c.finSent && c.sndUna == c.sndNxt {
+		switch c.state {
+		case StateFinWait1:
+			c.state = StateFinWait2
+			c.startTimer(c.stack.FinTimeout())
+		case StateClosing:
+			c.enterTimeWait()
+		case StateLastAck:
+			c.terminate(nil)
+			return
+		}
+	}
+
+	if seq != c.rcvNxt { // out of order segments are dropped
+		c.sendAck()
+		return
+	}
+
+	switch c.state {
+	case StateEstablished, StateFinWait1, StateFinWait2:
+		if len(data) > 0 {
+			n := min(len(data), c.rcvWindow())
+
+			c.recvBuf.Write(data[:n])
+			c.rcvNxt += uint32(n)
+			needAck = true
+
+			if n < len(data) { // FIN is beyond window
+				flags &^= FlagFIN
+			}
+		}
+	}
+
+	if flags&FlagFIN != 0 && !c.finReceived {
+		c.rcvNxt++
+		c.finReceived = true
+		needAck = true
+
+		switch c.state {
+		case StateEstablished:
+			c.state = StateCloseWait
+		case StateFinWait1:
+			c.state = StateClosing
+		case StateFinWait2:
+			c.enterTimeWait()
+		}
+	}
+
+	if needAck {
+		c.sendAck()
+	}
+}
+
+// acceptable checks sequence number of segment against receive window
+func (c *Conn) acceptable(seg *Segment) bool {
+	wnd := uint32(c.rcvWindow())
+	length := uint32(len(seg.Data))
+
+	if seg.Has(FlagSYN) || (seg.Has(FlagFIN) && wnd > 0) {
+		length = seg.Len() // bare FIN is taken even by zero window
+	}
+
+	inWindow := func(seq uint32) bool {
+		return seqLEQ(c.rcvNxt, seq) && seqLT(seq, c.rcvNxt+wnd)
+	}
+
+	switch {
+	case length == 0 && wnd == 0:
+		return seg.Seq == c.rcvNxt
+	case length == 0:
+		return inWindow(seg.Seq)
+	case wnd == 0:
+		return false
+	}
+
+	return inWindow(seg.Seq) || inWindow(seg.Seq+length-1)
+}
+
+// acknowledge removes acknowledged data from send buffer
+func (c *Conn) acknowledge(ack uint32) {
+	acked := int(ack - c.sndUna)
+
+	if c.sndUna == c.iss { // SYN is acknowledged
+		acked--
+	}
+
+	c.sendBuf.Next(min(acked, c.sendBuf.Len()))
+	c.sndUna = ack
+
+	if c.rttTiming && seqLEQ(c.rttSeq, ack) {
+		c.rto.sample(time.Since(c.rttStart))
+		c.rttTiming = false
+	}
+
+	c.rto.reset()
+	c.retries = 0
+
+	if c.sndUna == c.sndNxt {
+		c.stopTimer()
+	} else {
+		c.startTimer(c.rto.rto)
+	}
+}
+
+func (c *Conn) updateWindow(seg *Segment) {
+	if seqLT(c.sndWL1, seg.Seq) || (c.sndWL1 == seg.Seq && seqLEQ(c.sndWL2, seg.Ack)) {
+		c.sndWnd = uint32(seg.Window)
+		c.sndWL1 = seg.Seq
+		c.sndWL2 = seg.Ack
+	}
+}
+
+func (c *Conn) peerMSS(seg *Segment) {
+	mss, ok := seg.MSS()
+	if !ok {
+		mss = defaultMSS
+	}
+
+	c.mss = min(int(mss), int(c.stack.mss))
+}
+
+// output sends buffered data allowed by window and queued FIN
+func (c *Conn) output() {
+	if c.state < StateEstablished || c.finSent {
+		return
+	}
+
+	for {
+		offset := int(c.sndNxt - c.sndUna)
+		pending := c.sendBuf.Len() - offset
+		n := min(pending, int(c.sndWnd)-offset, c.mss)
+
+		// avoid silly window: small segment is sent only when it carries the
+		// rest of data or nothing is in flight
+		if n <= 0 || (n < c.mss && n < pending && offset > 0) {
+			break
+		}
+
+		if !c.rttTiming {
+			c.startRTT(c.sndNxt + uint32(n))
+		}
+
+		c.send(FlagACK|FlagPSH, c.sndNxt, c.sendBuf.Bytes()[offset:offset+n])
+		c.sndNxt += uint32(n)
+	}
+
+	if c.finQueued && int(c.sndNxt-c.sndUna) == c.sendBuf.Len() {
+		c.send(FlagFIN|FlagACK, c.sndNxt, nil)
+		c.finSent = true
+		c.sndNxt++
+	}
+
+	// data in flight or waiting for window to open
+	if c.sndNxt != c.sndUna || c.sendBuf.Len() > 0 {
+		c.armTimer()
+	}
+}
+
+// retransmit resends the first unacknowledged segment or probes zero window
+func (c *Conn) retransmit() {
+	c.retries++
+
+	if c.retries > maxRetries {
+		c.terminate(ErrTimeout)
+		return
+	}
+
+	c.rto.backoff()
+	c.rttTiming = false // Karn's algorithm
+
+	switch {
+	case c.state == StateSynSent:
+		c.send(FlagSYN, c.iss, nil)
+	case c.state == StateSynReceived:
+		c.send(FlagSYN|FlagACK, c.iss, nil)
+	case c.sndNxt == c.sndUna && c.sendBuf.Len() > 0: // zero window probe
+		c.send(FlagACK, c.sndNxt, c.sendBuf.Bytes()[:1])
+		c.sndNxt++
+	default:
+		n := min(c.sendBuf.Len(), c.mss, int(c.sndNxt-c.sndUna))
+		if n > 0 {
+			c.send(FlagACK|FlagPSH, c.sndUna, c.sendBuf.Bytes()[:n])
+		} else if c.finSent {
+			c.send(FlagFIN|FlagACK, c.sndUna, nil)
+		}
+	}
+
+	c.startTimer(c.rto.rto)
+}
+
+func (c *Conn) startRTT(seq uint32) {
+	c.rttTiming = true
+	c.rttSeq = seq
+	c.rttStart = time.Now()
+}
+
+// armTimer starts retransmission timer if it is not running
+func (c *Conn) armTimer() {
+	if c.timer == nil {
+		c.startTimer(c.rto.rto)
+	}
+}
+
+func (c *Conn) startTimer(d time.Duration) {
+	c.stopTimer()
+
+	c.timerGen++
+	gen := c.timerGen
+
+	c.timer = time.AfterFunc(d, func() {
+		c.expire(gen)
+	})
+}
+
+func (c *Conn) stopTimer() {
+	if c.timer != nil {
+		c.timer.Stop()
+		c.timer = nil
+	}
+}
+
+func (c *Conn) expire(gen uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if gen != c.timerGen || c.timer == nil {
+		return // timer was stopped or restarted
+	}
+
+	c.timer = nil
+
+	switch c.state {
+	case StateClosed:
+		return
+	case StateTimeWait, StateFinWait2:
+		c.terminate(nil)
+		return
+	}
+
+	c.retransmit()
+	c.notify()
+}
+
+func (c *Conn) enterTimeWait() {
+	c.state = StateTimeWait
+	c.startTimer(timeWait)
+}
+
+// terminate closes connection and removes it from the stack
+func (c *Conn) terminate(err error) {
+	if c.state == StateClosed {
+		return
+	}
+
+	c.state = StateClosed
+
+	if c.err == nil {
+		c.err = err
+	}
+
+	c.stopTimer()
+	c.stack.unregister(c)
+	c.notify()
+}
+
+func (c *Conn) rcvWindow() int {
+	return min(bufferSize-c.recvBuf.Len(), maxWindow)
+}
+
+func (c *Conn) sendAck() {
+	if c.state == StateSynReceived {
+		c.send(FlagSYN|FlagACK, c.iss, nil)
+		return
+	}
+
+	c.send(FlagACK, c.sndNxt, nil)
+}
+
+func (c *Conn) send(flags uint8, seq uint32, data []byte) error {
+	seg := &Segment{
+		SrcPort: c.local.Port,
+		DstPort: c.remote.Port,
+		Seq:     seq,
+		Flags:   flags,
+		Window:  uint16(c.rcvWindow()),
+		Data:    data,
+	}
+
+	if flags&FlagACK != 0 {
+		seg.Ack = c.rcvNxt
+	}
+
+	if flags&FlagSYN != 0 {
+		seg.Options = MSSOption(c.stack.mss)
+	}
+
+	return c.stack.send(seg, c.remote.IP)
+}
@@ -0,0 +1,67 @@
+package tcp
+
+import "time"
+
+const (
+	initialRTO = time.Second
+	minRTO     = time.Second
+	maxRTO     = 60 * time.Second
+	clockG     = time.Millisecond // clock granularity
+)
+
+// rtoEstimator calculates retransmission timeout from RFC6298
+type rtoEstimator struct {
+	srtt   time.Duration
+	rttvar time.Duration
+	rto    time.Duration
+	min    time.Duration
+	valid  bool // at least one sample is taken
+}
+
+func newRTOEstimator() *rtoEstimator {
+	return &rtoEstimator{rto: initialRTO, min: minRTO}
+}
+
+// sample updates estimation with round trip time measured on segment which
+// was not retransmitted (Karn's algorithm is up to caller)
+func (e *rtoEstimator) sample(rtt time.Duration) {
+	if !e.valid {
+		e.srtt = rtt
+		e.rttvar = rtt / 2
+		e.valid = true
+	} else {
+		delta := e.srtt - rtt
+		if delta < 0 {
+			delta = -delta
+		}
+
+		// RTTVAR <- (1 - 1/4) * RTTVAR + 1/4 * |SRTT - R'|
+		// SRTT <- (1 - 1/8) * SRTT + 1/8 * R'
+		e.rttvar = e.rttvar*3/4 + delta/4
+		e.srtt = e.srtt*7/8 + rtt/8
+	}
+
+	e.rto = e.current()
+}
+
+// current returns timeout calculated from estimation without backoff
+func (e *rtoEstimator) current() time.Duration {
+	if !e.valid {
+		return initialRTO
+	}
+
+	// RTO <- SRTT + max (G, K*RTTVAR)
+	rto := e.srtt + max(clockG, 4*e.rttvar)
+
+	return min(max(rto, e.min), maxRTO)
+}
+
+// backoff doubles timeout after retransmission
+func (e *rtoEstimator) backoff() {
+	e.rto = min(e.rto*2, maxRTO)
+}
+
+// reset drops backoff when new data is acknowledged
+func (e *rtoEstimator) reset() {
+	e.rto = e.current()
+}
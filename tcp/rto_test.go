@@ -0,0 +1,53 @@
+package tcp
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_rtoEstimator(t *testing.T) {
+	e := newRTOEstimator()
+
+	if e.rto != initialRTO {
+		t.Errorf("Initial RTO not expected %v", e.rto)
+	}
+
+	e.min = 0
+
+	// SRTT = 100ms, RTTVAR = 50ms, RTO = 100ms + 4*50ms
+	e.sample(100 * time.Millisecond)
+	if e.srtt != 100*time.Millisecond || e.rttvar != 50*time.Millisecond || e.rto != 300*time.Millisecond {
+		t.Errorf("Estimation not expected %v %v %v", e.srtt, e.rttvar, e.rto)
+	}
+
+	// RTTVAR = 3/4*50ms + 1/4*100ms, SRTT = 7/8*100ms + 1/8*200ms
+	e.sample(200 * time.Millisecond)
+	if e.srtt != 112500*time.Microsecond || e.rttvar != 62500*time.Microsecond || e.rto != 362500*time.Microsecond {
+		t.Errorf("Estimation not expected %v %v %v", e.srtt, e.rttvar, e.rto)
+	}
+
+	e.backoff()
+	e.backoff()
+	if e.rto != 1450*time.Millisecond {
+		t.Errorf("Backoff not expected %v", e.rto)
+	}
+
+	e.reset()
+	if e.rto != 362500*time.Microsecond {
+		t.Errorf("Reset not expected %v", e.rto)
+	}
+
+	for i := 0; i < 10; i++ {
+		e.backoff()
+	}
+
+	if e.rto != maxRTO {
+		t.Errorf("Backoff not limited %v", e.rto)
+	}
+
+	e.min = minRTO
+	e.sample(time.Millisecond)
+	if e.current() != minRTO {
+		t.Errorf("RTO not limited %v", e.current())
+	}
+}
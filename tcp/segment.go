@@ -0,0 +1,226 @@
+// Package tcp implements minimal TCP from RFC793 on top of ipv4.IpSocket:
+// handshake, sliding window, retransmission with timeout from RFC6298 and
+// FIN/RST teardown. There is no congestion control, out of order segments
+// are dropped and options other than MSS are ignored. Kernel of the host
+// answers segments of userspace connections with RST unless it is told to
+// drop them.
+package tcp
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/IvMaslov/ipv4"
+)
+
+// Control flags
+const (
+	FlagFIN = 0x01
+	FlagSYN = 0x02
+	FlagRST = 0x04
+	FlagPSH = 0x08
+	FlagACK = 0x10
+	FlagURG = 0x20
+)
+
+const (
+	headerLength = 20
+
+	optionEnd = 0
+	optionNOP = 1
+	optionMSS = 2
+)
+
+var (
+	ErrTruncated   = errors.New("tcp segment is truncated")
+	ErrBadOffset   = errors.New("wrong tcp data offset")
+	ErrBadChecksum = errors.New("wrong tcp checksum")
+)
+
+// 0                   1                   2                   3
+// 0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1
+// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+// |          Source Port          |       Destination Port        |
+// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+// |                        Sequence Number                        |
+// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+// |                    Acknowledgment Number                      |
+// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+// |  Data |           |U|A|P|R|S|F|                               |
+// | Offset| Reserved  |R|C|S|S|Y|I|            Window             |
+// |       |           |G|K|H|T|N|N|                               |
+// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+// |           Checksum            |         Urgent Pointer        |
+// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+// |                    Options                    |    Padding    |
+// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+// |                             data                              |
+// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+//
+//	TCP segment from RFC793, data offset is derived from length of options
+type Segment struct {
+	SrcPort  uint16
+	DstPort  uint16
+	Seq      uint32
+	Ack      uint32
+	Flags    uint8
+	Window   uint16
+	Checksum uint16
+	Urgent   uint16
+	Options  []byte
+	Data     []byte
+}
+
+// Marshal returns wire representation of segment sent from src to dst with
+// options padded to 32 bit boundary and calculated checksum, segment is not
+// changed
+func (s *Segment) Marshal(src, dst ipv4.IPAddr) []byte {
+	buf := s.marshal(0)
+
+	binary.BigEndian.PutUint16(buf[16:18], Checksum(src, dst, buf))
+
+	return buf
+}
+
+func (s *Segment) marshal(checksum uint16) []byte {
+	hl := headerLength + (len(s.Options)+3)/4*4
+	buf := make([]byte, hl+len(s.Data))
+
+	binary.BigEndian.PutUint16(buf[0:2], s.SrcPort)
+	binary.BigEndian.PutUint16(buf[2:4], s.DstPort)
+	binary.BigEndian.PutUint32(buf[4:8], s.Seq)
+	binary.BigEndian.PutUint32(buf[8:12], s.Ack)
+	buf[12] = uint8(hl/4) << 4
+	buf[13] = s.Flags
+	binary.BigEndian.PutUint16(buf[14:16], s.Window)
+	binary.BigEndian.PutUint16(buf[16:18], checksum)
+	binary.BigEndian.PutUint16(buf[18:20], s.Urgent)
+	copy(buf[headerLength:], s.Options) // padding is zero, which is end of options
+	copy(buf[hl:], s.Data)
+
+	return buf
+}
+
+// Unmarshal parses segment from b
+func (s *Segment) Unmarshal(b []byte) error {
+	if len(b) < headerLength {
+		return fmt.Errorf("%w: got %d bytes", ErrTruncated, len(b))
+	}
+
+	hl := int(b[12]>>4) * 4
+	if hl < headerLength || hl > len(b) {
+		return fmt.Errorf("%w: %d bytes of header for %d bytes", ErrBadOffset, hl, len(b))
+	}
+
+	s.SrcPort = binary.BigEndian.Uint16(b[0:2])
+	s.DstPort = binary.BigEndian.Uint16(b[2:4])
+	s.Seq = binary.BigEndian.Uint32(b[4:8])
+	s.Ack = binary.BigEndian.Uint32(b[8:12])
+	s.Flags = b[13]
+	s.Window = binary.BigEndian.Uint16(b[14:16])
+	s.Checksum = binary.BigEndian.Uint16(b[16:18])
+	s.Urgent = binary.BigEndian.Uint16(b[18:20])
+	s.Options = b[headerLength:hl]
+	s.Data = b[hl:]
+
+	return nil
+}
+
+// VerifyChecksum reports whether checksum of segment sent from src to dst
+// is correct
+func (s *Segment) VerifyChecksum(src, dst ipv4.IPAddr) bool {
+	return Checksum(src, dst, s.marshal(s.Checksum)) == 0
+}
+
+// Has reports whether all flags are set
+func (s *Segment) Has(flags uint8) bool {
+	return s.Flags&flags == flags
+}
+
+// Len returns length of segment in sequence space, SYN and FIN occupy one
+// sequence number each
+func (s *Segment) Len() uint32 {
+	n := uint32(len(s.Data))
+
+	if s.Has(FlagSYN) {
+		n++
+	}
+
+	if s.Has(FlagFIN) {
+		n++
+	}
+
+	return n
+}
+
+// MSS returns value of Maximum Segment Size option
+func (s *Segment) MSS() (uint16, bool) {
+	for i := 0; i < len(s.Options); {
+		switch s.Options[i] {
+		case optionEnd:
+			return 0, false
+		case optionNOP:
+			i++
+			continue
+		}
+
+		if i+1 >= len(s.Options) || s.Options[i+1] < 2 {
+			return 0, false
+		}
+
+		length := int(s.Options[i+1])
+
+		if s.Options[i] == optionMSS && length == 4 && i+4 <= len(s.Options) {
+			return binary.BigEndian.Uint16(s.Options[i+2 : i+4]), true
+		}
+
+		i += length
+	}
+
+	return 0, false
+}
+
+// MSSOption returns Maximum Segment Size option sent in SYN segments
+func MSSOption(mss uint16) []byte {
+	return []byte{optionMSS, 4, byte(mss >> 8), byte(mss)}
+}
+
+// FromPacket parses TCP segment carried by packet and verifies its checksum
+func FromPacket(p *ipv4.Packet) (*Segment, error) {
+	if p.Protocol != ipv4.ProtocolTCP {
+		return nil, fmt.Errorf("packet of protocol %d is not tcp", p.Protocol)
+	}
+
+	s := &Segment{}
+
+	if err := s.Unmarshal(p.Data); err != nil {
+		return nil, err
+	}
+
+	if !s.VerifyChecksum(p.Src, p.Dst) {
+		return nil, ErrBadChecksum
+	}
+
+	return s, nil
+}
+
+// Packet wraps segment to TCP packet from src to dst
+func (s *Segment) Packet(src, dst ipv4.IPAddr) *ipv4.Packet {
+	return ipv4.New(src, dst, s.Marshal(src, dst)).WithProtocol(ipv4.ProtocolTCP)
+}
+
+// Checksum calculates checksum of TCP segment b over IPv4 pseudo header
+func Checksum(src, dst ipv4.IPAddr, b []byte) uint16 {
+	return ipv4.PseudoHeaderChecksum(src, dst, ipv4.ProtocolTCP, b)
+}
+
+// seqLT reports whether sequence number a is before b modulo 2^32
+func seqLT(a, b uint32) bool {
+	return int32(a-b) < 0
+}
+
+// seqLEQ reports whether sequence number a is before or equal to b
+func seqLEQ(a, b uint32) bool {
+	return int32(a-b) <= 0
+}
@@ -0,0 +1,180 @@
+package tcp
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/IvMaslov/ipv4"
+)
+
+func addrs() (ipv4.IPAddr, ipv4.IPAddr) {
+	src, _ := ipv4.IPFromString("192.168.0.1")
+	dst, _ := ipv4.IPFromString("192.168.0.2")
+
+	return src, dst
+}
+
+var synSegment = []byte{
+	0x03, 0xE8, 0x00, 0x50, 0, 0, 0, 1, 0, 0, 0, 0, 0x60, 0x02, 0x04, 0x00,
+	0x0E, 0x9A, 0, 0, 2, 4, 0x05, 0xB4,
+}
+
+func Test_Segment_Marshal(t *testing.T) {
+	src, dst := addrs()
+	s := &Segment{SrcPort: 1000, DstPort: 80, Seq: 1, Flags: FlagSYN, Window: 1024, Options: MSSOption(1460)}
+
+	data := s.Marshal(src, dst)
+	if !bytes.Equal(data, synSegment) {
+		t.Errorf("Marshaled not expected %v", data)
+	}
+
+	if s.Checksum != 0 {
+		t.Errorf("Segment changed %v", s)
+	}
+}
+
+func Test_Segment_Marshal_Padding(t *testing.T) {
+	src, dst := addrs()
+	s := &Segment{Flags: FlagACK, Options: []byte{optionNOP}, Data: []byte{1, 2}}
+
+	data := s.Marshal(src, dst)
+	if len(data) != 26 || data[12] != 0x60 || data[20] != optionNOP || data[24] != 1 {
+		t.Errorf("Marshaled not expected %v", data)
+	}
+}
+
+func Test_Segment_Unmarshal(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    []byte
+		expected error
+	}{
+		{
+			name:  "SYN",
+			input: synSegment,
+		},
+		{
+			name:     "Truncated",
+			input:    synSegment[:19],
+			expected: ErrTruncated,
+		},
+		{
+			name:     "Offset exceeds data",
+			input:    append([]byte{}, synSegment[:20]...),
+			expected: ErrBadOffset,
+		},
+		{
+			name: "Offset below header",
+			input: []byte{
+				0x03, 0xE8, 0x00, 0x50, 0, 0, 0, 1, 0, 0, 0, 0, 0x40, 0x02, 0x04, 0x00,
+				0x0E, 0x9A, 0, 0,
+			},
+			expected: ErrBadOffset,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := &Segment{}
+
+			err := s.Unmarshal(test.input)
+			if !errors.Is(err, test.expected) {
+				t.Fatalf("Error not expected %v", err)
+			}
+
+			if err != nil {
+				return
+			}
+
+			mss, ok := s.MSS()
+			if s.SrcPort != 1000 || s.DstPort != 80 || s.Seq != 1 || !s.Has(FlagSYN) || !ok || mss != 1460 {
+				t.Errorf("Segment not expected %v", s)
+			}
+
+			if s.Len() != 1 {
+				t.Errorf("Length not expected %d", s.Len())
+			}
+		})
+	}
+}
+
+func Test_Segment_MSS(t *testing.T) {
+	tests := []struct {
+		name     string
+		options  []byte
+		expected uint16
+		found    bool
+	}{
+		{
+			name:     "After NOP and other option",
+			options:  []byte{optionNOP, 8, 10, 0, 0, 0, 1, 0, 0, 0, 2, optionMSS, 4, 0x02, 0x18, 0},
+			expected: 536,
+			found:    true,
+		},
+		{
+			name:    "After end of options",
+			options: []byte{optionEnd, optionMSS, 4, 0x02, 0x18},
+		},
+		{
+			name:    "Zero option length",
+			options: []byte{30, 0, optionMSS, 4, 0x02, 0x18},
+		},
+		{
+			name:    "Truncated",
+			options: []byte{optionMSS, 4, 0x02},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := &Segment{Options: test.options}
+
+			mss, ok := s.MSS()
+			if ok != test.found || mss != test.expected {
+				t.Errorf("MSS not expected %d %v", mss, ok)
+			}
+		})
+	}
+}
+
+func Test_FromPacket(t *testing.T) {
+	src, dst := addrs()
+	s := &Segment{SrcPort: 1000, DstPort: 80, Seq: 7, Ack: 9, Flags: FlagACK | FlagPSH, Data: []byte("data")}
+
+	parsed, err := FromPacket(s.Packet(src, dst))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if parsed.Seq != 7 || parsed.Ack != 9 || parsed.Len() != 4 || !bytes.Equal(parsed.Data, s.Data) {
+		t.Errorf("Segment not expected %v", parsed)
+	}
+
+	if _, err := FromPacket(ipv4.New(src, src, s.Marshal(src, dst))); !errors.Is(err, ErrBadChecksum) {
+		t.Errorf("Error not expected %v", err)
+	}
+}
+
+func Test_seqLT(t *testing.T) {
+	tests := []struct {
+		a, b     uint32
+		expected bool
+	}{
+		{a: 1, b: 2, expected: true},
+		{a: 2, b: 1},
+		{a: 2, b: 2},
+		{a: 0xFFFFFFF0, b: 0x10, expected: true},
+		{a: 0x10, b: 0xFFFFFFF0},
+	}
+
+	for _, test := range tests {
+		if seqLT(test.a, test.b) != test.expected {
+			t.Errorf("Comparison of %#x and %#x not expected", test.a, test.b)
+		}
+	}
+
+	if !seqLEQ(2, 2) || seqLEQ(3, 2) {
+		t.Error("Comparison not expected")
+	}
+}
@@ -0,0 +1,442 @@
+package tcp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/IvMaslov/ipv4"
+)
+
+const (
+	defaultBacklog = 16
+
+	// dynamic ports range from RFC6335
+	ephemeralFirst = 49152
+	ephemeralLast  = 65535
+
+	headersOverhead = 40 // IPv4 and TCP headers without options
+)
+
+var (
+	ErrPortInUse  = errors.New("tcp port is in use")
+	ErrNoFreePort = errors.New("no free tcp port")
+	ErrBadAddr    = errors.New("address is not tcp ipv4 address")
+)
+
+// StackStats contains counters of segments dropped by stack
+type StackStats struct {
+	Malformed   uint64 // segments failed to unmarshal
+	BadChecksum uint64 // segments with wrong checksum
+	Resets      uint64 // segments without connection answered with RST
+}
+
+type connKey struct {
+	localPort  uint16
+	remote     ipv4.IPAddr
+	remotePort uint16
+}
+
+// Stack keeps TCP connections of socket and delivers them segments received
+// by dispatcher. Dispatcher has to be running.
+type Stack struct {
+	d      *ipv4.Dispatcher
+	remove func()
+	local  ipv4.IPAddr
+	mss    uint16
+	output func(p *ipv4.Packet) error
+
+	finTimeout atomic.Int64 // time.Duration
+
+	mu        sync.Mutex
+	conns     map[connKey]*Conn
+	listeners map[uint16]*Listener
+	next      uint16
+	closed    bool
+
+	malformed   atomic.Uint64
+	badChecksum atomic.Uint64
+	resets      atomic.Uint64
+}
+
+// NewStack creates stack sending segments through socket of d and registers
+// its handler of TCP in d. Segments are accepted only when sent to address
+// of socket.
+func NewStack(d *ipv4.Dispatcher) *Stack {
+	sock := d.Socket()
+
+	mss := defaultMSS
+	if sock.GetMTU() > headersOverhead+defaultMSS {
+		mss = sock.GetMTU() - headersOverhead
+	}
+
+	return newStack(d, sock.GetIpAddr(), uint16(mss), sock.WritePacket)
+}
+
+func newStack(d *ipv4.Dispatcher, local ipv4.IPAddr, mss uint16, output func(p *ipv4.Packet) error) *Stack {
+	s := &Stack{
+		d:         d,
+		local:     local,
+		mss:       mss,
+		output:    output,
+		conns:     make(map[connKey]*Conn),
+		listeners: make(map[uint16]*Listener),
+		next:      ephemeralFirst,
+	}
+
+	s.finTimeout.Store(int64(defaultFinTimeout))
+	s.remove = d.HandleProtocol(ipv4.ProtocolTCP, s.handle)
+
+	return s
+}
+
+// SetFinTimeout sets up time connection closed by Close waits FIN of peer
+// after own FIN is acknowledged, by default is 60 seconds as tcp_fin_timeout
+// of Linux. Connection is removed from the stack when timeout passes.
+func (s *Stack) SetFinTimeout(d time.Duration) {
+	s.finTimeout.Store(int64(d))
+}
+
+// FinTimeout returns FIN-WAIT-2 timeout set by SetFinTimeout
+func (s *Stack) FinTimeout() time.Duration {
+	return time.Duration(s.finTimeout.Load())
+}
+
+// Dial opens connection to addr, which is *Addr or *net.TCPAddr with IPv4
+// address, and waits for handshake to complete
+func (s *Stack) Dial(ctx context.Context, addr net.Addr) (*Conn, error) {
+	remote, err := toAddr(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+
+	if s.closed {
+		s.mu.Unlock()
+		return nil, net.ErrClosed
+	}
+
+	port, err := s.freePort(remote)
+	if err != nil {
+		s.mu.Unlock()
+		return nil, err
+	}
+
+	c := newConn(s, Addr{IP: s.local, Port: port}, remote, rand.Uint32())
+	s.register(c)
+
+	s.mu.Unlock()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.open(); err != nil {
+		c.terminate(err)
+		return nil, err
+	}
+
+	for c.state == StateSynSent || c.state == StateSynReceived {
+		changed := c.changed
+
+		c.mu.Unlock()
+
+		select {
+		case <-changed:
+			c.mu.Lock()
+		case <-ctx.Done():
+			c.mu.Lock()
+			c.terminate(ctx.Err())
+
+			return nil, ctx.Err()
+		}
+	}
+
+	if c.state == StateClosed {
+		return nil, c.err
+	}
+
+	return c, nil
+}
+
+// Listen creates listener accepting connections to port
+func (s *Stack) Listen(port uint16) (*Listener, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return nil, net.ErrClosed
+	}
+
+	if _, ok := s.listeners[port]; ok {
+		return nil, fmt.Errorf("%w: %d", ErrPortInUse, port)
+	}
+
+	l := &Listener{
+		stack:   s,
+		port:    port,
+		backlog: make(chan *Conn, defaultBacklog),
+		done:    make(chan struct{}),
+	}
+
+	s.listeners[port] = l
+
+	return l, nil
+}
+
+// Close removes handler of TCP, resets all connections and closes listeners
+func (s *Stack) Close() {
+	s.mu.Lock()
+
+	if s.closed {
+		s.mu.Unlock()
+		return
+	}
+
+	s.closed = true
+	s.remove()
+
+	var conns []*Conn
+	for _, c := range s.conns {
+		conns = append(conns, c)
+	}
+
+	var listeners []*Listener
+	for _, l := range s.listeners {
+		listeners = append(listeners, l)
+	}
+
+	s.mu.Unlock()
+
+	for _, l := range listeners {
+		l.Close()
+	}
+
+	for _, c := range conns {
+		c.Reset()
+	}
+}
+
+// Stats returns counters of dropped segments
+func (s *Stack) Stats() StackStats {
+	return StackStats{
+		Malformed:   s.malformed.Load(),
+		BadChecksum: s.badChecksum.Load(),
+		Resets:      s.resets.Load(),
+	}
+}
+
+// freePort must be called with s.mu locked
+func (s *Stack) freePort(remote Addr) (uint16, error) {
+	for i := 0; i <= ephemeralLast-ephemeralFirst; i++ {
+		port := s.next
+
+		if s.next == ephemeralLast {
+			s.next = ephemeralFirst
+		} else {
+			s.next++
+		}
+
+		_, listened := s.listeners[port]
+		_, used := s.conns[connKey{localPort: port, remote: remote.IP, remotePort: remote.Port}]
+
+		if !listened && !used {
+			return port, nil
+		}
+	}
+
+	return 0, ErrNoFreePort
+}
+
+// register must be called with s.mu locked
+func (s *Stack) register(c *Conn) {
+	s.conns[c.key()] = c
+}
+
+func (s *Stack) unregister(c *Conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.conns[c.key()] == c {
+		delete(s.conns, c.key())
+	}
+}
+
+func (s *Stack) unlisten(l *Listener) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.listeners[l.port] == l {
+		delete(s.listeners, l.port)
+	}
+}
+
+func (s *Stack) handle(p *ipv4.Packet) {
+	if p.Dst != s.local {
+		return
+	}
+
+	seg := &Segment{}
+
+	if err := seg.Unmarshal(p.Data); err != nil {
+		s.malformed.Add(1)
+		return
+	}
+
+	if !seg.VerifyChecksum(p.Src, p.Dst) {
+		s.badChecksum.Add(1)
+		return
+	}
+
+	key := connKey{localPort: seg.DstPort, remote: p.Src, remotePort: seg.SrcPort}
+
+	s.mu.Lock()
+	c, ok := s.conns[key]
+	l := s.listeners[seg.DstPort]
+	s.mu.Unlock()
+
+	if ok {
+		c.handle(seg)
+		return
+	}
+
+	if l != nil && seg.Flags&(FlagSYN|FlagACK|FlagRST) == FlagSYN {
+		s.accept(l, p.Src, seg)
+		return
+	}
+
+	s.refuse(p.Src, seg)
+}
+
+// accept creates passive connection in response to SYN
+func (s *Stack) accept(l *Listener, remote ipv4.IPAddr, syn *Segment) {
+	c := newConn(s, Addr{IP: s.local, Port: syn.DstPort}, Addr{IP: remote, Port: syn.SrcPort}, rand.Uint32())
+	c.listener = l
+
+	s.mu.Lock()
+
+	if _, ok := s.conns[c.key()]; ok || s.closed {
+		s.mu.Unlock()
+		return
+	}
+
+	s.register(c)
+
+	s.mu.Unlock()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.accept(syn)
+}
+
+// refuse answers segment without connection with RST from RFC793
+func (s *Stack) refuse(remote ipv4.IPAddr, seg *Segment) {
+	if seg.Has(FlagRST) {
+		return
+	}
+
+	rst := &Segment{SrcPort: seg.DstPort, DstPort: seg.SrcPort, Flags: FlagRST}
+
+	if seg.Has(FlagACK) {
+		rst.Seq = seg.Ack
+	} else {
+		rst.Ack = seg.Seq + seg.Len()
+		rst.Flags |= FlagACK
+	}
+
+	s.resets.Add(1)
+	s.send(rst, remote)
+}
+
+func (s *Stack) send(seg *Segment, dst ipv4.IPAddr) error {
+	return s.output(seg.Packet(s.local, dst))
+}
+
+func (c *Conn) key() connKey {
+	return connKey{localPort: c.local.Port, remote: c.remote.IP, remotePort: c.remote.Port}
+}
+
+var _ net.Listener = (*Listener)(nil)
+
+// Listener accepts connections to local port, it implements net.Listener
+type Listener struct {
+	stack *Stack
+	port  uint16
+
+	backlog   chan *Conn
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// Accept waits for established connection
+func (l *Listener) Accept() (net.Conn, error) {
+	select {
+	case c := <-l.backlog:
+		return c, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close stops accepting connections and resets ones not accepted yet
+func (l *Listener) Close() error {
+	l.stack.unlisten(l)
+
+	l.closeOnce.Do(func() {
+		close(l.done)
+	})
+
+	for {
+		select {
+		case c := <-l.backlog:
+			c.Reset()
+		default:
+			return nil
+		}
+	}
+}
+
+// Addr returns local address of listener
+func (l *Listener) Addr() net.Addr {
+	return &Addr{IP: l.stack.local, Port: l.port}
+}
+
+// ready queues established connection, it is reset when backlog is full
+// or listener is closed
+func (l *Listener) ready(c *Conn) {
+	select {
+	case <-l.done:
+	default:
+		select {
+		case l.backlog <- c:
+			return
+		default:
+		}
+	}
+
+	c.userClosed = true
+	c.send(FlagRST, c.sndNxt, nil)
+	c.terminate(nil)
+}
+
+func toAddr(addr net.Addr) (Addr, error) {
+	switch a := addr.(type) {
+	case *Addr:
+		return *a, nil
+	case *net.TCPAddr:
+		ip := a.IP.To4()
+		if ip == nil || a.Port <= 0 || a.Port > 65535 {
+			return Addr{}, fmt.Errorf("%w: %v", ErrBadAddr, addr)
+		}
+
+		return Addr{IP: ipv4.IPAddr(ip), Port: uint16(a.Port)}, nil
+	}
+
+	return Addr{}, fmt.Errorf("%w: %v", ErrBadAddr, addr)
+}
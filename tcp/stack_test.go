@@ -0,0 +1,244 @@
+package tcp
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/IvMaslov/ipv4"
+)
+
+// link delivers packets between stacks as they go through wire
+type link struct {
+	dispatchers map[ipv4.IPAddr]*ipv4.Dispatcher
+	drop        func(seg *Segment) bool // called from delivery goroutine only
+	packets     chan []byte
+	done        chan struct{}
+}
+
+func newLink(t *testing.T) *link {
+	l := &link{
+		dispatchers: make(map[ipv4.IPAddr]*ipv4.Dispatcher),
+		packets:     make(chan []byte, 1024),
+		done:        make(chan struct{}),
+	}
+
+	t.Cleanup(func() {
+		close(l.done)
+	})
+
+	return l
+}
+
+// stack must be called before run
+func (l *link) stack(addr ipv4.IPAddr) *Stack {
+	d := ipv4.NewDispatcher(nil)
+	l.dispatchers[addr] = d
+
+	return newStack(d, addr, 1460, l.send)
+}
+
+func (l *link) send(p *ipv4.Packet) error {
+	select {
+	case l.packets <- p.Marshal():
+	case <-l.done:
+	}
+
+	return nil
+}
+
+func (l *link) run() {
+	for {
+		select {
+		case <-l.done:
+			return
+		case raw := <-l.packets:
+			p := &ipv4.Packet{}
+			if err := p.Unmarshal(raw); err != nil {
+				continue
+			}
+
+			if l.drop != nil {
+				if seg, err := FromPacket(p); err == nil && l.drop(seg) {
+					continue
+				}
+			}
+
+			if d, ok := l.dispatchers[p.Dst]; ok {
+				d.Dispatch(p)
+			}
+		}
+	}
+}
+
+func connect(t *testing.T, l *link) (*Conn, *Conn) {
+	clientIP, serverIP := addrs()
+
+	client := l.stack(clientIP)
+	server := l.stack(serverIP)
+
+	go l.run()
+
+	listener, err := server.Listen(80)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	c, err := client.Dial(ctx, &Addr{IP: serverIP, Port: 80})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	accepted, err := listener.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return c, accepted.(*Conn)
+}
+
+func waitState(t *testing.T, c *Conn, expected State) {
+	for i := 0; i < 100 && c.State() != expected; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if c.State() != expected {
+		t.Errorf("State not expected %v", c.State())
+	}
+}
+
+func Test_Stack_Transfer(t *testing.T) {
+	client, server := connect(t, newLink(t))
+
+	if client.State() != StateEstablished || server.State() != StateEstablished {
+		t.Fatalf("States not expected %v %v", client.State(), server.State())
+	}
+
+	if _, err := client.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := make([]byte, 5)
+	if _, err := io.ReadFull(server, buf); err != nil || string(buf) != "hello" {
+		t.Fatalf("Read not expected %q %v", buf, err)
+	}
+
+	// more than both buffers to exercise window
+	data := bytes.Repeat([]byte("0123456789abcdef"), 3*bufferSize/16)
+
+	go func() {
+		server.Write(data)
+		server.Close()
+	}()
+
+	received, err := io.ReadAll(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(received, data) {
+		t.Fatalf("Received %d bytes not expected", len(received))
+	}
+
+	waitState(t, server, StateFinWait2)
+
+	client.Close()
+
+	waitState(t, client, StateClosed)
+	waitState(t, server, StateTimeWait)
+}
+
+func Test_Stack_Dial_Refused(t *testing.T) {
+	l := newLink(t)
+	clientIP, serverIP := addrs()
+
+	client := l.stack(clientIP)
+	l.stack(serverIP)
+
+	go l.run()
+
+	_, err := client.Dial(context.Background(), &Addr{IP: serverIP, Port: 80})
+	if !errors.Is(err, ErrConnRefused) {
+		t.Errorf("Error not expected %v", err)
+	}
+}
+
+func Test_Conn_Retransmission(t *testing.T) {
+	l := newLink(t)
+
+	dropped := false
+	l.drop = func(seg *Segment) bool {
+		if !dropped && len(seg.Data) > 0 {
+			dropped = true
+			return true
+		}
+
+		return false
+	}
+
+	client, server := connect(t, l)
+
+	if _, err := client.Write([]byte("lost")); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(server, buf); err != nil || string(buf) != "lost" {
+		t.Fatalf("Read not expected %q %v", buf, err)
+	}
+}
+
+func Test_Conn_Reset(t *testing.T) {
+	client, server := connect(t, newLink(t))
+
+	client.Reset()
+
+	if _, err := server.Read(make([]byte, 1)); !errors.Is(err, ErrConnReset) {
+		t.Errorf("Error not expected %v", err)
+	}
+
+	if _, err := client.Write([]byte{1}); err == nil {
+		t.Error("Write to reset connection succeeded")
+	}
+}
+
+func Test_Conn_SetReadDeadline(t *testing.T) {
+	client, _ := connect(t, newLink(t))
+
+	client.SetReadDeadline(time.Now().Add(10 * time.Millisecond))
+
+	if _, err := client.Read(make([]byte, 1)); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("Error not expected %v", err)
+	}
+}
+
+func Test_Conn_FinWait2Timeout(t *testing.T) {
+	client, server := connect(t, newLink(t))
+	client.stack.SetFinTimeout(50 * time.Millisecond)
+
+	client.Close()
+
+	// server acknowledges FIN, but never closes its side
+	waitState(t, server, StateCloseWait)
+	waitState(t, client, StateClosed)
+
+	client.stack.mu.Lock()
+	registered := len(client.stack.conns)
+	client.stack.mu.Unlock()
+
+	if registered != 0 {
+		t.Errorf("Connections not expected %d", registered)
+	}
+}
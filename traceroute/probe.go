@@ -0,0 +1,183 @@
+package traceroute
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/IvMaslov/ipv4"
+	"github.com/IvMaslov/ipv4/icmp"
+	"github.com/IvMaslov/ipv4/tcp"
+	"github.com/IvMaslov/ipv4/udp"
+)
+
+// Style is kind of probes sent by tracer
+type Style int
+
+const (
+	// StyleUDP sends UDP datagrams to unlikely used ports, destination
+	// answers with Port Unreachable
+	StyleUDP Style = iota
+	// StyleICMP sends echo requests, destination answers with echo reply
+	StyleICMP
+	// StyleTCP sends TCP segments with SYN flag, destination answers with
+	// SYN-ACK, which is followed by RST of tracer, or RST
+	StyleTCP
+)
+
+const tcpWindow = 1024
+
+func (s Style) String() string {
+	switch s {
+	case StyleUDP:
+		return "udp"
+	case StyleICMP:
+		return "icmp"
+	case StyleTCP:
+		return "tcp"
+	}
+
+	return fmt.Sprintf("style(%d)", int(s))
+}
+
+func (s Style) protocol() uint8 {
+	switch s {
+	case StyleICMP:
+		return ipv4.ProtocolICMP
+	case StyleTCP:
+		return ipv4.ProtocolTCP
+	}
+
+	return ipv4.ProtocolUDP
+}
+
+// probeKey identifies probe by fields preserved in quoted header of ICMP
+// errors: UDP destination port, echo sequence number or TCP sequence number
+type probeKey struct {
+	protocol uint8
+	dst      ipv4.IPAddr
+	field    uint32
+}
+
+// reply is response matched to probe
+type reply struct {
+	from  ipv4.IPAddr
+	typ   uint8
+	code  uint8
+	final bool // response of destination itself
+}
+
+func newProbeKey(style Style, dst ipv4.IPAddr, port, seq uint16) probeKey {
+	key := probeKey{protocol: style.protocol(), dst: dst, field: uint32(seq)}
+
+	if style == StyleUDP {
+		key.field = uint32(port + seq)
+	}
+
+	return key
+}
+
+// encodeProbe returns payload of probe with sequence number seq, id is
+// source port of UDP and TCP probes and identifier of echo requests
+func encodeProbe(style Style, src, dst ipv4.IPAddr, id, port, seq uint16, size int) []byte {
+	switch style {
+	case StyleICMP:
+		echo := &icmp.Echo{ID: id, Seq: seq, Data: make([]byte, size)}
+		return echo.Marshal()
+	case StyleTCP:
+		segment := &tcp.Segment{SrcPort: id, DstPort: port, Seq: uint32(seq), Flags: tcp.FlagSYN, Window: tcpWindow}
+		return segment.Marshal(src, dst)
+	}
+
+	datagram := &udp.Datagram{SrcPort: id, DstPort: port + seq, Data: make([]byte, size)}
+
+	return datagram.Marshal(src, dst)
+}
+
+// matchReply finds probe of tracer with identifier id the packet responds to
+func matchReply(p *ipv4.Packet, id uint16) (probeKey, reply, bool) {
+	switch p.Protocol {
+	case ipv4.ProtocolICMP:
+		return matchICMP(p, id)
+	case ipv4.ProtocolTCP:
+		return matchTCP(p, id)
+	}
+
+	return probeKey{}, reply{}, false
+}
+
+func matchICMP(p *ipv4.Packet, id uint16) (probeKey, reply, bool) {
+	m, err := icmp.FromPacket(p)
+	if err != nil {
+		return probeKey{}, reply{}, false
+	}
+
+	r := reply{from: p.Src, typ: m.Type, code: m.Code}
+
+	if m.Type == icmp.TypeEchoReply {
+		echo, err := icmp.EchoFromMessage(m)
+		if err != nil || echo.ID != id {
+			return probeKey{}, reply{}, false
+		}
+
+		r.final = true
+
+		return probeKey{protocol: ipv4.ProtocolICMP, dst: p.Src, field: uint32(echo.Seq)}, r, true
+	}
+
+	if m.Type != icmp.TypeTimeExceeded && m.Type != icmp.TypeDestinationUnreachable {
+		return probeKey{}, reply{}, false
+	}
+
+	e, err := icmp.ErrorFromMessage(m)
+	if err != nil {
+		return probeKey{}, reply{}, false
+	}
+
+	quoted, err := e.Quoted()
+	if err != nil || len(quoted.Data) < icmp.QuotePayloadRFC792 {
+		return probeKey{}, reply{}, false
+	}
+
+	key := probeKey{protocol: quoted.Protocol, dst: quoted.Dst}
+	data := quoted.Data
+
+	switch quoted.Protocol {
+	case ipv4.ProtocolUDP:
+		if binary.BigEndian.Uint16(data[0:2]) != id {
+			return probeKey{}, reply{}, false
+		}
+
+		key.field = uint32(binary.BigEndian.Uint16(data[2:4]))
+	case ipv4.ProtocolICMP:
+		if data[0] != icmp.TypeEcho || binary.BigEndian.Uint16(data[4:6]) != id {
+			return probeKey{}, reply{}, false
+		}
+
+		key.field = uint32(binary.BigEndian.Uint16(data[6:8]))
+	case ipv4.ProtocolTCP:
+		if binary.BigEndian.Uint16(data[0:2]) != id {
+			return probeKey{}, reply{}, false
+		}
+
+		key.field = binary.BigEndian.Uint32(data[4:8])
+	default:
+		return probeKey{}, reply{}, false
+	}
+
+	r.final = m.Type == icmp.TypeDestinationUnreachable && p.Src == quoted.Dst
+
+	return key, r, true
+}
+
+func matchTCP(p *ipv4.Packet, id uint16) (probeKey, reply, bool) {
+	segment, err := tcp.FromPacket(p)
+	if err != nil || segment.DstPort != id || !segment.Has(tcp.FlagACK) {
+		return probeKey{}, reply{}, false
+	}
+
+	if !segment.Has(tcp.FlagSYN) && !segment.Has(tcp.FlagRST) {
+		return probeKey{}, reply{}, false
+	}
+
+	return probeKey{protocol: ipv4.ProtocolTCP, dst: p.Src, field: segment.Ack - 1}, reply{from: p.Src, final: true}, true
+}
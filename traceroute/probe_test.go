@@ -0,0 +1,365 @@
+package traceroute
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/IvMaslov/ipv4"
+	"github.com/IvMaslov/ipv4/icmp"
+	"github.com/IvMaslov/ipv4/tcp"
+)
+
+func Test_matchReply(t *testing.T) {
+	src, _ := ipv4.IPFromString("192.168.0.2")
+	dst, _ := ipv4.IPFromString("8.8.8.8")
+	router, _ := ipv4.IPFromString("10.0.0.1")
+
+	const id, port, seq = 40000, 33434, 7
+
+	tests := []struct {
+		name    string
+		style   Style
+		reply   func(probe *ipv4.Packet) *ipv4.Packet
+		from    ipv4.IPAddr
+		final   bool
+		noMatch bool
+	}{
+		{
+			name:  "UDP time exceeded",
+			style: StyleUDP,
+			reply: func(probe *ipv4.Packet) *ipv4.Packet {
+				return icmp.NewTimeExceeded(icmp.CodeTTLExceeded, probe).Message().Packet(router, src)
+			},
+			from: router,
+		},
+		{
+			name:  "UDP port unreachable",
+			style: StyleUDP,
+			reply: func(probe *ipv4.Packet) *ipv4.Packet {
+				return icmp.NewDestinationUnreachable(icmp.CodePortUnreachable, probe).Message().Packet(dst, src)
+			},
+			from:  dst,
+			final: true,
+		},
+		{
+			name:  "UDP host unreachable",
+			style: StyleUDP,
+			reply: func(probe *ipv4.Packet) *ipv4.Packet {
+				return icmp.NewDestinationUnreachable(icmp.CodeHostUnreachable, probe).Message().Packet(router, src)
+			},
+			from: router,
+		},
+		{
+			name:  "ICMP time exceeded",
+			style: StyleICMP,
+			reply: func(probe *ipv4.Packet) *ipv4.Packet {
+				return icmp.NewTimeExceeded(icmp.CodeTTLExceeded, probe).Message().Packet(router, src)
+			},
+			from: router,
+		},
+		{
+			name:  "ICMP echo reply",
+			style: StyleICMP,
+			reply: func(probe *ipv4.Packet) *ipv4.Packet {
+				echo := &icmp.Echo{}
+				if err := echo.Unmarshal(probe.Data); err != nil {
+					t.Fatal(err)
+				}
+
+				return echo.ReplyTo().Message().Packet(dst, src)
+			},
+			from:  dst,
+			final: true,
+		},
+		{
+			name:  "TCP time exceeded",
+			style: StyleTCP,
+			reply: func(probe *ipv4.Packet) *ipv4.Packet {
+				return icmp.NewTimeExceeded(icmp.CodeTTLExceeded, probe).Message().Packet(router, src)
+			},
+			from: router,
+		},
+		{
+			name:  "TCP SYN-ACK",
+			style: StyleTCP,
+			reply: func(probe *ipv4.Packet) *ipv4.Packet {
+				return tcpReply(probe, dst, src, tcp.FlagSYN|tcp.FlagACK)
+			},
+			from:  dst,
+			final: true,
+		},
+		{
+			name:  "TCP RST",
+			style: StyleTCP,
+			reply: func(probe *ipv4.Packet) *ipv4.Packet {
+				return tcpReply(probe, dst, src, tcp.FlagRST|tcp.FlagACK)
+			},
+			from:  dst,
+			final: true,
+		},
+		{
+			name:  "Other tracer",
+			style: StyleUDP,
+			reply: func(probe *ipv4.Packet) *ipv4.Packet {
+				other := ipv4.New(src, dst, encodeProbe(StyleUDP, src, dst, id+1, port, seq, 8)).WithProtocol(ipv4.ProtocolUDP)
+				return icmp.NewTimeExceeded(icmp.CodeTTLExceeded, other).Message().Packet(router, src)
+			},
+			noMatch: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			data := encodeProbe(test.style, src, dst, id, port, seq, 8)
+			probe := ipv4.New(src, dst, data).WithProtocol(test.style.protocol())
+
+			key, r, ok := matchReply(test.reply(probe), id)
+			if ok == test.noMatch {
+				t.Fatalf("Match not expected %v", ok)
+			}
+
+			if test.noMatch {
+				return
+			}
+
+			if key != newProbeKey(test.style, dst, port, seq) {
+				t.Errorf("Key not expected %v", key)
+			}
+
+			if r.from != test.from || r.final != test.final {
+				t.Errorf("Reply not expected %v", r)
+			}
+		})
+	}
+}
+
+func Test_encodeProbe(t *testing.T) {
+	src, _ := ipv4.IPFromString("192.168.0.2")
+	dst, _ := ipv4.IPFromString("8.8.8.8")
+
+	udp := encodeProbe(StyleUDP, src, dst, 40000, 33434, 3, 16)
+	if len(udp) != 24 || binary.BigEndian.Uint16(udp[2:4]) != 33437 {
+		t.Errorf("UDP probe not expected %v", udp)
+	}
+
+	if ipv4.PseudoHeaderChecksum(src, dst, ipv4.ProtocolUDP, udp) != 0 {
+		t.Error("UDP checksum not verified")
+	}
+
+	segment, err := tcp.FromPacket(ipv4.New(src, dst, encodeProbe(StyleTCP, src, dst, 40000, 80, 3, 16)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if segment.SrcPort != 40000 || segment.DstPort != 80 || segment.Seq != 3 || segment.Flags != tcp.FlagSYN {
+		t.Errorf("TCP probe not expected %v", segment)
+	}
+}
+
+func Test_Tracer_handle(t *testing.T) {
+	d := ipv4.NewDispatcher(nil)
+	tr := NewTracer(d)
+	defer tr.Close()
+
+	src, _ := ipv4.IPFromString("192.168.0.2")
+	dst, _ := ipv4.IPFromString("8.8.8.8")
+	router, _ := ipv4.IPFromString("10.0.0.1")
+
+	seq, replies, err := tr.register(StyleUDP, dst, defaultUDPPort)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := encodeProbe(StyleUDP, src, dst, tr.ID(), defaultUDPPort, seq, 8)
+	probe := ipv4.New(src, dst, data).WithProtocol(ipv4.ProtocolUDP)
+
+	d.Dispatch(icmp.NewTimeExceeded(icmp.CodeTTLExceeded, probe).Message().Packet(router, src))
+
+	select {
+	case r := <-replies:
+		if r.from != router || r.typ != icmp.TypeTimeExceeded {
+			t.Errorf("Reply not expected %v", r)
+		}
+	default:
+		t.Fatal("Reply not matched")
+	}
+}
+
+// packetRecorder keeps packets written by tracer
+type packetRecorder struct {
+	packets []*ipv4.Packet
+}
+
+func (r *packetRecorder) GetIpAddr() ipv4.IPAddr {
+	return ipv4.IPAddr{192, 168, 0, 2}
+}
+
+func (r *packetRecorder) WritePacket(p *ipv4.Packet) error {
+	r.packets = append(r.packets, p)
+	return nil
+}
+
+func Test_Tracer_handle_Reset(t *testing.T) {
+	src, _ := ipv4.IPFromString("192.168.0.2")
+	dst, _ := ipv4.IPFromString("8.8.8.8")
+
+	tests := []struct {
+		name  string
+		flags uint8
+		reset bool
+	}{
+		{name: "SYN-ACK", flags: tcp.FlagSYN | tcp.FlagACK, reset: true},
+		{name: "RST", flags: tcp.FlagRST | tcp.FlagACK},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			d := ipv4.NewDispatcher(nil)
+			r := &packetRecorder{}
+			tr := newTracer(d, r)
+			defer tr.Close()
+
+			seq, replies, err := tr.register(StyleTCP, dst, defaultTCPPort)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			probe := ipv4.New(src, dst, encodeProbe(StyleTCP, src, dst, tr.ID(), defaultTCPPort, seq, 0)).WithProtocol(ipv4.ProtocolTCP)
+			d.Dispatch(tcpReply(probe, dst, src, test.flags))
+
+			if len(replies) != 1 {
+				t.Fatal("Reply not matched")
+			}
+
+			if !test.reset {
+				if len(r.packets) != 0 {
+					t.Errorf("Packets not expected %v", r.packets)
+				}
+				return
+			}
+
+			if len(r.packets) != 1 || r.packets[0].Src != src || r.packets[0].Dst != dst {
+				t.Fatalf("Packets not expected %v", r.packets)
+			}
+
+			rst, err := tcp.FromPacket(r.packets[0])
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if rst.Flags != tcp.FlagRST || rst.SrcPort != tr.ID() || rst.DstPort != defaultTCPPort || rst.Seq != uint32(seq)+1 {
+				t.Errorf("Reset not expected %v", rst)
+			}
+
+			if !rst.VerifyChecksum(src, dst) {
+				t.Error("Reset checksum not verified")
+			}
+		})
+	}
+}
+
+// fakeSocket answers probes by dispatching replies of path through routers
+// 10.0.0.1 and 10.0.0.2 to destination, the second router is silent
+type fakeSocket struct {
+	t     *testing.T
+	d     *ipv4.Dispatcher
+	local ipv4.IPAddr
+}
+
+func (s *fakeSocket) GetIpAddr() ipv4.IPAddr {
+	return s.local
+}
+
+func (s *fakeSocket) WritePacket(p *ipv4.Packet) error {
+	switch p.TTL {
+	case 1:
+		s.d.Dispatch(icmp.NewTimeExceeded(icmp.CodeTTLExceeded, p).Message().Packet(ipv4.IPAddr{10, 0, 0, 1}, p.Src))
+	case 2:
+	default:
+		echo := &icmp.Echo{}
+		if err := echo.Unmarshal(p.Data); err != nil {
+			s.t.Fatal(err)
+		}
+
+		s.d.Dispatch(echo.ReplyTo().Message().Packet(p.Dst, p.Src))
+	}
+
+	return nil
+}
+
+func Test_Tracer_Trace(t *testing.T) {
+	src, _ := ipv4.IPFromString("192.168.0.2")
+	dst, _ := ipv4.IPFromString("8.8.8.8")
+
+	d := ipv4.NewDispatcher(nil)
+	tr := newTracer(d, &fakeSocket{t: t, d: d, local: src})
+	defer tr.Close()
+
+	result, err := tr.Trace(context.Background(), dst, Options{Style: StyleICMP, Probes: 2, Timeout: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !result.Reached || len(result.Hops) != 3 {
+		t.Fatalf("Result not expected %v", result)
+	}
+
+	tests := []struct {
+		ttl      uint8
+		received bool
+		from     ipv4.IPAddr
+		typ      uint8
+		reached  bool
+	}{
+		{ttl: 1, received: true, from: ipv4.IPAddr{10, 0, 0, 1}, typ: icmp.TypeTimeExceeded},
+		{ttl: 2},
+		{ttl: 3, received: true, from: dst, typ: icmp.TypeEchoReply, reached: true},
+	}
+
+	for i, test := range tests {
+		hop := result.Hops[i]
+
+		if hop.TTL != test.ttl || len(hop.Probes) != 2 {
+			t.Fatalf("Hop not expected %v", hop)
+		}
+
+		for _, probe := range hop.Probes {
+			if probe.Received != test.received || probe.From != test.from || probe.Type != test.typ || probe.Reached != test.reached {
+				t.Errorf("Probe of hop %d not expected %v", test.ttl, probe)
+			}
+		}
+	}
+
+	tr.Close()
+
+	if _, err := tr.Trace(context.Background(), dst, Options{}); !errors.Is(err, ErrTracerClosed) {
+		t.Errorf("Error not expected %v", err)
+	}
+}
+
+func Test_Hop_Addrs(t *testing.T) {
+	a, _ := ipv4.IPFromString("10.0.0.1")
+	b, _ := ipv4.IPFromString("10.0.0.2")
+
+	hop := Hop{TTL: 1, Probes: []Probe{
+		{Received: true, From: a},
+		{},
+		{Received: true, From: b},
+		{Received: true, From: a},
+	}}
+
+	addrs := hop.Addrs()
+	if len(addrs) != 2 || addrs[0] != a || addrs[1] != b {
+		t.Errorf("Addrs not expected %v", addrs)
+	}
+}
+
+func tcpReply(probe *ipv4.Packet, from, to ipv4.IPAddr, flags uint8) *ipv4.Packet {
+	syn, _ := tcp.FromPacket(probe)
+	segment := &tcp.Segment{SrcPort: syn.DstPort, DstPort: syn.SrcPort, Ack: syn.Seq + 1, Flags: flags}
+
+	return segment.Packet(from, to)
+}
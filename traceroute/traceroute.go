@@ -0,0 +1,333 @@
+// Package traceroute discovers path to destination sending probes with
+// increasing TTL through ipv4 socket and matching ICMP Time Exceeded
+// messages returned by routers on the way
+package traceroute
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"github.com/IvMaslov/ipv4"
+	"github.com/IvMaslov/ipv4/icmp"
+	"github.com/IvMaslov/ipv4/tcp"
+)
+
+const (
+	defaultFirstHop = 1
+	defaultMaxHops  = 30
+	defaultProbes   = 3
+	defaultTimeout  = 3 * time.Second
+	defaultUDPPort  = 33434
+	defaultTCPPort  = 80
+	defaultSize     = 32
+)
+
+// ErrTracerClosed is returned by Trace after Close
+var ErrTracerClosed = errors.New("tracer is closed")
+
+// Options describes probes of Trace, zero fields are replaced by defaults:
+// 3 UDP probes per hop for hops from 1 to 30 waiting reply for 3 seconds
+type Options struct {
+	Style    Style
+	FirstHop uint8
+	MaxHops  uint8
+	Probes   int           // probes per hop
+	Timeout  time.Duration // time to wait reply of each probe
+	Port     uint16        // base destination port of UDP probes (33434) or port of TCP probes (80)
+	Size     int           // bytes of UDP or echo data
+}
+
+// Probe is outcome of single probe
+type Probe struct {
+	Received bool
+	From     ipv4.IPAddr
+	RTT      time.Duration
+	Type     uint8 // ICMP type of reply, zero for TCP reply
+	Code     uint8 // ICMP code of reply
+	Reached  bool  // reply is sent by destination
+}
+
+// Hop contains probes sent with the same TTL
+type Hop struct {
+	TTL    uint8
+	Probes []Probe
+}
+
+// Result contains hops in order of TTL
+type Result struct {
+	Dst     ipv4.IPAddr
+	Hops    []Hop
+	Reached bool
+}
+
+// socket sends probes, *ipv4.IpSocket implements it
+type socket interface {
+	GetIpAddr() ipv4.IPAddr
+	WritePacket(p *ipv4.Packet) error
+}
+
+type probeReply struct {
+	reply
+	at time.Time
+}
+
+// Tracer sends probes through dispatcher socket and matches replies by
+// quoted header of ICMP errors. Dispatcher has to be running and socket must
+// not be bound to protocol other than of replies.
+type Tracer struct {
+	d      *ipv4.Dispatcher
+	sock   socket
+	id     uint16
+	remove []func()
+
+	mu      sync.Mutex
+	seq     uint16
+	pending map[probeKey]chan probeReply
+	closed  bool
+}
+
+// NewTracer creates tracer with random identifier, used as source port of
+// UDP and TCP probes, and registers its handlers of ICMP and TCP in d
+func NewTracer(d *ipv4.Dispatcher) *Tracer {
+	return newTracer(d, d.Socket())
+}
+
+func newTracer(d *ipv4.Dispatcher, sock socket) *Tracer {
+	t := &Tracer{
+		d:       d,
+		sock:    sock,
+		id:      uint16(rand.IntN(1<<15)) | 1<<15, // dynamic ports range
+		pending: make(map[probeKey]chan probeReply),
+	}
+
+	t.remove = []func(){
+		d.HandleProtocol(ipv4.ProtocolICMP, t.handle),
+		d.HandleProtocol(ipv4.ProtocolTCP, t.handle),
+	}
+
+	return t
+}
+
+// ID returns identifier of probes
+func (t *Tracer) ID() uint16 {
+	return t.id
+}
+
+// Close removes handlers of replies
+func (t *Tracer) Close() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if !t.closed {
+		t.closed = true
+
+		for _, remove := range t.remove {
+			remove()
+		}
+	}
+}
+
+// Trace sends probes to dst with TTL from opts.FirstHop until destination
+// replies, reports it is unreachable or opts.MaxHops is reached. Probes
+// without reply are reported as not received, error is returned only when
+// probe can not be sent or ctx is done.
+func (t *Tracer) Trace(ctx context.Context, dst ipv4.IPAddr, opts Options) (*Result, error) {
+	opts = withDefaults(opts)
+	result := &Result{Dst: dst}
+
+	for ttl := int(opts.FirstHop); ttl <= int(opts.MaxHops); ttl++ {
+		hop := Hop{TTL: uint8(ttl)}
+		stop := false
+
+		for i := 0; i < opts.Probes; i++ {
+			probe, err := t.probe(ctx, dst, uint8(ttl), opts)
+			if err != nil {
+				result.Hops = append(result.Hops, hop)
+				return result, err
+			}
+
+			hop.Probes = append(hop.Probes, probe)
+
+			if probe.Reached {
+				result.Reached = true
+			}
+
+			if probe.Type == icmp.TypeDestinationUnreachable {
+				stop = true
+			}
+		}
+
+		result.Hops = append(result.Hops, hop)
+
+		if result.Reached || stop {
+			break
+		}
+	}
+
+	return result, nil
+}
+
+func (t *Tracer) probe(ctx context.Context, dst ipv4.IPAddr, ttl uint8, opts Options) (Probe, error) {
+	sock := t.sock
+	src := sock.GetIpAddr()
+
+	seq, replies, err := t.register(opts.Style, dst, opts.Port)
+	if err != nil {
+		return Probe{}, err
+	}
+	defer t.unregister(newProbeKey(opts.Style, dst, opts.Port, seq))
+
+	data := encodeProbe(opts.Style, src, dst, t.id, opts.Port, seq, opts.Size)
+
+	packet := ipv4.New(src, dst, data).WithProtocol(opts.Style.protocol())
+	packet.TTL = ttl
+
+	result := Probe{}
+	sent := time.Now()
+
+	if err := sock.WritePacket(packet); err != nil {
+		return result, err
+	}
+
+	timer := time.NewTimer(opts.Timeout)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return result, ctx.Err()
+	case <-timer.C:
+		return result, nil
+	case r := <-replies:
+		result.Received = true
+		result.From = r.from
+		result.RTT = r.at.Sub(sent)
+		result.Type = r.typ
+		result.Code = r.code
+		result.Reached = r.final
+
+		return result, nil
+	}
+}
+
+func (t *Tracer) register(style Style, dst ipv4.IPAddr, port uint16) (uint16, chan probeReply, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.closed {
+		return 0, nil, ErrTracerClosed
+	}
+
+	t.seq++
+
+	replies := make(chan probeReply, 1)
+	t.pending[newProbeKey(style, dst, port, t.seq)] = replies
+
+	return t.seq, replies, nil
+}
+
+func (t *Tracer) unregister(key probeKey) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	delete(t.pending, key)
+}
+
+func (t *Tracer) handle(packet *ipv4.Packet) {
+	at := time.Now()
+
+	key, r, ok := matchReply(packet, t.id)
+	if !ok {
+		return
+	}
+
+	if packet.Protocol == ipv4.ProtocolTCP {
+		t.reset(packet)
+	}
+
+	t.mu.Lock()
+	replies, ok := t.pending[key]
+	t.mu.Unlock()
+
+	if !ok {
+		return
+	}
+
+	select {
+	case replies <- probeReply{reply: r, at: at}:
+	default: // duplicate reply
+	}
+}
+
+// reset aborts connection half-opened on destination by probe answered with
+// SYN-ACK, late replies are reset too
+func (t *Tracer) reset(packet *ipv4.Packet) {
+	segment, err := tcp.FromPacket(packet)
+	if err != nil || !segment.Has(tcp.FlagSYN) {
+		return
+	}
+
+	rst := &tcp.Segment{SrcPort: segment.DstPort, DstPort: segment.SrcPort, Seq: segment.Ack, Flags: tcp.FlagRST}
+
+	t.sock.WritePacket(rst.Packet(packet.Dst, packet.Src)) // probe is answered even if reset is lost
+}
+
+// Addrs returns distinct addresses replied to probes of hop
+func (h Hop) Addrs() []ipv4.IPAddr {
+	var addrs []ipv4.IPAddr
+
+	for _, probe := range h.Probes {
+		if !probe.Received {
+			continue
+		}
+
+		seen := false
+
+		for _, addr := range addrs {
+			if addr == probe.From {
+				seen = true
+				break
+			}
+		}
+
+		if !seen {
+			addrs = append(addrs, probe.From)
+		}
+	}
+
+	return addrs
+}
+
+func withDefaults(opts Options) Options {
+	if opts.FirstHop == 0 {
+		opts.FirstHop = defaultFirstHop
+	}
+
+	if opts.MaxHops == 0 {
+		opts.MaxHops = defaultMaxHops
+	}
+
+	if opts.Probes <= 0 {
+		opts.Probes = defaultProbes
+	}
+
+	if opts.Timeout <= 0 {
+		opts.Timeout = defaultTimeout
+	}
+
+	if opts.Port == 0 {
+		opts.Port = defaultUDPPort
+
+		if opts.Style == StyleTCP {
+			opts.Port = defaultTCPPort
+		}
+	}
+
+	if opts.Size <= 0 {
+		opts.Size = defaultSize
+	}
+
+	return opts
+}
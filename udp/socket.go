@@ -0,0 +1,380 @@
+package udp
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/IvMaslov/ipv4"
+)
+
+const (
+	defaultQueueLength = 64
+
+	// dynamic ports range from RFC6335
+	ephemeralFirst = 49152
+	ephemeralLast  = 65535
+
+	maxDataLength = 65535 - headerLength - 20
+)
+
+var (
+	ErrPortInUse  = errors.New("udp port is in use")
+	ErrNoFreePort = errors.New("no free udp port")
+	ErrBadAddr    = errors.New("address is not udp ipv4 address")
+)
+
+// Addr is address of UDP socket, it implements net.Addr
+type Addr struct {
+	IP   ipv4.IPAddr
+	Port uint16
+}
+
+// Network returns name of network
+func (a *Addr) Network() string {
+	return "udp"
+}
+
+func (a *Addr) String() string {
+	return net.JoinHostPort(a.IP.String(), strconv.Itoa(int(a.Port)))
+}
+
+// MuxStats contains counters of datagrams dropped by mux
+type MuxStats struct {
+	Malformed   uint64 // datagrams failed to unmarshal
+	BadChecksum uint64 // datagrams with wrong checksum
+	NotLocal    uint64 // datagrams addressed to other host
+	NoPort      uint64 // datagrams to port without socket
+	QueueFull   uint64 // datagrams dropped because socket queue is full
+}
+
+// Mux delivers UDP datagrams received by dispatcher to sockets bound to
+// their destination ports. Only datagrams sent to address of interface,
+// limited or directed broadcast or multicast are delivered. Dispatcher has to
+// be running.
+type Mux struct {
+	d           *ipv4.Dispatcher
+	local       ipv4.IPAddr
+	isBroadcast func(dst ipv4.IPAddr) bool
+	remove      func()
+
+	mu      sync.Mutex
+	sockets map[uint16]*UDPSocket
+	next    uint16
+	closed  bool
+
+	malformed   atomic.Uint64
+	badChecksum atomic.Uint64
+	notLocal    atomic.Uint64
+	noPort      atomic.Uint64
+	queueFull   atomic.Uint64
+}
+
+// NewMux creates mux and registers its handler of UDP in d
+func NewMux(d *ipv4.Dispatcher) *Mux {
+	sock := d.Socket()
+
+	return newMux(d, sock.GetIpAddr(), sock.IsBroadcast)
+}
+
+func newMux(d *ipv4.Dispatcher, local ipv4.IPAddr, isBroadcast func(dst ipv4.IPAddr) bool) *Mux {
+	m := &Mux{
+		d:           d,
+		local:       local,
+		isBroadcast: isBroadcast,
+		sockets:     make(map[uint16]*UDPSocket),
+		next:        ephemeralFirst,
+	}
+
+	m.remove = d.HandleProtocol(ipv4.ProtocolUDP, m.handle)
+
+	return m
+}
+
+// Bind creates socket receiving datagrams sent to port, zero port is replaced
+// by free port from dynamic range
+func (m *Mux) Bind(port uint16) (*UDPSocket, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed {
+		return nil, net.ErrClosed
+	}
+
+	if port == 0 {
+		free, err := m.freePort()
+		if err != nil {
+			return nil, err
+		}
+
+		port = free
+	}
+
+	if _, ok := m.sockets[port]; ok {
+		return nil, fmt.Errorf("%w: %d", ErrPortInUse, port)
+	}
+
+	s := &UDPSocket{
+		mux:      m,
+		port:     port,
+		queue:    make(chan received, defaultQueueLength),
+		done:     make(chan struct{}),
+		deadline: make(chan struct{}),
+	}
+
+	m.sockets[port] = s
+
+	return s, nil
+}
+
+// Close removes handler of UDP and closes all sockets
+func (m *Mux) Close() {
+	m.mu.Lock()
+
+	if m.closed {
+		m.mu.Unlock()
+		return
+	}
+
+	m.closed = true
+	m.remove()
+
+	sockets := m.sockets
+	m.sockets = make(map[uint16]*UDPSocket)
+
+	m.mu.Unlock()
+
+	for _, s := range sockets {
+		s.closeQueue()
+	}
+}
+
+// Stats returns counters of dropped datagrams
+func (m *Mux) Stats() MuxStats {
+	return MuxStats{
+		Malformed:   m.malformed.Load(),
+		BadChecksum: m.badChecksum.Load(),
+		NotLocal:    m.notLocal.Load(),
+		NoPort:      m.noPort.Load(),
+		QueueFull:   m.queueFull.Load(),
+	}
+}
+
+// freePort must be called with m.mu locked
+func (m *Mux) freePort() (uint16, error) {
+	for i := 0; i <= ephemeralLast-ephemeralFirst; i++ {
+		port := m.next
+
+		if m.next == ephemeralLast {
+			m.next = ephemeralFirst
+		} else {
+			m.next++
+		}
+
+		if _, ok := m.sockets[port]; !ok {
+			return port, nil
+		}
+	}
+
+	return 0, ErrNoFreePort
+}
+
+func (m *Mux) unbind(s *UDPSocket) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.sockets[s.port] == s {
+		delete(m.sockets, s.port)
+	}
+}
+
+func (m *Mux) handle(p *ipv4.Packet) {
+	if p.Dst != m.local && !m.isBroadcast(p.Dst) && (p.Dst[0] < 224 || p.Dst[0] >= 240) {
+		m.notLocal.Add(1)
+		return
+	}
+
+	d := &Datagram{}
+
+	if err := d.Unmarshal(p.Data); err != nil {
+		m.malformed.Add(1)
+		return
+	}
+
+	if !d.VerifyChecksum(p.Src, p.Dst) {
+		m.badChecksum.Add(1)
+		return
+	}
+
+	m.mu.Lock()
+	s, ok := m.sockets[d.DstPort]
+	m.mu.Unlock()
+
+	if !ok {
+		m.noPort.Add(1)
+		return
+	}
+
+	select {
+	case s.queue <- received{from: Addr{IP: p.Src, Port: d.SrcPort}, data: d.Data}:
+	default:
+		m.queueFull.Add(1)
+	}
+}
+
+type received struct {
+	from Addr
+	data []byte
+}
+
+var _ net.PacketConn = (*UDPSocket)(nil)
+
+// UDPSocket is UDP socket bound to local port, it implements net.PacketConn
+type UDPSocket struct {
+	mux  *Mux
+	port uint16
+
+	queue     chan received
+	done      chan struct{}
+	closeOnce sync.Once
+
+	mu           sync.Mutex
+	readDeadline time.Time
+	deadline     chan struct{} // closed when read deadline changes
+}
+
+// ReadFrom waits datagram and copies its data to b, data not fitting b is
+// discarded
+func (s *UDPSocket) ReadFrom(b []byte) (int, net.Addr, error) {
+	for {
+		s.mu.Lock()
+		readDeadline, changed := s.readDeadline, s.deadline
+		s.mu.Unlock()
+
+		n, addr, done, err := s.read(b, readDeadline, changed)
+		if done {
+			return n, addr, err
+		}
+	}
+}
+
+// read waits datagram until deadline, it is not done when deadline is
+// changed while waiting
+func (s *UDPSocket) read(b []byte, deadline time.Time, changed <-chan struct{}) (int, net.Addr, bool, error) {
+	var expired <-chan time.Time
+
+	if !deadline.IsZero() {
+		timer := time.NewTimer(time.Until(deadline))
+		defer timer.Stop()
+
+		expired = timer.C
+	}
+
+	select {
+	case r := <-s.queue:
+		return copy(b, r.data), &r.from, true, nil
+	case <-s.done:
+		return 0, nil, true, net.ErrClosed
+	case <-expired:
+		return 0, nil, true, os.ErrDeadlineExceeded
+	case <-changed:
+		return 0, nil, false, nil
+	}
+}
+
+// WriteTo sends b to addr, which is *Addr or *net.UDPAddr with IPv4 address
+func (s *UDPSocket) WriteTo(b []byte, addr net.Addr) (int, error) {
+	select {
+	case <-s.done:
+		return 0, net.ErrClosed
+	default:
+	}
+
+	to, err := toAddr(addr)
+	if err != nil {
+		return 0, err
+	}
+
+	if len(b) > maxDataLength {
+		return 0, fmt.Errorf("%w: %d bytes of data", ErrBadLength, len(b))
+	}
+
+	sock := s.mux.d.Socket()
+
+	d := &Datagram{SrcPort: s.port, DstPort: to.Port, Data: b}
+
+	if err := sock.WritePacket(d.Packet(sock.GetIpAddr(), to.IP)); err != nil {
+		return 0, err
+	}
+
+	return len(b), nil
+}
+
+// Close unbinds socket from port and unblocks ReadFrom
+func (s *UDPSocket) Close() error {
+	s.mux.unbind(s)
+	s.closeQueue()
+
+	return nil
+}
+
+// LocalAddr returns address of interface and bound port
+func (s *UDPSocket) LocalAddr() net.Addr {
+	return &Addr{IP: s.mux.d.Socket().GetIpAddr(), Port: s.port}
+}
+
+// Port returns bound port
+func (s *UDPSocket) Port() uint16 {
+	return s.port
+}
+
+// SetDeadline sets up read deadline, writes never block
+func (s *UDPSocket) SetDeadline(t time.Time) error {
+	return s.SetReadDeadline(t)
+}
+
+// SetReadDeadline sets up time after which ReadFrom fails with
+// os.ErrDeadlineExceeded, zero value disables deadline
+func (s *UDPSocket) SetReadDeadline(t time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.readDeadline = t
+
+	close(s.deadline)
+	s.deadline = make(chan struct{})
+
+	return nil
+}
+
+// SetWriteDeadline does nothing, writes never block
+func (s *UDPSocket) SetWriteDeadline(t time.Time) error {
+	return nil
+}
+
+func (s *UDPSocket) closeQueue() {
+	s.closeOnce.Do(func() {
+		close(s.done)
+	})
+}
+
+func toAddr(addr net.Addr) (Addr, error) {
+	switch a := addr.(type) {
+	case *Addr:
+		return *a, nil
+	case *net.UDPAddr:
+		ip := a.IP.To4()
+		if ip == nil || a.Port < 0 || a.Port > 65535 {
+			return Addr{}, fmt.Errorf("%w: %v", ErrBadAddr, addr)
+		}
+
+		return Addr{IP: ipv4.IPAddr(ip), Port: uint16(a.Port)}, nil
+	}
+
+	return Addr{}, fmt.Errorf("%w: %v", ErrBadAddr, addr)
+}
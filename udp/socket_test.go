@@ -0,0 +1,203 @@
+package udp
+
+import (
+	"errors"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/IvMaslov/ipv4"
+)
+
+// isBroadcast reports broadcasts of socket on network 192.168.0.0/24
+func isBroadcast(dst ipv4.IPAddr) bool {
+	return dst == ipv4.IPAddr{255, 255, 255, 255} || dst == ipv4.IPAddr{192, 168, 0, 255}
+}
+
+func Test_Mux_Bind(t *testing.T) {
+	m := newMux(ipv4.NewDispatcher(nil), ipv4.IPAddr{192, 168, 0, 2}, isBroadcast)
+	defer m.Close()
+
+	s, err := m.Bind(5000)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := m.Bind(5000); !errors.Is(err, ErrPortInUse) {
+		t.Errorf("Error not expected %v", err)
+	}
+
+	ephemeral, err := m.Bind(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ephemeral.Port() < ephemeralFirst {
+		t.Errorf("Port not expected %d", ephemeral.Port())
+	}
+
+	s.Close()
+
+	if _, err := m.Bind(5000); err != nil {
+		t.Errorf("Port not released %v", err)
+	}
+}
+
+func Test_Mux_handle(t *testing.T) {
+	src, dst := addrs()
+	d := ipv4.NewDispatcher(nil)
+	m := newMux(d, dst, isBroadcast)
+	defer m.Close()
+
+	s, err := m.Bind(2000)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	valid := &Datagram{SrcPort: 1000, DstPort: 2000, Data: []byte{1, 2, 3}}
+	d.Dispatch(valid.Packet(src, dst))
+
+	noPort := &Datagram{SrcPort: 1000, DstPort: 2001}
+	d.Dispatch(noPort.Packet(src, dst))
+
+	badChecksum := valid.Packet(src, dst)
+	badChecksum.Data[len(badChecksum.Data)-1]++
+	d.Dispatch(badChecksum)
+
+	d.Dispatch(ipv4.New(src, dst, []byte{1, 2}).WithProtocol(ipv4.ProtocolUDP))
+
+	d.Dispatch(valid.Packet(src, ipv4.IPAddr{192, 168, 0, 3}))
+
+	buf := make([]byte, 2)
+
+	n, from, err := s.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n != 2 || buf[0] != 1 || buf[1] != 2 {
+		t.Errorf("Data not expected %v", buf[:n])
+	}
+
+	if a, ok := from.(*Addr); !ok || a.IP != src || a.Port != 1000 {
+		t.Errorf("Source not expected %v", from)
+	}
+
+	stats := m.Stats()
+	if stats != (MuxStats{Malformed: 1, BadChecksum: 1, NotLocal: 1, NoPort: 1}) {
+		t.Errorf("Stats not expected %v", stats)
+	}
+
+	for i := 0; i <= defaultQueueLength; i++ {
+		d.Dispatch(valid.Packet(src, dst))
+	}
+
+	if m.Stats().QueueFull != 1 {
+		t.Errorf("Queue overflow not counted %v", m.Stats())
+	}
+}
+
+func Test_Mux_handle_Destination(t *testing.T) {
+	src, dst := addrs()
+
+	tests := []struct {
+		name      string
+		dst       ipv4.IPAddr
+		delivered bool
+	}{
+		{name: "Local", dst: dst, delivered: true},
+		{name: "Limited broadcast", dst: ipv4.IPAddr{255, 255, 255, 255}, delivered: true},
+		{name: "Directed broadcast", dst: ipv4.IPAddr{192, 168, 0, 255}, delivered: true},
+		{name: "Broadcast of other network", dst: ipv4.IPAddr{10, 0, 0, 255}},
+		{name: "Multicast", dst: ipv4.IPAddr{224, 0, 0, 251}, delivered: true},
+		{name: "Other host", dst: ipv4.IPAddr{192, 168, 0, 3}},
+		{name: "Class E", dst: ipv4.IPAddr{240, 0, 0, 1}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			d := ipv4.NewDispatcher(nil)
+			m := newMux(d, dst, isBroadcast)
+			defer m.Close()
+
+			s, err := m.Bind(2000)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			datagram := &Datagram{SrcPort: 1000, DstPort: 2000, Data: []byte{1}}
+			d.Dispatch(datagram.Packet(src, test.dst))
+
+			if delivered := len(s.queue) == 1; delivered != test.delivered {
+				t.Errorf("Delivered not expected %v", delivered)
+			}
+		})
+	}
+}
+
+func Test_UDPSocket_ReadFrom(t *testing.T) {
+	m := newMux(ipv4.NewDispatcher(nil), ipv4.IPAddr{192, 168, 0, 2}, isBroadcast)
+
+	s, err := m.Bind(2000)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s.SetReadDeadline(time.Now().Add(10 * time.Millisecond))
+
+	if _, _, err := s.ReadFrom(nil); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("Error not expected %v", err)
+	}
+
+	s.SetReadDeadline(time.Time{})
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		m.Close()
+	}()
+
+	if _, _, err := s.ReadFrom(nil); !errors.Is(err, net.ErrClosed) {
+		t.Errorf("Error not expected %v", err)
+	}
+}
+
+func Test_toAddr(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    net.Addr
+		expected error
+	}{
+		{
+			name:  "Addr",
+			input: &Addr{IP: ipv4.IPAddr{10, 0, 0, 1}, Port: 53},
+		},
+		{
+			name:  "net.UDPAddr",
+			input: &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 53},
+		},
+		{
+			name:     "IPv6",
+			input:    &net.UDPAddr{IP: net.ParseIP("::1"), Port: 53},
+			expected: ErrBadAddr,
+		},
+		{
+			name:     "TCP",
+			input:    &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 53},
+			expected: ErrBadAddr,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			addr, err := toAddr(test.input)
+			if !errors.Is(err, test.expected) {
+				t.Fatalf("Error not expected %v", err)
+			}
+
+			if err == nil && (addr.IP != ipv4.IPAddr{10, 0, 0, 1} || addr.Port != 53) {
+				t.Errorf("Addr not expected %v", addr)
+			}
+		})
+	}
+}
@@ -0,0 +1,126 @@
+// Package udp implements UDP from RFC768 on top of ipv4.IpSocket
+package udp
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/IvMaslov/ipv4"
+)
+
+const headerLength = 8
+
+var (
+	ErrTruncated   = errors.New("udp datagram is truncated")
+	ErrBadLength   = errors.New("wrong udp length")
+	ErrBadChecksum = errors.New("wrong udp checksum")
+)
+
+// 0      7 8     15 16    23 24    31
+// +--------+--------+--------+--------+
+// |     Source      |   Destination   |
+// |      Port       |      Port       |
+// +--------+--------+--------+--------+
+// |                 |                 |
+// |     Length      |    Checksum     |
+// +--------+--------+--------+--------+
+// |
+// |          data octets ...
+// +---------------- ...
+//
+//	UDP datagram, zero checksum means it is not calculated by sender
+type Datagram struct {
+	SrcPort  uint16
+	DstPort  uint16
+	Length   uint16
+	Checksum uint16
+	Data     []byte
+}
+
+// Marshal returns wire representation of datagram sent from src to dst,
+// length and checksum over pseudo header are calculated, datagram is not
+// changed
+func (d *Datagram) Marshal(src, dst ipv4.IPAddr) []byte {
+	buf := d.marshal(0)
+
+	sum := Checksum(src, dst, buf)
+	if sum == 0 {
+		sum = 0xFFFF // zero is reserved for datagrams without checksum
+	}
+
+	binary.BigEndian.PutUint16(buf[6:8], sum)
+
+	return buf
+}
+
+func (d *Datagram) marshal(checksum uint16) []byte {
+	buf := make([]byte, headerLength+len(d.Data))
+
+	binary.BigEndian.PutUint16(buf[0:2], d.SrcPort)
+	binary.BigEndian.PutUint16(buf[2:4], d.DstPort)
+	binary.BigEndian.PutUint16(buf[4:6], uint16(len(buf)))
+	binary.BigEndian.PutUint16(buf[6:8], checksum)
+	copy(buf[8:], d.Data)
+
+	return buf
+}
+
+// Unmarshal parses datagram from b, bytes after Length are ignored
+func (d *Datagram) Unmarshal(b []byte) error {
+	if len(b) < headerLength {
+		return fmt.Errorf("%w: got %d bytes", ErrTruncated, len(b))
+	}
+
+	length := binary.BigEndian.Uint16(b[4:6])
+	if length < headerLength || int(length) > len(b) {
+		return fmt.Errorf("%w: %d for %d bytes", ErrBadLength, length, len(b))
+	}
+
+	d.SrcPort = binary.BigEndian.Uint16(b[0:2])
+	d.DstPort = binary.BigEndian.Uint16(b[2:4])
+	d.Length = length
+	d.Checksum = binary.BigEndian.Uint16(b[6:8])
+	d.Data = b[headerLength:length]
+
+	return nil
+}
+
+// VerifyChecksum reports whether checksum of datagram sent from src to dst
+// is correct, datagram without checksum is always correct
+func (d *Datagram) VerifyChecksum(src, dst ipv4.IPAddr) bool {
+	if d.Checksum == 0 {
+		return true
+	}
+
+	return Checksum(src, dst, d.marshal(d.Checksum)) == 0
+}
+
+// FromPacket parses UDP datagram carried by packet and verifies its checksum
+func FromPacket(p *ipv4.Packet) (*Datagram, error) {
+	if p.Protocol != ipv4.ProtocolUDP {
+		return nil, fmt.Errorf("packet of protocol %d is not udp", p.Protocol)
+	}
+
+	d := &Datagram{}
+
+	if err := d.Unmarshal(p.Data); err != nil {
+		return nil, err
+	}
+
+	if !d.VerifyChecksum(p.Src, p.Dst) {
+		return nil, ErrBadChecksum
+	}
+
+	return d, nil
+}
+
+// Packet wraps datagram to UDP packet from src to dst
+func (d *Datagram) Packet(src, dst ipv4.IPAddr) *ipv4.Packet {
+	return ipv4.New(src, dst, d.Marshal(src, dst)).WithProtocol(ipv4.ProtocolUDP)
+}
+
+// Checksum calculates checksum of UDP datagram b over IPv4 pseudo header
+func Checksum(src, dst ipv4.IPAddr, b []byte) uint16 {
+	return ipv4.PseudoHeaderChecksum(src, dst, ipv4.ProtocolUDP, b)
+}
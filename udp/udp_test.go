@@ -0,0 +1,139 @@
+package udp
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/IvMaslov/ipv4"
+)
+
+func addrs() (ipv4.IPAddr, ipv4.IPAddr) {
+	src, _ := ipv4.IPFromString("192.168.0.1")
+	dst, _ := ipv4.IPFromString("192.168.0.2")
+
+	return src, dst
+}
+
+func Test_Datagram_Marshal(t *testing.T) {
+	src, dst := addrs()
+	d := &Datagram{SrcPort: 1000, DstPort: 2000, Data: []byte{0x41, 0x42}}
+
+	data := d.Marshal(src, dst)
+	expected := []byte{0x03, 0xE8, 0x07, 0xD0, 0, 10, 0x31, 0x8C, 0x41, 0x42}
+
+	if !bytes.Equal(data, expected) {
+		t.Errorf("Marshaled not expected %v", data)
+	}
+
+	if d.Length != 0 || d.Checksum != 0 {
+		t.Errorf("Datagram changed %v", d)
+	}
+}
+
+func Test_Datagram_Unmarshal(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    []byte
+		expected error
+	}{
+		{
+			name:  "Datagram",
+			input: []byte{0x03, 0xE8, 0x07, 0xD0, 0, 10, 0x31, 0x8C, 0x41, 0x42},
+		},
+		{
+			name:  "Padded",
+			input: []byte{0x03, 0xE8, 0x07, 0xD0, 0, 10, 0x31, 0x8C, 0x41, 0x42, 0, 0},
+		},
+		{
+			name:     "Truncated",
+			input:    []byte{0x03, 0xE8, 0x07, 0xD0, 0},
+			expected: ErrTruncated,
+		},
+		{
+			name:     "Length exceeds data",
+			input:    []byte{0x03, 0xE8, 0x07, 0xD0, 0, 12, 0x31, 0x8C, 0x41, 0x42},
+			expected: ErrBadLength,
+		},
+		{
+			name:     "Length below header",
+			input:    []byte{0x03, 0xE8, 0x07, 0xD0, 0, 4, 0x31, 0x8C, 0x41, 0x42},
+			expected: ErrBadLength,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			d := &Datagram{}
+
+			err := d.Unmarshal(test.input)
+			if !errors.Is(err, test.expected) {
+				t.Fatalf("Error not expected %v", err)
+			}
+
+			if err == nil && (d.SrcPort != 1000 || d.DstPort != 2000 || !bytes.Equal(d.Data, []byte{0x41, 0x42})) {
+				t.Errorf("Datagram not expected %v", d)
+			}
+		})
+	}
+}
+
+func Test_Datagram_VerifyChecksum(t *testing.T) {
+	src, dst := addrs()
+
+	tests := []struct {
+		name     string
+		input    []byte
+		expected bool
+	}{
+		{
+			name:     "Correct",
+			input:    []byte{0x03, 0xE8, 0x07, 0xD0, 0, 10, 0x31, 0x8C, 0x41, 0x42},
+			expected: true,
+		},
+		{
+			name:     "No checksum",
+			input:    []byte{0x03, 0xE8, 0x07, 0xD0, 0, 10, 0, 0, 0x41, 0x42},
+			expected: true,
+		},
+		{
+			name:  "Wrong",
+			input: []byte{0x03, 0xE8, 0x07, 0xD0, 0, 10, 0x31, 0x8C, 0x41, 0x43},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			d := &Datagram{}
+			if err := d.Unmarshal(test.input); err != nil {
+				t.Fatal(err)
+			}
+
+			if d.VerifyChecksum(src, dst) != test.expected {
+				t.Errorf("Verification not expected %v", !test.expected)
+			}
+		})
+	}
+}
+
+func Test_FromPacket(t *testing.T) {
+	src, dst := addrs()
+	d := &Datagram{SrcPort: 1000, DstPort: 2000, Data: []byte{1, 2, 3}}
+
+	parsed, err := FromPacket(d.Packet(src, dst))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if parsed.SrcPort != 1000 || parsed.DstPort != 2000 || parsed.Length != 11 || !bytes.Equal(parsed.Data, d.Data) {
+		t.Errorf("Datagram not expected %v", parsed)
+	}
+
+	if _, err := FromPacket(ipv4.New(src, src, d.Marshal(src, dst)).WithProtocol(ipv4.ProtocolUDP)); !errors.Is(err, ErrBadChecksum) {
+		t.Errorf("Error not expected %v", err)
+	}
+
+	if _, err := FromPacket(ipv4.New(src, dst, d.Marshal(src, dst))); err == nil {
+		t.Error("Packet of other protocol parsed")
+	}
+}